@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/opsee/gmunch/health"
+	"github.com/opsee/gmunch/metrics"
+	log "github.com/opsee/logrus"
+)
+
+// Server is the optional plain HTTP listener gmunch servers and workers use
+// for operational endpoints that don't belong on the grpc port.
+type Server struct {
+	addr     string
+	mux      *http.ServeMux
+	listener net.Listener
+	mut      sync.Mutex
+	logger   *log.Entry
+}
+
+// New serves h's checks and the metrics on addr. A nil logger means the
+// standard logger.
+func New(addr string, h *health.Health, logger *log.Logger) *Server {
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	mux := http.NewServeMux()
+	h.Register(mux)
+	mux.Handle("/metrics", metrics.Handler())
+
+	return &Server{
+		addr:   addr,
+		mux:    mux,
+		logger: logger.WithField("admin", addr),
+	}
+}
+
+// Handle mounts an additional handler on the admin listener.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start listens on the admin address and blocks until Stop is called or the
+// listener fails.
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	s.listener = lis
+	s.mut.Unlock()
+
+	s.logger.Info("starting")
+	return http.Serve(lis, s.mux)
+}
+
+func (s *Server) Stop() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+		s.logger.Info("stopped")
+	}
+}
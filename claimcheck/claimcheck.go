@@ -0,0 +1,169 @@
+// Package claimcheck moves event data that's too big for the stream into a
+// blob store. The producer stores the data and sends the event with a
+// reference to it in place of Data, and the consumer fetches the data back
+// before dispatch.
+package claimcheck
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/metrics"
+	log "github.com/opsee/logrus"
+)
+
+// HeaderClaimCheck is the event header holding the store key of data that
+// was offloaded.
+const HeaderClaimCheck = "claim-check"
+
+// DefaultThreshold is the largest event data to send inline, in bytes. It
+// leaves room under the 1MB kinesis record limit for the rest of the event.
+const DefaultThreshold = 512 * 1024
+
+// ErrNotFound is returned by stores for keys they don't have, including
+// blobs that have expired.
+var ErrNotFound = errors.New("claimcheck: blob not found")
+
+// A Store holds offloaded event data. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// checkKey rejects keys that could reach outside a store's directory or
+// prefix. Keys come from the event's header, so they can't be trusted.
+func checkKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return fmt.Errorf("claimcheck: invalid key %q", key)
+	}
+
+	return nil
+}
+
+// An Expirer is a store that can remove blobs stored before a time. Stores
+// that expire blobs on their own, like an S3 bucket with a lifecycle rule,
+// needn't implement it.
+type Expirer interface {
+	Expire(before time.Time) (int, error)
+}
+
+// Offload stores the event's data if there's more than threshold bytes of
+// it, replacing Data with nothing and the claim-check header. Events that
+// have already been offloaded are left alone.
+func Offload(store Store, event *gmunch.Event, threshold int) error {
+	if len(event.Data) <= threshold || event.Header(HeaderClaimCheck) != "" {
+		return nil
+	}
+
+	key := gmunch.NewEventID()
+	if err := store.Put(key, event.Data); err != nil {
+		offloadErrors.With(metrics.EventLabel(event.Name)).Inc()
+		return fmt.Errorf("offloading event data: %s", err)
+	}
+
+	offloadedEvents.With(metrics.EventLabel(event.Name)).Inc()
+	offloadedBytes.With(metrics.EventLabel(event.Name)).Add(float64(len(event.Data)))
+
+	event.Data = nil
+	event.SetHeader(HeaderClaimCheck, key)
+	return nil
+}
+
+// Inline fetches the data of an offloaded event back into Data and removes
+// the claim-check header. It does nothing to events that weren't offloaded.
+// The blob is left in the store, since the event may be read again by a
+// replay; it's removed when it expires.
+func Inline(store Store, event *gmunch.Event) error {
+	key := event.Header(HeaderClaimCheck)
+	if key == "" {
+		return nil
+	}
+
+	if store == nil {
+		return fmt.Errorf("event data was offloaded to %s, but there's no claim check store", key)
+	}
+
+	data, err := store.Get(key)
+	if err != nil {
+		inlineErrors.With(metrics.EventLabel(event.Name)).Inc()
+		return fmt.Errorf("fetching offloaded event data %s: %s", key, err)
+	}
+
+	event.Data = data
+	delete(event.Headers, HeaderClaimCheck)
+	return nil
+}
+
+// A Janitor periodically expires old blobs from a store. The TTL should
+// comfortably outlast the stream's retention, so that replays still find
+// their data.
+type Janitor struct {
+	store    Expirer
+	ttl      time.Duration
+	interval time.Duration
+	logger   *log.Entry
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// NewJanitor expires blobs older than ttl from store every interval. A nil
+// logger means the standard logger.
+func NewJanitor(store Expirer, ttl, interval time.Duration, logger *log.Logger) *Janitor {
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	return &Janitor{
+		store:    store,
+		ttl:      ttl,
+		interval: interval,
+		logger:   logger.WithField("ttl", ttl),
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+// Start runs the janitor in the background until Stop is called.
+func (j *Janitor) Start() {
+	go func() {
+		defer close(j.doneChan)
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			j.Run()
+
+			select {
+			case <-ticker.C:
+			case <-j.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Run expires old blobs once, returning how many were removed.
+func (j *Janitor) Run() int {
+	n, err := j.store.Expire(time.Now().Add(-j.ttl))
+	if err != nil {
+		j.logger.WithError(err).Error("couldn't expire claim check blobs")
+	}
+
+	if n > 0 {
+		j.logger.WithField("expired", n).Info("expired claim check blobs")
+		expiredBlobs.With().Add(float64(n))
+	}
+
+	return n
+}
+
+func (j *Janitor) Stop() {
+	close(j.stopChan)
+	<-j.doneChan
+}
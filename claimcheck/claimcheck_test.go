@@ -0,0 +1,154 @@
+package claimcheck
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opsee/gmunch"
+	log "github.com/opsee/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffloadInline(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryStore()
+	data := bytes.Repeat([]byte("worms"), 100)
+
+	// small enough to send as is
+	event := &gmunch.Event{Name: "test_event", Data: data}
+	assert.NoError(Offload(store, event, len(data)))
+	assert.Equal(data, event.Data)
+	assert.Equal(0, store.Len())
+
+	assert.NoError(Offload(store, event, 10))
+	assert.Empty(event.Data)
+	assert.NotEqual("", event.Header(HeaderClaimCheck))
+	assert.Equal(1, store.Len())
+
+	// offloading twice is harmless
+	assert.NoError(Offload(store, event, 10))
+	assert.Equal(1, store.Len())
+
+	assert.Error(Inline(nil, event))
+	assert.NoError(Inline(store, event))
+	assert.Equal(data, event.Data)
+	assert.Equal("", event.Header(HeaderClaimCheck))
+
+	// and inlining an inline event does nothing
+	assert.NoError(Inline(store, event))
+	assert.Equal(data, event.Data)
+
+	event.SetHeader(HeaderClaimCheck, "missing")
+	assert.Error(Inline(store, event))
+}
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "claimcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(store.Put("old", []byte("old data")))
+	assert.NoError(store.Put("new", []byte("new data")))
+	assert.Error(store.Put("../escape", []byte("nope")))
+
+	data, err := store.Get("new")
+	assert.NoError(err)
+	assert.Equal([]byte("new data"), data)
+
+	_, err = store.Get("missing")
+	assert.Equal(ErrNotFound, err)
+
+	hourAgo := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "blobs", "old"), hourAgo, hourAgo)
+
+	n, err := store.Expire(time.Now().Add(-time.Minute))
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	_, err = store.Get("old")
+	assert.Equal(ErrNotFound, err)
+
+	assert.NoError(store.Delete("new"))
+	assert.NoError(store.Delete("new"))
+	_, err = store.Get("new")
+	assert.Equal(ErrNotFound, err)
+}
+
+type fakeS3 struct {
+	objects map[string][]byte
+}
+
+func (s *fakeS3) PutObject(bucket, key string, data []byte) error {
+	s.objects[bucket+":"+key] = data
+	return nil
+}
+
+func (s *fakeS3) GetObject(bucket, key string) ([]byte, error) {
+	data, ok := s.objects[bucket+":"+key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return data, nil
+}
+
+func (s *fakeS3) DeleteObject(bucket, key string) error {
+	delete(s.objects, bucket+":"+key)
+	return nil
+}
+
+func TestS3Store(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &fakeS3{objects: make(map[string][]byte)}
+	store := NewS3Store(client, "bucket", "gmunch/claims")
+
+	event := &gmunch.Event{Name: "test_event", Data: []byte("worms")}
+	assert.NoError(Offload(store, event, 0))
+	assert.Contains(client.objects, "bucket:gmunch/claims/"+event.Header(HeaderClaimCheck))
+
+	assert.NoError(Inline(store, event))
+	assert.Equal([]byte("worms"), event.Data)
+
+	// keys come from the event, so they mustn't reach outside the prefix
+	for _, key := range []string{"../x", "a/../../x", "..", ""} {
+		assert.Error(store.Put(key, []byte("nope")), key)
+		_, err := store.Get(key)
+		assert.Error(err, key)
+		assert.Error(store.Delete(key), key)
+	}
+	assert.Len(client.objects, 1)
+}
+
+func TestJanitor(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	store := NewMemoryStore()
+	store.Put("key", []byte("worms"))
+
+	j := NewJanitor(store, time.Hour, time.Millisecond, logger)
+	assert.Equal(0, j.Run())
+
+	j = NewJanitor(store, 0, time.Millisecond, logger)
+	j.Start()
+	time.Sleep(10 * time.Millisecond)
+	j.Stop()
+	assert.Equal(0, store.Len())
+}
@@ -0,0 +1,70 @@
+package claimcheck
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryBlob struct {
+	data   []byte
+	stored time.Time
+}
+
+// MemoryStore keeps blobs in memory. It's handy for tests, and for setups
+// where the producer and consumer share a process.
+type MemoryStore struct {
+	blobs map[string]memoryBlob
+	mut   sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blobs: make(map[string]memoryBlob)}
+}
+
+func (s *MemoryStore) Put(key string, data []byte) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.blobs[key] = memoryBlob{data: append([]byte(nil), data...), stored: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	blob, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), blob.data...), nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *MemoryStore) Expire(before time.Time) (int, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	n := 0
+	for key, blob := range s.blobs {
+		if blob.stored.Before(before) {
+			delete(s.blobs, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Len returns the number of blobs stored.
+func (s *MemoryStore) Len() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return len(s.blobs)
+}
@@ -0,0 +1,36 @@
+package claimcheck
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	offloadedEvents = metrics.NewCounterVec(
+		"gmunch_claimcheck_offloaded_events_total",
+		"Events whose data was moved to the claim check store, by event name.",
+		"name",
+	)
+
+	offloadedBytes = metrics.NewCounterVec(
+		"gmunch_claimcheck_offloaded_bytes_total",
+		"Bytes of event data moved to the claim check store, by event name.",
+		"name",
+	)
+
+	offloadErrors = metrics.NewCounterVec(
+		"gmunch_claimcheck_offload_errors_total",
+		"Failures storing event data in the claim check store, by event name.",
+		"name",
+	)
+
+	inlineErrors = metrics.NewCounterVec(
+		"gmunch_claimcheck_inline_errors_total",
+		"Failures fetching offloaded event data back, by event name.",
+		"name",
+	)
+
+	expiredBlobs = metrics.NewCounterVec(
+		"gmunch_claimcheck_expired_blobs_total",
+		"Blobs removed from the claim check store by a janitor.",
+	)
+)
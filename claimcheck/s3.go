@@ -0,0 +1,67 @@
+package claimcheck
+
+import (
+	"path"
+)
+
+// S3API is the part of an S3 client that S3Store uses. It's small so that
+// the aws-sdk-go s3 client, or a client for any S3-compatible store like
+// minio or ceph, is easy to adapt to it. GetObject must return ErrNotFound
+// for missing keys.
+type S3API interface {
+	PutObject(bucket, key string, data []byte) error
+	GetObject(bucket, key string) ([]byte, error)
+	DeleteObject(bucket, key string) error
+}
+
+// S3Store keeps blobs in an S3 bucket under a key prefix. It doesn't
+// implement Expirer: give the bucket a lifecycle rule expiring objects under
+// the prefix instead.
+type S3Store struct {
+	client S3API
+	bucket string
+	prefix string
+}
+
+func NewS3Store(client S3API, bucket, prefix string) *S3Store {
+	return &S3Store{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+func (s *S3Store) key(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+
+	return path.Join(s.prefix, key), nil
+}
+
+func (s *S3Store) Put(key string, data []byte) error {
+	key, err := s.key(key)
+	if err != nil {
+		return err
+	}
+
+	return s.client.PutObject(s.bucket, key, data)
+}
+
+func (s *S3Store) Get(key string) ([]byte, error) {
+	key, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.client.GetObject(s.bucket, key)
+}
+
+func (s *S3Store) Delete(key string) error {
+	key, err := s.key(key)
+	if err != nil {
+		return err
+	}
+
+	return s.client.DeleteObject(s.bucket, key)
+}
@@ -2,8 +2,12 @@ package client
 
 import (
 	"crypto/tls"
+	"time"
 
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/envelope"
+	"github.com/opsee/gmunch/signing"
+	"github.com/opsee/gmunch/trace"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -14,17 +18,47 @@ type Client interface {
 	// the event type so that your worker process can subscribe handlers for that event.
 	// Names do not have to be globally unique--simply unique per gmunch instance (one or
 	// more gmunch Servers that use the same configuration).
-	Send(name string, data interface{}) error
+	Send(name string, data interface{}, opts ...SendOption) error
+
+	// SendContext() is Send() with a caller-supplied context. If the context carries
+	// a trace, the event carries it along to the tasks that eventually handle it.
+	SendContext(ctx context.Context, name string, data interface{}, opts ...SendOption) error
+}
+
+// A SendOption sets something on an event before it's sent.
+type SendOption func(*gmunch.Event)
+
+// DeliverAt has the event handled no earlier than t, by workers with a
+// delay store.
+func DeliverAt(t time.Time) SendOption {
+	return func(event *gmunch.Event) {
+		event.SetDeliveryTime(t)
+	}
+}
+
+// Delay has the event handled no earlier than d from now, by workers with a
+// delay store.
+func Delay(d time.Duration) SendOption {
+	return DeliverAt(time.Now().Add(d))
 }
 
 // ClientConfig objects are used to configure the transport's client.
 type Config struct {
 	// TLSConfig must be provided.
 	TLSConfig tls.Config
+
+	// Encrypter, if set, encrypts the data of the events it's configured
+	// for before they leave the client.
+	Encrypter *envelope.Encrypter
+
+	// SigningKey, if set, signs every event sent, after encryption.
+	SigningKey *signing.Key
 }
 
 type client struct {
 	grpcClient gmunch.EventsClient
+	encrypter  *envelope.Encrypter
+	signingKey *signing.Key
 }
 
 func New(addr string, config Config) (Client, error) {
@@ -33,18 +67,54 @@ func New(addr string, config Config) (Client, error) {
 		return nil, err
 	}
 
-	return &client{gmunch.NewEventsClient(conn)}, nil
+	return &client{
+		grpcClient: gmunch.NewEventsClient(conn),
+		encrypter:  config.Encrypter,
+		signingKey: config.SigningKey,
+	}, nil
+}
+
+func (c *client) Send(name string, data interface{}, opts ...SendOption) error {
+	return c.SendContext(context.Background(), name, data, opts...)
 }
 
-func (c *client) Send(name string, data interface{}) error {
-	event := &gmunch.Event{Name: name}
+func (c *client) SendContext(ctx context.Context, name string, data interface{}, opts ...SendOption) error {
+	event := &gmunch.Event{
+		Name: name,
+		Id:   gmunch.NewEventID(),
+	}
+
+	for _, opt := range opts {
+		opt(event)
+	}
 
 	err := event.EncodeData(data)
 	if err != nil {
 		return err
 	}
 
-	_, err = c.grpcClient.Publish(context.Background(), event)
+	if c.encrypter != nil {
+		if err := c.encrypter.Encrypt(event); err != nil {
+			return err
+		}
+	}
+
+	if c.signingKey != nil {
+		if err := signing.Sign(event, c.signingKey); err != nil {
+			return err
+		}
+	}
+
+	span, ctx := trace.StartSpan(ctx, "gmunch.publish")
+	span.SetAttribute("name", name)
+	defer span.Finish()
+
+	trace.Inject(ctx, event)
+
+	_, err = c.grpcClient.Publish(ctx, event)
+	if err != nil {
+		span.SetError(err)
+	}
 
 	return err
 }
@@ -0,0 +1,131 @@
+package gmunch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/mreiferson/go-snappystream"
+)
+
+// HeaderContentEncoding is the event header that says how Data is
+// compressed. Events without it aren't compressed.
+const HeaderContentEncoding = "content-encoding"
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingSnappy = "snappy"
+)
+
+// DefaultCompressionThreshold is the smallest Data worth compressing, in
+// bytes. Below it the headers and framing cost more than we'd save.
+const DefaultCompressionThreshold = 1024
+
+// ValidEncoding returns an error unless encoding is one we can compress
+// with.
+func ValidEncoding(encoding string) error {
+	switch encoding {
+	case EncodingGzip, EncodingSnappy:
+		return nil
+	default:
+		return fmt.Errorf("unknown content encoding: %q", encoding)
+	}
+}
+
+// Compress compresses Data with the given encoding and marks the event so
+// that consumers decompress it. Events with less than threshold bytes of
+// Data, events that are already compressed, and events that compression
+// wouldn't shrink are left alone.
+func (event *Event) Compress(encoding string, threshold int) error {
+	if err := ValidEncoding(encoding); err != nil {
+		return err
+	}
+
+	if len(event.Data) < threshold || event.Header(HeaderContentEncoding) != "" {
+		return nil
+	}
+
+	var b bytes.Buffer
+	var w io.Writer
+	var gz *gzip.Writer
+
+	if encoding == EncodingGzip {
+		gz = gzip.NewWriter(&b)
+		w = gz
+	} else {
+		w = snappystream.NewWriter(&b)
+	}
+
+	if _, err := w.Write(event.Data); err != nil {
+		return err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	if b.Len() >= len(event.Data) {
+		return nil
+	}
+
+	event.Data = b.Bytes()
+	event.SetHeader(HeaderContentEncoding, encoding)
+	return nil
+}
+
+// Decompress undoes Compress, leaving Data as it was encoded and removing
+// the content-encoding header. It does nothing to uncompressed events.
+func (event *Event) Decompress() error {
+	encoding := event.Header(HeaderContentEncoding)
+	if encoding == "" {
+		return nil
+	}
+
+	r, err := decompressor(encoding, event.Data)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("decompressing %s event data: %s", encoding, err)
+	}
+
+	event.Data = data
+	delete(event.Headers, HeaderContentEncoding)
+	return nil
+}
+
+// dataReader returns a reader of the event's uncompressed Data.
+func (event *Event) dataReader() (io.Reader, error) {
+	encoding := event.Header(HeaderContentEncoding)
+	if encoding == "" {
+		return bytes.NewReader(event.Data), nil
+	}
+
+	return decompressor(encoding, event.Data)
+}
+
+func decompressor(encoding string, data []byte) (io.Reader, error) {
+	switch encoding {
+	case EncodingGzip:
+		return gzip.NewReader(bytes.NewReader(data))
+	case EncodingSnappy:
+		return snappystream.NewReader(bytes.NewReader(data), snappystream.VerifyChecksum), nil
+	default:
+		return nil, fmt.Errorf("unknown content encoding: %q", encoding)
+	}
+}
+
+// errDecoder is the Decoder for data we couldn't even start reading.
+type errDecoder struct {
+	err error
+}
+
+func (d errDecoder) Decode(interface{}) error {
+	return d.err
+}
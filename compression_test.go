@@ -0,0 +1,77 @@
+package gmunch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, encoding := range []string{EncodingGzip, EncodingSnappy} {
+		data := bytes.Repeat([]byte("worms "), 1000)
+		event := &Event{Name: "cool", Data: data}
+		assert.NoError(event.Compress(encoding, DefaultCompressionThreshold))
+		assert.Equal(encoding, event.Header(HeaderContentEncoding))
+		assert.True(len(event.Data) < len(data))
+
+		// compressing twice is harmless
+		compressed := event.Data
+		assert.NoError(event.Compress(encoding, DefaultCompressionThreshold))
+		assert.Equal(compressed, event.Data)
+
+		pbdata, err := proto.Marshal(event)
+		assert.NoError(err)
+		event = &Event{}
+		assert.NoError(proto.Unmarshal(pbdata, event))
+
+		assert.NoError(event.Decompress())
+		assert.Equal(data, event.Data)
+		assert.Equal("", event.Header(HeaderContentEncoding))
+	}
+}
+
+func TestCompressSkipped(t *testing.T) {
+	assert := assert.New(t)
+
+	// under the threshold
+	event := &Event{Name: "cool", Data: bytes.Repeat([]byte("a"), 100)}
+	assert.NoError(event.Compress(EncodingGzip, 1000))
+	assert.Len(event.Data, 100)
+	assert.Equal("", event.Header(HeaderContentEncoding))
+
+	// compression wouldn't help
+	event = &Event{Name: "cool", Data: []byte("a")}
+	assert.NoError(event.Compress(EncodingGzip, 0))
+	assert.Equal([]byte("a"), event.Data)
+	assert.Equal("", event.Header(HeaderContentEncoding))
+
+	assert.Error(event.Compress("lzma", 0))
+	assert.NoError(event.Decompress())
+}
+
+func TestCompressedDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	customerID := strings.Repeat("custy-asdf", 100)
+	event := &Event{Name: "cool"}
+	event.EncodeData(&coolEventData{
+		CustomerId:  customerID,
+		Permissions: []string{"read", "write", "admin"},
+	})
+	assert.NoError(event.Compress(EncodingSnappy, 0))
+	assert.Equal(EncodingSnappy, event.Header(HeaderContentEncoding))
+
+	coolData := &coolEventData{}
+	assert.NoError(event.Decoder().Decode(coolData))
+	assert.Equal(customerID, coolData.CustomerId)
+	assert.Equal([]string{"read", "write", "admin"}, coolData.Permissions)
+
+	event.SetHeader(HeaderContentEncoding, EncodingGzip)
+	assert.Error(event.Decoder().Decode(coolData))
+	assert.Error(event.Decompress())
+}
@@ -0,0 +1,85 @@
+package kinesis
+
+import (
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// A Checkpointer stores how far the consumer has read each shard, keyed by
+// shard path. Implementations must be safe for concurrent use.
+type Checkpointer interface {
+	// Checkpoint returns the stored sequence number for key, or "" if
+	// there isn't one.
+	Checkpoint(key string) (string, error)
+
+	SetCheckpoint(key, sequence string) error
+}
+
+// EtcdCheckpointer keeps checkpoints in etcd.
+type EtcdCheckpointer struct {
+	keys etcd.KeysAPI
+}
+
+func NewEtcdCheckpointer(endpoints []string) (*EtcdCheckpointer, error) {
+	client, err := etcd.New(etcd.Config{
+		Endpoints:               endpoints,
+		Transport:               etcd.DefaultTransport,
+		HeaderTimeoutPerRequest: time.Second,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdCheckpointer{keys: etcd.NewKeysAPI(client)}, nil
+}
+
+func (e *EtcdCheckpointer) Checkpoint(key string) (string, error) {
+	response, err := e.keys.Get(context.Background(), key, &etcd.GetOptions{
+		Quorum: true,
+	})
+
+	if err != nil {
+		if etcdErr, ok := err.(etcd.Error); ok && etcdErr.Code == etcd.ErrorCodeKeyNotFound {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return response.Node.Value, nil
+}
+
+func (e *EtcdCheckpointer) SetCheckpoint(key, sequence string) error {
+	_, err := e.keys.Set(context.Background(), key, sequence, &etcd.SetOptions{})
+	return err
+}
+
+// MemoryCheckpointer keeps checkpoints in memory. It's meant for tests, or
+// consumers that are happy to start over every time they start.
+type MemoryCheckpointer struct {
+	checkpoints map[string]string
+	mut         sync.Mutex
+}
+
+func NewMemoryCheckpointer() *MemoryCheckpointer {
+	return &MemoryCheckpointer{
+		checkpoints: make(map[string]string),
+	}
+}
+
+func (m *MemoryCheckpointer) Checkpoint(key string) (string, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.checkpoints[key], nil
+}
+
+func (m *MemoryCheckpointer) SetCheckpoint(key, sequence string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.checkpoints[key] = sequence
+	return nil
+}
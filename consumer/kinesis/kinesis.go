@@ -10,16 +10,23 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/cenkalti/backoff"
-	etcd "github.com/coreos/etcd/client"
 	"github.com/golang/protobuf/proto"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/claimcheck"
+	"github.com/opsee/gmunch/deadletter"
+	"github.com/opsee/gmunch/kpl"
+	"github.com/opsee/gmunch/trace"
 	log "github.com/opsee/logrus"
 	"golang.org/x/net/context"
 )
 
 const (
 	flushIntervalDuration = 10 * time.Second
-	sleepDuration         = 500 * time.Millisecond
+
+	// how long we can go without a successful read or checkpoint before
+	// we consider ourselves unhealthy
+	staleReadDuration       = 5 * time.Minute
+	staleCheckpointDuration = 3 * flushIntervalDuration
 )
 
 type kinesisConsumer struct {
@@ -27,16 +34,40 @@ type kinesisConsumer struct {
 	shardId       *string
 	shardPath     string
 	etcdEndpoints []string
-	etcd          etcd.KeysAPI
+	checkpoints   Checkpointer
 	iterator      *string
 	iteratorMut   sync.Mutex
 	sequence      *string
-	client        *kinesis.Kinesis
+	client        kinesisAPI
+	batchSize     int64
+	poller        *poller
 	stopChan      chan struct{}
 	stoppedChan   chan struct{}
+	doneChan      chan struct{}
 	stopping      bool
 	eventChan     chan *gmunch.Event
 	logger        *log.Entry
+	deadLetter    deadletter.Sink
+	claimCheck    claimcheck.Store
+	replay        *Replay
+
+	initialPosition  InitialPosition
+	initialTimestamp time.Time
+	onExpired        ExpiredCheckpointPolicy
+
+	maxLag         time.Duration
+	healthMut      sync.Mutex
+	lastRead       time.Time
+	millisBehind   int64
+	lastCheckpoint time.Time
+	checkpointErr  error
+}
+
+// kinesisAPI is the part of the kinesis client we use.
+type kinesisAPI interface {
+	DescribeStream(*kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error)
+	GetShardIterator(*kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error)
+	GetRecords(*kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error)
 }
 
 type Config struct {
@@ -44,18 +75,119 @@ type Config struct {
 	EtcdEndpoints []string
 	ShardPath     string
 	Region        string
+
+	// Checkpointer stores the consumer's position. Defaults to etcd at
+	// EtcdEndpoints.
+	Checkpointer Checkpointer
+
+	// AWSConfig, if set, is used for the kinesis client instead of the
+	// default config for Region, e.g. to point it at a local kinesis.
+	AWSConfig *aws.Config
+
+	// MaxLag is how far behind the tip of the stream the consumer may fall
+	// before it reports itself unhealthy. Zero disables the lag check.
+	MaxLag time.Duration
+
+	// Logger is used for all of the consumer's logging. Defaults to the
+	// standard logger.
+	Logger *log.Logger
+
+	// DeadLetter, if set, receives records that can't be decoded.
+	DeadLetter deadletter.Sink
+
+	// ClaimCheck is where the producer offloaded event data too big to
+	// send. Offloaded events are dead lettered without one, or if their
+	// data can't be fetched.
+	ClaimCheck claimcheck.Store
+
+	// BatchSize is the most records to read per call, up to 10000.
+	// Defaults to 1000.
+	BatchSize int64
+
+	// MinPollInterval is the shortest time between reads. Kinesis allows
+	// 5 reads per second per shard, shared between everything reading
+	// it, so this defaults to 200ms.
+	MinPollInterval time.Duration
+
+	// MaxPollInterval is the longest the consumer will wait between
+	// reads while it's caught up with the stream or being throttled.
+	// Defaults to 2s.
+	MaxPollInterval time.Duration
+
+	// InitialPosition is where to start reading when there's no
+	// checkpoint. Defaults to StartTrimHorizon.
+	InitialPosition InitialPosition
+
+	// InitialTimestamp is the time to start from with StartAtTimestamp.
+	InitialTimestamp time.Time
+
+	// OnExpiredCheckpoint says what to do when the checkpoint is older
+	// than anything left on the stream. Defaults to FallBackOnExpired.
+	OnExpiredCheckpoint ExpiredCheckpointPolicy
+
+	// Replay, if set, re-reads a window of the stream rather than
+	// consuming from the live checkpoint.
+	Replay *Replay
 }
 
 func New(config Config) *kinesisConsumer {
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+
+	if config.BatchSize > maxBatchSize {
+		config.BatchSize = maxBatchSize
+	}
+
+	if config.MinPollInterval <= 0 {
+		config.MinPollInterval = defaultMinPollInterval
+	}
+
+	if config.MaxPollInterval < config.MinPollInterval {
+		config.MaxPollInterval = defaultMaxPollInterval
+		if config.MaxPollInterval < config.MinPollInterval {
+			config.MaxPollInterval = config.MinPollInterval
+		}
+	}
+
+	if config.InitialPosition == "" {
+		config.InitialPosition = StartTrimHorizon
+	}
+
+	if config.AWSConfig == nil {
+		config.AWSConfig = aws.NewConfig().WithRegion(config.Region)
+	}
+
+	logger := config.Logger.WithFields(log.Fields{"consumer": "kinesis", "stream": config.Stream})
+	if config.Replay != nil {
+		logger = logger.WithField("replay", config.Replay.Name)
+	}
+
 	return &kinesisConsumer{
 		stream:        config.Stream,
 		etcdEndpoints: config.EtcdEndpoints,
-		client:        kinesis.New(session.New(aws.NewConfig().WithRegion(config.Region))),
+		checkpoints:   config.Checkpointer,
+		client:        kinesis.New(session.New(config.AWSConfig)),
+		batchSize:     config.BatchSize,
+		poller:        newPoller(config.MinPollInterval, config.MaxPollInterval),
 		stopChan:      make(chan struct{}, 1),
 		stoppedChan:   make(chan struct{}, 1),
+		doneChan:      make(chan struct{}),
 		eventChan:     make(chan *gmunch.Event),
 		shardPath:     config.ShardPath,
-		logger:        log.WithField("consumer", "kinesis"),
+		logger:        logger,
+		maxLag:        config.MaxLag,
+		deadLetter:    config.DeadLetter,
+		claimCheck:    config.ClaimCheck,
+		replay:        config.Replay,
+
+		initialPosition:  config.InitialPosition,
+		initialTimestamp: config.InitialTimestamp,
+		onExpired:        config.OnExpiredCheckpoint,
 	}
 }
 
@@ -63,17 +195,22 @@ func (c *kinesisConsumer) Start() error {
 	var err error
 	c.logger.Info("starting")
 
-	etcdClient, err := etcd.New(etcd.Config{
-		Endpoints:               c.etcdEndpoints,
-		Transport:               etcd.DefaultTransport,
-		HeaderTimeoutPerRequest: time.Second,
-	})
-
-	if err != nil {
+	if err = c.initialPosition.validate(c.initialTimestamp); err != nil {
 		return err
 	}
 
-	c.etcd = etcd.NewKeysAPI(etcdClient)
+	if c.replay != nil {
+		if err = c.replay.init(); err != nil {
+			return err
+		}
+	}
+
+	if c.checkpoints == nil {
+		c.checkpoints, err = NewEtcdCheckpointer(c.etcdEndpoints)
+		if err != nil {
+			return err
+		}
+	}
 
 	out, err := c.client.DescribeStream(&kinesis.DescribeStreamInput{
 		StreamName: aws.String(c.stream),
@@ -96,6 +233,7 @@ func (c *kinesisConsumer) Start() error {
 	if c.shardId == nil {
 		return fmt.Errorf("no shard id found")
 	}
+	c.logger = c.logger.WithField("shard", aws.StringValue(c.shardId))
 
 	err = c.getIterator()
 	if err != nil {
@@ -145,6 +283,7 @@ func (c *kinesisConsumer) run() {
 			out *kinesis.GetRecordsOutput
 		)
 
+		readStart := time.Now()
 		backoff.Retry(func() error {
 			if c.shouldStop() {
 				return nil
@@ -154,14 +293,37 @@ func (c *kinesisConsumer) run() {
 
 			out, err = c.client.GetRecords(&kinesis.GetRecordsInput{
 				ShardIterator: c.iterator,
-				Limit:         aws.Int64(1),
+				Limit:         aws.Int64(c.batchSize),
 			})
 
-			if err != nil {
+			switch {
+			case err == nil:
+			case isThrottled(err):
+				c.logger.Warn("read throttled")
+				throttles.With(c.stream, aws.StringValue(c.shardId)).Inc()
+				c.poller.throttled()
+				return err
+			case isExpiredIterator(err):
+				c.logger.Warn("shard iterator expired")
+				expiredIterators.With(c.stream, aws.StringValue(c.shardId)).Inc()
+				if iterErr := c.reacquireIterator(); iterErr != nil {
+					return iterErr
+				}
+				return err
+			default:
 				c.logger.WithError(err).Error("AWS error")
 				return err
 			}
 
+			c.healthMut.Lock()
+			c.lastRead = time.Now()
+			c.millisBehind = aws.Int64Value(out.MillisBehindLatest)
+			c.healthMut.Unlock()
+
+			shard := aws.StringValue(c.shardId)
+			recordsRead.With(c.stream, shard).Add(float64(len(out.Records)))
+			millisBehindLatest.With(c.stream, shard).Set(float64(aws.Int64Value(out.MillisBehindLatest)))
+
 			return nil
 
 		}, &backoff.ExponentialBackOff{
@@ -184,22 +346,39 @@ func (c *kinesisConsumer) run() {
 		}
 
 		for _, rec := range out.Records {
-			event := &gmunch.Event{}
-			err = proto.Unmarshal(rec.Data, event)
-
-			// ignore unmarshaling errors, if you can't send the right kind of data, then
-			// continue incrementing the sequence and to heck with you
-			if err != nil {
-				c.logger.WithError(err).Error("proto unmarshal error")
-				continue
+			if c.replay != nil && c.replay.past(rec) {
+				c.logger.WithField("sequence", aws.StringValue(rec.SequenceNumber)).Info("replay reached its end")
+				goto SHUTDOWN
 			}
 
-			if c.shouldStop() {
-				goto SHUTDOWN
+			// KPL aggregated records hold many events, anything else is
+			// just the one
+			payloads := [][]byte{rec.Data}
+			aggregated := kpl.IsAggregated(rec.Data)
+			if aggregated {
+				userRecords, aggErr := kpl.Deaggregate(rec.Data)
+				if aggErr != nil {
+					c.logger.WithError(aggErr).WithField("sequence", aws.StringValue(rec.SequenceNumber)).Error("couldn't deaggregate record")
+					decodeErrors.With(c.stream, aws.StringValue(c.shardId)).Inc()
+					c.sendDeadLetter(rec.Data, c.origin(rec, 0), aggErr)
+					c.sequence = rec.SequenceNumber
+					continue
+				}
+
+				payloads = payloads[:0]
+				for _, userRecord := range userRecords {
+					payloads = append(payloads, userRecord.Data)
+				}
+				aggregatedRecords.With(c.stream, aws.StringValue(c.shardId)).Inc()
 			}
 
-			c.logger.WithField("name", event.Name).Debug("sending event to event channel")
-			c.eventChan <- event
+			// if we stop partway through an aggregated record, we'll read
+			// all of it again next time
+			for i, data := range payloads {
+				if !c.consume(data, c.origin(rec, uint64(i))) {
+					goto SHUTDOWN
+				}
+			}
 			c.sequence = rec.SequenceNumber
 		}
 
@@ -212,24 +391,73 @@ func (c *kinesisConsumer) run() {
 		c.logger.Debugf("setting next iterator: %s", aws.StringValue(out.NextShardIterator))
 		c.setIterator(out.NextShardIterator)
 
-		// if there aren't any more records, just chill for a bit. ideally this would be adaptive
-		if aws.Int64Value(out.MillisBehindLatest) == 0 {
-			time.Sleep(sleepDuration)
+		if aws.Int64Value(out.MillisBehindLatest) == 0 && c.replay != nil && c.replay.done(time.Now()) {
+			c.logger.Info("replay caught up with the stream")
+			goto SHUTDOWN
+		}
+
+		interval := c.poller.next(len(out.Records), aws.Int64Value(out.MillisBehindLatest))
+		pollInterval.With(c.stream, aws.StringValue(c.shardId)).Set(interval.Seconds())
+		if !c.sleep(interval - time.Since(readStart)) {
+			goto SHUTDOWN
 		}
 	}
 
 SHUTDOWN:
+	close(c.doneChan)
 	close(c.eventChan)
 	// persist cursor
 	c.putSequence()
 	c.stoppedChan <- struct{}{}
 }
 
+// sleep waits between reads, returning false if we're stopped in the
+// meantime.
+func (c *kinesisConsumer) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return !c.shouldStop()
+	}
+
+	select {
+	case <-time.After(d):
+		return !c.shouldStop()
+	case <-c.stopChan:
+		c.stopping = true
+		return false
+	}
+}
+
+// reacquireIterator gets a fresh iterator after ours expired, picking up
+// after the last record we handed off, or from the stored checkpoint if we
+// haven't read anything yet.
+func (c *kinesisConsumer) reacquireIterator() error {
+	if c.sequence == nil {
+		return c.getIterator()
+	}
+
+	out, err := c.client.GetShardIterator(&kinesis.GetShardIteratorInput{
+		ShardId:                c.shardId,
+		ShardIteratorType:      aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber),
+		StreamName:             aws.String(c.stream),
+		StartingSequenceNumber: c.sequence,
+	})
+
+	if err != nil {
+		c.logger.WithError(err).Error("AWS error")
+		return err
+	}
+
+	c.setIterator(out.ShardIterator)
+	return nil
+}
+
 func (c *kinesisConsumer) flushIterator() {
 	for {
 		select {
 		case <-time.After(flushIntervalDuration):
 			c.putSequence()
+		case <-c.doneChan:
+			return
 		}
 	}
 }
@@ -240,57 +468,195 @@ func (c *kinesisConsumer) setIterator(iter *string) {
 	c.iterator = iter
 }
 
+// sequencePath is where we checkpoint the shard. Replays get their own
+// namespace so they don't disturb the live consumer.
+func (c *kinesisConsumer) sequencePath() string {
+	shardPath := c.shardPath
+	if c.replay != nil {
+		shardPath = c.replay.checkpointPath(shardPath)
+	}
+
+	return path.Join(shardPath, aws.StringValue(c.shardId), "sequence")
+}
+
 func (c *kinesisConsumer) putSequence() error {
-	_, err := c.etcd.Set(context.Background(), path.Join(c.shardPath, aws.StringValue(c.shardId), "sequence"), aws.StringValue(c.sequence), &etcd.SetOptions{})
+	// nothing read yet, and an empty checkpoint can't be resumed from
+	if c.sequence == nil {
+		return nil
+	}
+
+	err := c.checkpoints.SetCheckpoint(c.sequencePath(), aws.StringValue(c.sequence))
+
+	c.healthMut.Lock()
+	c.checkpointErr = err
+	if err == nil {
+		c.lastCheckpoint = time.Now()
+	}
+	c.healthMut.Unlock()
+
+	if err != nil {
+		c.logger.WithError(err).Error("checkpoint error")
+	}
+
 	return err
 }
 
 func (c *kinesisConsumer) getIterator() error {
-	response, err := c.etcd.Get(context.Background(), path.Join(c.shardPath, aws.StringValue(c.shardId), "sequence"), &etcd.GetOptions{
-		Quorum: true,
-	})
-
+	sequence, err := c.checkpoints.Checkpoint(c.sequencePath())
 	if err != nil {
-		if etcdErr, ok := err.(etcd.Error); ok {
-			switch etcdErr.Code {
-			case etcd.ErrorCodeKeyNotFound:
-				return c.getIteratorHorizon()
-			}
-		}
-
-		c.logger.WithError(err).Error("etcd error")
+		c.logger.WithError(err).Error("checkpoint error")
 		return err
 	}
 
+	if sequence == "" {
+		return c.getIteratorInitial(startNoCheckpoint)
+	}
+
 	out, err := c.client.GetShardIterator(&kinesis.GetShardIteratorInput{
 		ShardId:                c.shardId,
 		ShardIteratorType:      aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber),
 		StreamName:             aws.String(c.stream),
-		StartingSequenceNumber: aws.String(response.Node.Value),
+		StartingSequenceNumber: aws.String(sequence),
 	})
 
 	if err != nil {
+		// kinesis rejects sequence numbers that have been trimmed off
+		// the stream, so that's how we find out the checkpoint expired
+		if isInvalidArgument(err) {
+			return c.expiredCheckpoint(sequence)
+		}
+
 		c.logger.WithError(err).Error("AWS error")
 		return err
 	}
 
+	c.logger.WithField("sequence", sequence).Info("resuming from checkpoint")
+	iteratorStarts.With(c.stream, aws.StringValue(c.shardId), kinesis.ShardIteratorTypeAfterSequenceNumber, startCheckpoint).Inc()
+
+	c.sequence = aws.String(sequence)
 	c.setIterator(out.ShardIterator)
 	return nil
 }
 
-func (c *kinesisConsumer) getIteratorHorizon() error {
-	out, err := c.client.GetShardIterator(&kinesis.GetShardIteratorInput{
-		ShardId:           c.shardId,
-		ShardIteratorType: aws.String(kinesis.ShardIteratorTypeTrimHorizon),
-		StreamName:        aws.String(c.stream),
+// consume decodes an event and hands it off, returning false if we're
+// stopping.
+func (c *kinesisConsumer) consume(data []byte, origin *gmunch.Origin) bool {
+	event := &gmunch.Event{}
+
+	// ignore unmarshaling errors, if you can't send the right kind of data, then
+	// continue incrementing the sequence and to heck with you
+	if err := proto.Unmarshal(data, event); err != nil {
+		c.logger.WithError(err).WithFields(log.Fields{
+			"sequence":     origin.Sequence,
+			"sub_sequence": origin.SubSequence,
+		}).Error("proto unmarshal error")
+		decodeErrors.With(c.stream, aws.StringValue(c.shardId)).Inc()
+		c.sendDeadLetter(data, origin, err)
+		return true
+	}
+
+	// handlers see data as it was encoded, however it was published
+	if err := c.inline(event); err != nil {
+		c.logger.WithError(err).WithFields(log.Fields{
+			"sequence":     origin.Sequence,
+			"sub_sequence": origin.SubSequence,
+		}).Error("couldn't restore event data")
+		decodeErrors.With(c.stream, aws.StringValue(c.shardId)).Inc()
+		c.sendDeadLetter(data, origin, err)
+		return true
+	}
+
+	if c.shouldStop() {
+		return false
+	}
+
+	if c.replay != nil && !c.replay.wants(event.Name) {
+		return true
+	}
+
+	event.Origin = origin
+
+	span, ctx := trace.StartSpan(trace.Extract(context.Background(), event), "gmunch.consume")
+	span.SetAttribute("name", event.Name)
+	span.SetAttribute("stream", c.stream)
+	span.SetAttribute("shard", origin.Shard)
+	span.SetAttribute("sequence", origin.Sequence)
+	trace.Inject(ctx, event)
+
+	c.logger.WithFields(event.LogFields()).Debug("sending event to event channel")
+	c.eventChan <- event
+	span.Finish()
+
+	return true
+}
+
+// inline fetches offloaded data and decompresses it, undoing what the
+// producer did in that order.
+func (c *kinesisConsumer) inline(event *gmunch.Event) error {
+	if err := claimcheck.Inline(c.claimCheck, event); err != nil {
+		return err
+	}
+
+	return event.Decompress()
+}
+
+// origin returns the origin of the event at index i within a record. Only
+// aggregated records hold more than one.
+func (c *kinesisConsumer) origin(rec *kinesis.Record, i uint64) *gmunch.Origin {
+	return &gmunch.Origin{
+		Source:      "kinesis",
+		Shard:       aws.StringValue(c.shardId),
+		Sequence:    aws.StringValue(rec.SequenceNumber),
+		SubSequence: i,
+	}
+}
+
+func (c *kinesisConsumer) sendDeadLetter(payload []byte, origin *gmunch.Origin, reason error) {
+	if c.deadLetter == nil {
+		return
+	}
+
+	err := c.deadLetter.Send(&deadletter.DeadLetter{
+		Payload: payload,
+		Reason:  reason.Error(),
+		Origin:  origin,
+		Time:    time.Now(),
 	})
 
 	if err != nil {
-		c.logger.WithError(err).Error("AWS error")
-		return err
+		c.logger.WithError(err).Error("couldn't send dead letter")
+	}
+}
+
+// Healthy reports an error if we haven't been able to read from the shard or
+// checkpoint our position recently, or if we've fallen too far behind.
+func (c *kinesisConsumer) Healthy() error {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+
+	if c.lastRead.IsZero() {
+		return fmt.Errorf("consumer has not read from kinesis yet")
+	}
+
+	if since := time.Since(c.lastRead); since > staleReadDuration {
+		return fmt.Errorf("no records read from kinesis in %s", since)
+	}
+
+	if c.checkpointErr != nil {
+		return fmt.Errorf("couldn't checkpoint sequence: %s", c.checkpointErr)
+	}
+
+	if !c.lastCheckpoint.IsZero() {
+		if since := time.Since(c.lastCheckpoint); since > staleCheckpointDuration {
+			return fmt.Errorf("no checkpoint written in %s", since)
+		}
+	}
+
+	lag := time.Duration(c.millisBehind) * time.Millisecond
+	if c.maxLag > 0 && lag > c.maxLag {
+		return fmt.Errorf("consumer is %s behind the stream, max lag is %s", lag, c.maxLag)
 	}
 
-	c.setIterator(out.ShardIterator)
 	return nil
 }
 
@@ -0,0 +1,328 @@
+package kinesis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/claimcheck"
+	"github.com/opsee/gmunch/deadletter"
+	"github.com/opsee/gmunch/kinesistest"
+	"github.com/opsee/gmunch/kpl"
+	log "github.com/opsee/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testStream = "test"
+	testShard  = "shardId-000000000000"
+)
+
+// newTestStream creates a single shard stream holding n events, whose data
+// is their index.
+func newTestStream(n int) *kinesistest.Server {
+	srv := kinesistest.NewServer()
+	srv.CreateStream(testStream, 1)
+	putEvents(srv, 0, n)
+	return srv
+}
+
+func putEvents(srv *kinesistest.Server, from, to int) {
+	for i := from; i < to; i++ {
+		data, _ := proto.Marshal(&gmunch.Event{Name: "test_event", Data: []byte(strconv.Itoa(i))})
+		srv.Put(testStream, "key", data)
+	}
+}
+
+// startTestConsumer starts a consumer against the fake, returning the
+// result of Start on errChan once it's done.
+func startTestConsumer(srv *kinesistest.Server, config Config) (*kinesisConsumer, chan error) {
+	config.Stream = testStream
+	config.AWSConfig = srv.Config()
+
+	if config.Checkpointer == nil {
+		config.Checkpointer = NewMemoryCheckpointer()
+	}
+
+	if config.MinPollInterval == 0 {
+		config.MinPollInterval = time.Millisecond
+	}
+
+	if config.Logger == nil {
+		config.Logger = log.New()
+		config.Logger.Out = ioutil.Discard
+	}
+
+	c := New(config)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.Start()
+	}()
+
+	return c, errChan
+}
+
+func readEvents(c *kinesisConsumer, n int) []string {
+	data := []string{}
+	for event := range c.Events() {
+		data = append(data, string(event.Data))
+		if len(data) == n {
+			break
+		}
+	}
+
+	return data
+}
+
+func indexes(from, to int) []string {
+	data := []string{}
+	for i := from; i < to; i++ {
+		data = append(data, strconv.Itoa(i))
+	}
+
+	return data
+}
+
+func TestCheckpointResume(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(20)
+	defer srv.Close()
+	checkpoints := NewMemoryCheckpointer()
+
+	c, errChan := startTestConsumer(srv, Config{Checkpointer: checkpoints})
+	read := readEvents(c, 10)
+
+	// stopping persists our position. an event that was already on its
+	// way may still arrive
+	go c.Stop()
+	read = append(read, readEvents(c, -1)...)
+	assert.NoError(<-errChan)
+	assert.Equal(indexes(0, len(read)), read)
+
+	sequence, err := checkpoints.Checkpoint(testShard + "/sequence")
+	assert.NoError(err)
+	assert.Equal(srv.Records(testStream, testShard)[len(read)-1].Sequence, sequence)
+
+	// and a new consumer picks up after it
+	putEvents(srv, 20, 25)
+	c, errChan = startTestConsumer(srv, Config{Checkpointer: checkpoints})
+	assert.Equal(indexes(len(read), 25), readEvents(c, 25-len(read)))
+
+	go c.Stop()
+	for range c.Events() {
+	}
+	assert.NoError(<-errChan)
+}
+
+func TestInitialPositionLatest(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(5)
+	defer srv.Close()
+
+	c, errChan := startTestConsumer(srv, Config{InitialPosition: StartLatest})
+
+	// give it a moment to get its iterator before the new records arrive
+	for srv.Calls("GetRecords") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	putEvents(srv, 5, 8)
+	srv.CloseShard(testStream, testShard)
+
+	assert.Equal(indexes(5, 8), readEvents(c, -1))
+	assert.NoError(<-errChan)
+}
+
+func TestExpiredCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(2)
+	defer srv.Close()
+	checkpoints := NewMemoryCheckpointer()
+	checkpoints.SetCheckpoint(testShard+"/sequence", srv.Records(testStream, testShard)[0].Sequence)
+
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	putEvents(srv, 2, 5)
+	srv.TrimBefore(testStream, cutoff)
+	srv.CloseShard(testStream, testShard)
+
+	_, errChan := startTestConsumer(srv, Config{
+		Checkpointer:        checkpoints,
+		OnExpiredCheckpoint: FailOnExpired,
+	})
+	assert.Error(<-errChan)
+
+	// falling back reads what's left on the stream, even for a consumer
+	// that would otherwise start at the latest record
+	c, errChan := startTestConsumer(srv, Config{
+		Checkpointer:    checkpoints,
+		InitialPosition: StartLatest,
+	})
+	assert.Equal(indexes(2, 5), readEvents(c, -1))
+	assert.NoError(<-errChan)
+}
+
+func TestShardClosure(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(3)
+	defer srv.Close()
+
+	c, errChan := startTestConsumer(srv, Config{})
+	assert.Equal(indexes(0, 3), readEvents(c, 3))
+
+	// the consumer reads the closed shard to its end and shuts down. it
+	// doesn't follow the children
+	assert.NoError(srv.SplitShard(testStream, testShard))
+	putEvents(srv, 3, 6)
+
+	for event := range c.Events() {
+		t.Fatal(fmt.Sprintf("got an event from a child shard: %s", event.Data))
+	}
+	assert.NoError(<-errChan)
+}
+
+func TestReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(10)
+	defer srv.Close()
+	records := srv.Records(testStream, testShard)
+	checkpoints := NewMemoryCheckpointer()
+
+	c, errChan := startTestConsumer(srv, Config{
+		Checkpointer: checkpoints,
+		Replay: &Replay{
+			Name:          "test",
+			StartSequence: records[2].Sequence,
+			EndSequence:   records[6].Sequence,
+		},
+	})
+	assert.Equal(indexes(2, 7), readEvents(c, -1))
+	assert.NoError(<-errChan)
+
+	// the replay didn't touch the live checkpoint
+	sequence, _ := checkpoints.Checkpoint(testShard + "/sequence")
+	assert.Equal("", sequence)
+	sequence, _ = checkpoints.Checkpoint("replay/test/" + testShard + "/sequence")
+	assert.Equal(records[6].Sequence, sequence)
+}
+
+func TestDeaggregation(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(1)
+	defer srv.Close()
+
+	userRecords := []*kpl.UserRecord{}
+	for i := 1; i < 4; i++ {
+		data, _ := proto.Marshal(&gmunch.Event{Name: "test_event", Data: []byte(strconv.Itoa(i))})
+		userRecords = append(userRecords, &kpl.UserRecord{PartitionKey: "key", Data: data})
+	}
+	aggregated, err := kpl.Aggregate(userRecords)
+	assert.NoError(err)
+	srv.Put(testStream, "key", aggregated)
+	putEvents(srv, 4, 5)
+	srv.CloseShard(testStream, testShard)
+
+	c, errChan := startTestConsumer(srv, Config{})
+	events := []*gmunch.Event{}
+	for event := range c.Events() {
+		events = append(events, event)
+	}
+	assert.NoError(<-errChan)
+
+	assert.Len(events, 5)
+	records := srv.Records(testStream, testShard)
+	for i, event := range events {
+		assert.Equal(strconv.Itoa(i), string(event.Data))
+	}
+	assert.Equal(records[1].Sequence, events[3].Origin.Sequence)
+	assert.Equal(uint64(2), events[3].Origin.SubSequence)
+	assert.Equal(records[2].Sequence, events[4].Origin.Sequence)
+	assert.Equal(uint64(0), events[4].Origin.SubSequence)
+}
+
+func TestDecompression(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(0)
+	defer srv.Close()
+
+	data := strings.Repeat("worms ", 1000)
+	for _, encoding := range []string{gmunch.EncodingGzip, gmunch.EncodingSnappy} {
+		event := &gmunch.Event{Name: "test_event", Data: []byte(data)}
+		assert.NoError(event.Compress(encoding, 0))
+		pbdata, _ := proto.Marshal(event)
+		srv.Put(testStream, "key", pbdata)
+	}
+
+	// claims to be compressed but isn't
+	corrupt, _ := proto.Marshal(&gmunch.Event{
+		Name:    "test_event",
+		Data:    []byte(data),
+		Headers: map[string]string{gmunch.HeaderContentEncoding: gmunch.EncodingGzip},
+	})
+	srv.Put(testStream, "key", corrupt)
+	putEvents(srv, 3, 4)
+	srv.CloseShard(testStream, testShard)
+
+	letters := deadletter.NewMemorySink()
+	c, errChan := startTestConsumer(srv, Config{DeadLetter: letters})
+	events := []*gmunch.Event{}
+	for event := range c.Events() {
+		events = append(events, event)
+	}
+	assert.NoError(<-errChan)
+
+	assert.Len(events, 3)
+	assert.Equal(data, string(events[0].Data))
+	assert.Equal(data, string(events[1].Data))
+	assert.Equal("", events[1].Header(gmunch.HeaderContentEncoding))
+	assert.Equal("3", string(events[2].Data))
+	assert.Len(letters.Letters(), 1)
+}
+
+func TestClaimCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(0)
+	defer srv.Close()
+
+	store := claimcheck.NewMemoryStore()
+	data := strings.Repeat("worms ", 1000)
+	for i := 0; i < 2; i++ {
+		event := &gmunch.Event{Name: "test_event", Data: []byte(data)}
+		assert.NoError(event.Compress(gmunch.EncodingGzip, 0))
+		assert.NoError(claimcheck.Offload(store, event, 0))
+		pbdata, _ := proto.Marshal(event)
+		srv.Put(testStream, "key", pbdata)
+
+		// the second one's data has expired
+		if i == 1 {
+			store.Delete(event.Header(claimcheck.HeaderClaimCheck))
+		}
+	}
+	srv.CloseShard(testStream, testShard)
+
+	letters := deadletter.NewMemorySink()
+	c, errChan := startTestConsumer(srv, Config{DeadLetter: letters, ClaimCheck: store})
+	events := []*gmunch.Event{}
+	for event := range c.Events() {
+		events = append(events, event)
+	}
+	assert.NoError(<-errChan)
+
+	assert.Len(events, 1)
+	assert.Equal(data, string(events[0].Data))
+	assert.Equal("", events[0].Header(claimcheck.HeaderClaimCheck))
+	assert.Equal("", events[0].Header(gmunch.HeaderContentEncoding))
+	assert.Len(letters.Letters(), 1)
+}
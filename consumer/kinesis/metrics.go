@@ -0,0 +1,55 @@
+package kinesis
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	recordsRead = metrics.NewCounterVec(
+		"gmunch_kinesis_consumer_records_total",
+		"Records read from kinesis, by stream and shard.",
+		"stream", "shard",
+	)
+
+	millisBehindLatest = metrics.NewGaugeVec(
+		"gmunch_kinesis_consumer_millis_behind_latest",
+		"How far the consumer is behind the tip of the shard, in milliseconds.",
+		"stream", "shard",
+	)
+
+	decodeErrors = metrics.NewCounterVec(
+		"gmunch_kinesis_consumer_decode_errors_total",
+		"Records that couldn't be unmarshaled into events, by stream and shard.",
+		"stream", "shard",
+	)
+
+	iteratorStarts = metrics.NewCounterVec(
+		"gmunch_kinesis_consumer_iterator_starts_total",
+		"Where the consumer started reading a shard, and why.",
+		"stream", "shard", "position", "reason",
+	)
+
+	throttles = metrics.NewCounterVec(
+		"gmunch_kinesis_consumer_throttles_total",
+		"Reads rejected for exceeding the shard's provisioned throughput.",
+		"stream", "shard",
+	)
+
+	expiredIterators = metrics.NewCounterVec(
+		"gmunch_kinesis_consumer_expired_iterators_total",
+		"Shard iterators that expired and had to be reacquired.",
+		"stream", "shard",
+	)
+
+	pollInterval = metrics.NewGaugeVec(
+		"gmunch_kinesis_consumer_poll_interval_seconds",
+		"The current interval between reads.",
+		"stream", "shard",
+	)
+
+	aggregatedRecords = metrics.NewCounterVec(
+		"gmunch_kinesis_consumer_aggregated_records_total",
+		"KPL aggregated records read, by stream and shard.",
+		"stream", "shard",
+	)
+)
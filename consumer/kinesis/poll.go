@@ -0,0 +1,90 @@
+package kinesis
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const (
+	errCodeThroughputExceeded = "ProvisionedThroughputExceededException"
+	errCodeExpiredIterator    = "ExpiredIteratorException"
+
+	defaultBatchSize = 1000
+	maxBatchSize     = 10000
+
+	// kinesis allows 5 reads per second per shard
+	defaultMinPollInterval = 200 * time.Millisecond
+	defaultMaxPollInterval = 2 * time.Second
+)
+
+// poller paces GetRecords calls. While we're behind the stream we poll as
+// fast as the shard allows. Once we've caught up, empty reads back the
+// interval off towards max. Throttling adds a penalty on top, which decays
+// with every successful read.
+type poller struct {
+	min, max time.Duration
+	interval time.Duration
+	penalty  time.Duration
+}
+
+func newPoller(min, max time.Duration) *poller {
+	return &poller{
+		min:      min,
+		max:      max,
+		interval: min,
+	}
+}
+
+// next returns how long to wait between the start of the last read and the
+// next one.
+func (p *poller) next(records int, millisBehind int64) time.Duration {
+	switch {
+	case records == 0 && millisBehind == 0:
+		p.interval = p.clamp(p.interval * 2)
+	default:
+		p.interval = p.min
+	}
+
+	p.penalty /= 2
+	if p.penalty < p.min {
+		p.penalty = 0
+	}
+
+	if p.penalty > p.interval {
+		return p.penalty
+	}
+
+	return p.interval
+}
+
+// throttled records a throttling response.
+func (p *poller) throttled() {
+	if p.penalty == 0 {
+		p.penalty = p.clamp(p.interval * 2)
+		return
+	}
+
+	p.penalty = p.clamp(p.penalty * 2)
+}
+
+func (p *poller) clamp(d time.Duration) time.Duration {
+	switch {
+	case d < p.min:
+		return p.min
+	case d > p.max:
+		return p.max
+	default:
+		return d
+	}
+}
+
+func isThrottled(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == errCodeThroughputExceeded
+}
+
+func isExpiredIterator(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == errCodeExpiredIterator
+}
@@ -0,0 +1,79 @@
+package kinesis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opsee/gmunch/kinesistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newPoller(100*time.Millisecond, time.Second)
+
+	// behind the stream, poll as fast as we can
+	assert.Equal(100*time.Millisecond, p.next(10, 5000))
+
+	// caught up and nothing new, back off
+	assert.Equal(200*time.Millisecond, p.next(0, 0))
+	assert.Equal(400*time.Millisecond, p.next(0, 0))
+	assert.Equal(800*time.Millisecond, p.next(0, 0))
+	assert.Equal(time.Second, p.next(0, 0))
+
+	// new records, speed up again
+	assert.Equal(100*time.Millisecond, p.next(1, 0))
+
+	// throttling slows us down even while we're behind, and decays
+	p.throttled()
+	p.throttled()
+	assert.Equal(200*time.Millisecond, p.next(10, 5000))
+	assert.Equal(100*time.Millisecond, p.next(10, 5000))
+}
+
+func TestConsumerRecovers(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newTestStream(50)
+	defer srv.Close()
+	srv.CloseShard(testStream, testShard)
+	srv.FailNext("GetRecords", kinesistest.ErrCodeThroughputExceeded, 1)
+
+	c, errChan := startTestConsumer(srv, Config{
+		BatchSize:       10,
+		MinPollInterval: time.Millisecond,
+	})
+
+	i := 0
+	for event := range c.Events() {
+		assert.Equal(fmt.Sprint(i), string(event.Data))
+		i++
+
+		if i == 15 {
+			srv.FailNext("GetRecords", kinesistest.ErrCodeExpiredIterator, 1)
+		}
+	}
+	assert.Equal(50, i)
+	assert.NoError(<-errChan)
+}
+
+func BenchmarkConsume(b *testing.B) {
+	for _, batchSize := range []int64{1, 100, 1000} {
+		b.Run(fmt.Sprintf("batch-%d", batchSize), func(b *testing.B) {
+			srv := newTestStream(b.N)
+			defer srv.Close()
+			srv.CloseShard(testStream, testShard)
+
+			c, _ := startTestConsumer(srv, Config{
+				BatchSize:       batchSize,
+				MinPollInterval: time.Microsecond,
+			})
+
+			b.ResetTimer()
+			for range c.Events() {
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package kinesis
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/opsee/logrus"
+)
+
+const errCodeInvalidArgument = "InvalidArgumentException"
+
+// InitialPosition is where a consumer with no checkpoint starts reading.
+type InitialPosition string
+
+const (
+	// StartTrimHorizon reads everything still on the stream, up to its
+	// retention period.
+	StartTrimHorizon InitialPosition = kinesis.ShardIteratorTypeTrimHorizon
+
+	// StartLatest only reads records put after the consumer starts.
+	StartLatest InitialPosition = kinesis.ShardIteratorTypeLatest
+
+	// StartAtTimestamp reads records put after Config.InitialTimestamp.
+	StartAtTimestamp InitialPosition = kinesis.ShardIteratorTypeAtTimestamp
+)
+
+// ExpiredCheckpointPolicy says what to do when the stored checkpoint has
+// been trimmed off the stream, meaning records after it may have been lost.
+type ExpiredCheckpointPolicy int
+
+const (
+	// FallBackOnExpired logs the expiry and starts again from the trim
+	// horizon, the oldest record still on the stream.
+	FallBackOnExpired ExpiredCheckpointPolicy = iota
+
+	// FailOnExpired refuses to start, so that someone can decide what to
+	// do about the gap.
+	FailOnExpired
+)
+
+// reasons we pick a starting position, for logging and metrics
+const (
+	startCheckpoint        = "checkpoint"
+	startNoCheckpoint      = "no_checkpoint"
+	startExpiredCheckpoint = "expired_checkpoint"
+)
+
+func (p InitialPosition) validate(timestamp time.Time) error {
+	switch p {
+	case StartTrimHorizon, StartLatest:
+		return nil
+	case StartAtTimestamp:
+		if timestamp.IsZero() {
+			return fmt.Errorf("initial position %s needs a timestamp", p)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown initial position: %s", p)
+	}
+}
+
+// initialInput returns the input for an iterator at the initial position.
+// Replays start at the start of their window instead.
+func (c *kinesisConsumer) initialInput() *kinesis.GetShardIteratorInput {
+	if c.replay != nil {
+		return c.replay.iteratorInput()
+	}
+
+	input := &kinesis.GetShardIteratorInput{
+		ShardIteratorType: aws.String(string(c.initialPosition)),
+	}
+	if c.initialPosition == StartAtTimestamp {
+		input.Timestamp = aws.Time(c.initialTimestamp)
+	}
+
+	return input
+}
+
+// getIteratorInitial starts reading from the initial position.
+func (c *kinesisConsumer) getIteratorInitial(reason string) error {
+	return c.startIterator(c.initialInput(), reason)
+}
+
+// startIterator starts reading from the position in input, which is
+// filled in with the stream and shard.
+func (c *kinesisConsumer) startIterator(input *kinesis.GetShardIteratorInput, reason string) error {
+	input.ShardId = c.shardId
+	input.StreamName = aws.String(c.stream)
+
+	position := aws.StringValue(input.ShardIteratorType)
+	c.logger.WithFields(log.Fields{"position": position, "reason": reason}).Info("starting from position")
+	iteratorStarts.With(c.stream, aws.StringValue(c.shardId), position, reason).Inc()
+
+	out, err := c.client.GetShardIterator(input)
+	if err != nil {
+		c.logger.WithError(err).Error("AWS error")
+		return err
+	}
+
+	c.setIterator(out.ShardIterator)
+	return nil
+}
+
+// expiredCheckpoint applies the expired checkpoint policy. Falling back
+// starts from the trim horizon rather than the initial position, so that
+// the records still on the stream are read, however the consumer would
+// start without a checkpoint.
+func (c *kinesisConsumer) expiredCheckpoint(sequence string) error {
+	logger := c.logger.WithField("sequence", sequence)
+
+	if c.onExpired == FailOnExpired {
+		logger.Error("checkpoint has expired off the stream, refusing to start")
+		iteratorStarts.With(c.stream, aws.StringValue(c.shardId), "none", startExpiredCheckpoint).Inc()
+		return fmt.Errorf("checkpoint %s has expired off the stream", sequence)
+	}
+
+	logger.Warn("checkpoint has expired off the stream, records after it may have been lost")
+	return c.startIterator(&kinesis.GetShardIteratorInput{
+		ShardIteratorType: aws.String(kinesis.ShardIteratorTypeTrimHorizon),
+	}, startExpiredCheckpoint)
+}
+
+// parseSequence parses a kinesis sequence number. They're too big for an
+// int64, but they're still numbers and they still increase.
+func parseSequence(sequence string) (*big.Int, bool) {
+	return new(big.Int).SetString(sequence, 10)
+}
+
+func isInvalidArgument(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == errCodeInvalidArgument
+}
@@ -0,0 +1,27 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitialPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(StartTrimHorizon.validate(time.Time{}))
+	assert.NoError(StartLatest.validate(time.Time{}))
+	assert.Error(StartAtTimestamp.validate(time.Time{}))
+	assert.NoError(StartAtTimestamp.validate(time.Now()))
+	assert.Error(InitialPosition("AFTER_SEQUENCE_NUMBER").validate(time.Time{}))
+
+	c := New(Config{Stream: "test"})
+	assert.Equal(StartTrimHorizon, c.initialPosition)
+	assert.Equal(FallBackOnExpired, c.onExpired)
+
+	c = New(Config{Stream: "test", InitialPosition: StartAtTimestamp, InitialTimestamp: time.Unix(1, 0)})
+	input := c.initialInput()
+	assert.Equal("AT_TIMESTAMP", *input.ShardIteratorType)
+	assert.Equal(time.Unix(1, 0), *input.Timestamp)
+}
@@ -0,0 +1,70 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error((&Replay{}).init())
+	assert.Error((&Replay{Name: "r", StartTime: time.Now(), StartSequence: "1"}).init())
+	assert.Error((&Replay{Name: "r", EndSequence: "nope"}).init())
+
+	// sequence numbers overflow an int64
+	r := &Replay{Name: "r", EndSequence: "49561219021098245432112345678901234567890"}
+	assert.NoError(r.init())
+	assert.True(r.EndTime.IsZero())
+	assert.False(r.past(&kinesis.Record{SequenceNumber: aws.String("49561219021098245432112345678901234567890")}))
+	assert.True(r.past(&kinesis.Record{SequenceNumber: aws.String("49561219021098245432112345678901234567891")}))
+	assert.True(r.done(time.Now()))
+
+	end := time.Now()
+	r = &Replay{Name: "r", EndTime: end}
+	assert.NoError(r.init())
+	assert.False(r.past(&kinesis.Record{ApproximateArrivalTimestamp: aws.Time(end)}))
+	assert.True(r.past(&kinesis.Record{ApproximateArrivalTimestamp: aws.Time(end.Add(time.Second))}))
+	assert.False(r.done(end.Add(-time.Second)))
+	assert.True(r.done(end.Add(time.Second)))
+
+	// no end means now
+	r = &Replay{Name: "r"}
+	assert.NoError(r.init())
+	assert.False(r.EndTime.IsZero())
+}
+
+func TestReplayStart(t *testing.T) {
+	assert := assert.New(t)
+
+	input := (&Replay{Name: "r"}).iteratorInput()
+	assert.Equal(kinesis.ShardIteratorTypeTrimHorizon, aws.StringValue(input.ShardIteratorType))
+
+	input = (&Replay{Name: "r", StartSequence: "123"}).iteratorInput()
+	assert.Equal(kinesis.ShardIteratorTypeAtSequenceNumber, aws.StringValue(input.ShardIteratorType))
+	assert.Equal("123", aws.StringValue(input.StartingSequenceNumber))
+
+	start := time.Now()
+	input = (&Replay{Name: "r", StartTime: start}).iteratorInput()
+	assert.Equal(kinesis.ShardIteratorTypeAtTimestamp, aws.StringValue(input.ShardIteratorType))
+	assert.Equal(start, aws.TimeValue(input.Timestamp))
+
+	assert.Equal("gmunch/replay/r", (&Replay{Name: "r"}).checkpointPath("gmunch"))
+}
+
+func TestReplayFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &Replay{Name: "r"}
+	assert.NoError(r.init())
+	assert.True(r.wants("anything"))
+
+	r = &Replay{Name: "r", EventNames: []string{"a", "b"}}
+	assert.NoError(r.init())
+	assert.True(r.wants("a"))
+	assert.False(r.wants("c"))
+}
@@ -0,0 +1,19 @@
+package nsq
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	messagesRead = metrics.NewCounterVec(
+		"gmunch_nsq_consumer_messages_total",
+		"Messages read from nsq, by topic and channel.",
+		"topic", "channel",
+	)
+
+	decodeErrors = metrics.NewCounterVec(
+		"gmunch_nsq_consumer_decode_errors_total",
+		"Messages that couldn't be unmarshaled into events, by topic and channel.",
+		"topic", "channel",
+	)
+)
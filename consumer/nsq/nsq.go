@@ -1,12 +1,17 @@
 package nsq
 
 import (
+	"errors"
 	"time"
 
 	log "github.com/opsee/logrus"
 	"github.com/golang/protobuf/proto"
 	"github.com/nsqio/go-nsq"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/claimcheck"
+	"github.com/opsee/gmunch/deadletter"
+	"github.com/opsee/gmunch/trace"
+	"golang.org/x/net/context"
 )
 
 type nsqConsumer struct {
@@ -24,15 +29,32 @@ type Config struct {
 	LookupdAddresses []string
 	NSQConfig        *nsq.Config
 	HandlerCount     int
+
+	// Logger is used for all of the consumer's logging. Defaults to the
+	// standard logger.
+	Logger *log.Logger
+
+	// DeadLetter, if set, receives messages that can't be decoded. Without
+	// one, they're requeued by nsq.
+	DeadLetter deadletter.Sink
+
+	// ClaimCheck is where the producer offloaded event data too big to
+	// send. Offloaded events are dead lettered without one, or if their
+	// data can't be fetched.
+	ClaimCheck claimcheck.Store
 }
 
 func New(config Config) *nsqConsumer {
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+
 	return &nsqConsumer{
 		config:      &config,
 		stopChan:    make(chan struct{}, 1),
 		stoppedChan: make(chan struct{}, 1),
 		eventChan:   make(chan *gmunch.Event),
-		logger:      log.WithField("consumer", "nsq"),
+		logger:      config.Logger.WithFields(log.Fields{"consumer": "nsq", "topic": config.Topic, "channel": config.Channel}),
 	}
 }
 
@@ -47,7 +69,7 @@ func (c *nsqConsumer) Start() error {
 
 	c.consumer, err = nsq.NewConsumer(c.config.Topic, c.config.Channel, c.config.NSQConfig)
 	if err != nil {
-		log.WithError(err).Error("couldn't create nsq consumer")
+		c.logger.WithError(err).Error("couldn't create nsq consumer")
 		return err
 	}
 
@@ -85,14 +107,86 @@ func (c *nsqConsumer) Events() chan *gmunch.Event {
 }
 
 func (c *nsqConsumer) HandleMessage(m *nsq.Message) error {
+	messagesRead.With(c.config.Topic, c.config.Channel).Inc()
+
 	event := &gmunch.Event{}
 	err := proto.Unmarshal(m.Body, event)
 	if err != nil {
-		c.logger.WithError(err).Error("couldn't unmarshal gmunch event")
-		return err
+		c.logger.WithError(err).WithField("sequence", string(m.ID[:])).Error("couldn't unmarshal gmunch event")
+		decodeErrors.With(c.config.Topic, c.config.Channel).Inc()
+		return c.sendDeadLetter(m, err)
+	}
+
+	if err := c.inline(event); err != nil {
+		c.logger.WithError(err).WithField("sequence", string(m.ID[:])).Error("couldn't restore gmunch event data")
+		decodeErrors.With(c.config.Topic, c.config.Channel).Inc()
+		return c.sendDeadLetter(m, err)
 	}
 
+	event.Origin = &gmunch.Origin{
+		Source:   "nsq",
+		Shard:    m.NSQDAddress,
+		Sequence: string(m.ID[:]),
+	}
+	c.logger.WithFields(event.LogFields()).Debug("sending event to event channel")
+
+	span, ctx := trace.StartSpan(trace.Extract(context.Background(), event), "gmunch.consume")
+	span.SetAttribute("name", event.Name)
+	span.SetAttribute("topic", c.config.Topic)
+	span.SetAttribute("channel", c.config.Channel)
+	trace.Inject(ctx, event)
+
 	c.eventChan <- event
+	span.Finish()
+
+	return nil
+}
+
+// inline fetches offloaded data and decompresses it, undoing what the
+// producer did in that order.
+func (c *nsqConsumer) inline(event *gmunch.Event) error {
+	if err := claimcheck.Inline(c.config.ClaimCheck, event); err != nil {
+		return err
+	}
+
+	return event.Decompress()
+}
+
+// sendDeadLetter returns nil if the message was dead lettered, so that nsq
+// doesn't requeue it.
+func (c *nsqConsumer) sendDeadLetter(m *nsq.Message, reason error) error {
+	if c.config.DeadLetter == nil {
+		return reason
+	}
+
+	err := c.config.DeadLetter.Send(&deadletter.DeadLetter{
+		Payload: m.Body,
+		Reason:  reason.Error(),
+		Origin: &gmunch.Origin{
+			Source:   "nsq",
+			Shard:    m.NSQDAddress,
+			Sequence: string(m.ID[:]),
+		},
+		Time: time.Now(),
+	})
+
+	if err != nil {
+		c.logger.WithError(err).Error("couldn't send dead letter")
+		return reason
+	}
+
+	return nil
+}
+
+// Healthy reports an error if we aren't connected to any nsqd instances.
+func (c *nsqConsumer) Healthy() error {
+	if c.consumer == nil {
+		return errors.New("nsq consumer has not started")
+	}
+
+	if c.consumer.Stats().Connections == 0 {
+		return errors.New("no nsqd connections")
+	}
 
 	return nil
 }
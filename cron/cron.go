@@ -0,0 +1,385 @@
+// Package cron emits events on a schedule. A Scheduler is attached to a
+// server, which publishes its events, or to a worker, which dispatches them
+// itself. When several instances run the same jobs, an Elector picks the one
+// that fires them, and a shared State remembers which ticks have fired so
+// that a new leader, or an instance coming back up, can catch up on the
+// ones that were missed.
+package cron
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsee/gmunch"
+	log "github.com/opsee/logrus"
+)
+
+const (
+	// DefaultInterval is how often a Scheduler checks for due ticks.
+	DefaultInterval = time.Second
+
+	// DefaultGrace is how late a tick can be and still fire under the Skip
+	// policy.
+	DefaultGrace = time.Minute
+
+	// DefaultMaxCatchUp is how many missed ticks the All policy fires.
+	DefaultMaxCatchUp = 100
+
+	// HeaderJob and HeaderTick are set on each event to the name of the job
+	// that emitted it and the tick it was for, in RFC 3339.
+	HeaderJob  = "cron-job"
+	HeaderTick = "cron-tick"
+)
+
+// CatchUp says what a job does about ticks that were missed, because no
+// instance was running or leading when they came due, or because emitting
+// failed. Ticks that are late by less than the scheduler's Grace always
+// fire.
+type CatchUp int
+
+const (
+	// Skip drops missed ticks.
+	Skip CatchUp = iota
+
+	// Latest fires the most recent missed tick, once.
+	Latest
+
+	// All fires every missed tick, oldest first, up to the scheduler's
+	// MaxCatchUp of the most recent ones.
+	All
+)
+
+func (c CatchUp) String() string {
+	switch c {
+	case Skip:
+		return "skip"
+	case Latest:
+		return "latest"
+	case All:
+		return "all"
+	default:
+		return fmt.Sprintf("CatchUp(%d)", int(c))
+	}
+}
+
+// A Job emits an event on each tick of its schedule.
+type Job struct {
+	// Name identifies the job in its state, logs and metrics, and must be
+	// unique among a scheduler's jobs.
+	Name string
+
+	// Schedule is when the job fires. See Parse.
+	Schedule string
+
+	// Location is the time zone the schedule is in. Defaults to UTC.
+	Location *time.Location
+
+	// Event is the name of the events the job emits. Defaults to the job's
+	// name.
+	Event string
+
+	// Data and Headers are copied onto each event.
+	Data    []byte
+	Headers map[string]string
+
+	CatchUp CatchUp
+}
+
+type job struct {
+	Job
+	schedule Schedule
+}
+
+// event returns the job's event for a tick. Its ID is derived from the job
+// and tick, so that a tick fired twice, e.g. by an old leader that hadn't
+// noticed it lost its lease, is deduped by workers that dedupe.
+func (j *job) event(tick time.Time) *gmunch.Event {
+	event := &gmunch.Event{
+		Name: j.Event,
+		Id:   fmt.Sprintf("cron-%s-%d", j.Name, tick.Unix()),
+		Data: j.Data,
+	}
+
+	for k, v := range j.Headers {
+		event.SetHeader(k, v)
+	}
+
+	event.SetHeader(HeaderJob, j.Name)
+	event.SetHeader(HeaderTick, tick.UTC().Format(time.RFC3339))
+	return event
+}
+
+type Config struct {
+	// Elector decides whether this instance fires ticks. Defaults to a
+	// LocalElector, which is fine for a single instance.
+	Elector Elector
+
+	// State remembers the last tick each job fired. Defaults to a
+	// MemoryState, which forgets on restart, so missed ticks are only
+	// caught up on by instances sharing a State that outlives them.
+	State State
+
+	// Interval is how often the scheduler checks for due ticks. Defaults
+	// to DefaultInterval.
+	Interval time.Duration
+
+	// Grace is how late a tick can be before it counts as missed. Defaults
+	// to DefaultGrace.
+	Grace time.Duration
+
+	// MaxCatchUp is how many missed ticks a job with the All policy fires.
+	// Defaults to DefaultMaxCatchUp.
+	MaxCatchUp int
+
+	// Logger defaults to the standard logger.
+	Logger *log.Logger
+}
+
+// A Scheduler fires its jobs' ticks while its instance is the leader.
+type Scheduler struct {
+	jobs       []*job
+	elector    Elector
+	state      State
+	interval   time.Duration
+	grace      time.Duration
+	maxCatchUp int
+	logger     *log.Entry
+
+	started  bool
+	stopChan chan struct{}
+	doneChan chan struct{}
+	mut      sync.Mutex
+}
+
+// New returns a scheduler for jobs, or an error if one of them is invalid.
+func New(jobs []Job, config Config) (*Scheduler, error) {
+	if config.Elector == nil {
+		config.Elector = LocalElector{}
+	}
+
+	if config.State == nil {
+		config.State = NewMemoryState()
+	}
+
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+
+	if config.Grace <= 0 {
+		config.Grace = DefaultGrace
+	}
+
+	if config.MaxCatchUp <= 0 {
+		config.MaxCatchUp = DefaultMaxCatchUp
+	}
+
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+
+	s := &Scheduler{
+		elector:    config.Elector,
+		state:      config.State,
+		interval:   config.Interval,
+		grace:      config.Grace,
+		maxCatchUp: config.MaxCatchUp,
+		logger:     config.Logger.WithField("cron", "scheduler"),
+		stopChan:   make(chan struct{}),
+		doneChan:   make(chan struct{}),
+	}
+
+	names := make(map[string]bool)
+	for _, j := range jobs {
+		if err := validName(j.Name); err != nil {
+			return nil, err
+		}
+
+		if names[j.Name] {
+			return nil, fmt.Errorf("cron: duplicate job %q", j.Name)
+		}
+		names[j.Name] = true
+
+		schedule, err := Parse(j.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("cron: job %s: %s", j.Name, err)
+		}
+
+		if j.Location == nil {
+			j.Location = time.UTC
+		}
+
+		if j.Event == "" {
+			j.Event = j.Name
+		}
+
+		s.jobs = append(s.jobs, &job{Job: j, schedule: schedule})
+	}
+
+	return s, nil
+}
+
+func validName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\*?[`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("cron: invalid job name %q", name)
+	}
+
+	return nil
+}
+
+// Start fires ticks in the background until Stop is called, handing each
+// tick's event to emit. A tick counts as fired once emit succeeds.
+func (s *Scheduler) Start(emit func(*gmunch.Event) error) {
+	s.mut.Lock()
+	s.started = true
+	s.mut.Unlock()
+
+	go func() {
+		defer close(s.doneChan)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.run(time.Now(), emit)
+
+			select {
+			case <-ticker.C:
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// run fires whatever's due at now, if we're the leader, returning how many
+// ticks fired.
+func (s *Scheduler) run(now time.Time, emit func(*gmunch.Event) error) int {
+	leader, err := s.elector.Leader()
+	if err != nil {
+		s.logger.WithError(err).Error("couldn't elect a leader")
+	}
+
+	if !leader {
+		leading.Set(0)
+		return 0
+	}
+	leading.Set(1)
+
+	n := 0
+	for _, j := range s.jobs {
+		select {
+		case <-s.stopChan:
+			return n
+		default:
+		}
+
+		n += s.runJob(j, now, emit)
+	}
+
+	return n
+}
+
+func (s *Scheduler) runJob(j *job, now time.Time, emit func(*gmunch.Event) error) int {
+	logger := s.logger.WithField("job", j.Name)
+
+	last, err := s.state.LastRun(j.Name)
+	if err != nil {
+		logger.WithError(err).Error("couldn't get the job's last run")
+		return 0
+	}
+
+	if last.IsZero() {
+		// a new job starts from now rather than catching up on every tick
+		// since the beginning of time
+		if err := s.state.SetLastRun(j.Name, now); err != nil {
+			logger.WithError(err).Error("couldn't record the job's first run")
+		}
+		return 0
+	}
+
+	ticks, skipped, through := s.due(j, last, now.In(j.Location))
+	if skipped > 0 {
+		logger.WithField("catch_up", j.CatchUp.String()).Warnf("skipping %d missed ticks", skipped)
+		skippedTicks.With(j.Name).Add(float64(skipped))
+	}
+
+	n := 0
+	for _, tick := range ticks {
+		event := j.event(tick)
+		if err := emit(event); err != nil {
+			logger.WithFields(event.LogFields()).WithError(err).Error("couldn't emit the job's event")
+			emitErrors.With(j.Name).Inc()
+			return n
+		}
+
+		lateness.With(j.Name).Observe(now.Sub(tick).Seconds())
+		firedTicks.With(j.Name).Inc()
+		n++
+
+		if err := s.state.SetLastRun(j.Name, tick); err != nil {
+			logger.WithError(err).Error("couldn't record the job's last run")
+			return n
+		}
+	}
+
+	// every tick we found was skipped, so move past them
+	if len(ticks) == 0 && !through.IsZero() {
+		if err := s.state.SetLastRun(j.Name, through); err != nil {
+			logger.WithError(err).Error("couldn't record the job's last run")
+		}
+	}
+
+	return n
+}
+
+// due returns the ticks after last and up to now that the job should fire,
+// oldest first, along with how many missed ticks its catch-up policy
+// skipped and the last tick it considered.
+func (s *Scheduler) due(j *job, last, now time.Time) (ticks []time.Time, skipped int, through time.Time) {
+	keep := 0
+	switch j.CatchUp {
+	case Latest:
+		keep = 1
+	case All:
+		keep = s.maxCatchUp
+	}
+
+	cutoff := now.Add(-s.grace)
+	missed := []time.Time{}
+	for tick := j.schedule.Next(last.In(j.Location)); !tick.IsZero() && !tick.After(now); tick = j.schedule.Next(tick) {
+		through = tick
+
+		if !tick.Before(cutoff) {
+			ticks = append(ticks, tick)
+			continue
+		}
+
+		missed = append(missed, tick)
+		if len(missed) > keep {
+			missed = missed[1:]
+			skipped++
+		}
+	}
+
+	return append(missed, ticks...), skipped, through
+}
+
+// Stop stops the scheduler, waiting for ticks being fired to finish, and
+// gives up its leadership.
+func (s *Scheduler) Stop() {
+	s.mut.Lock()
+	started := s.started
+	s.mut.Unlock()
+
+	close(s.stopChan)
+	if started {
+		<-s.doneChan
+	}
+
+	if err := s.elector.Resign(); err != nil {
+		s.logger.WithError(err).Warn("couldn't resign leadership")
+	}
+	leading.Set(0)
+}
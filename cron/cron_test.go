@@ -0,0 +1,199 @@
+package cron
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/opsee/gmunch"
+	log "github.com/opsee/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		spec, from, next string
+	}{
+		{"* * * * *", "2016-03-01 10:00", "2016-03-01 10:01"},
+		{"*/15 * * * *", "2016-03-01 10:07", "2016-03-01 10:15"},
+		{"0 9-17/4 * * *", "2016-03-01 13:00", "2016-03-01 17:00"},
+		{"30 2 * * mon-fri", "2016-03-04 03:00", "2016-03-07 02:30"},
+		{"0 0 1,15 * *", "2016-03-02 00:00", "2016-03-15 00:00"},
+		{"0 0 29 feb *", "2016-03-01 00:00", "2020-02-29 00:00"},
+		{"0 0 13 * 5", "2016-03-01 00:00", "2016-03-04 00:00"},
+		{"0 0 * * 7", "2016-03-01 00:00", "2016-03-06 00:00"},
+		{"@monthly", "2016-12-31 23:59", "2017-01-01 00:00"},
+		{"@hourly", "2016-03-01 10:00", "2016-03-01 11:00"},
+		{"@every 2m", "2016-03-01 10:01", "2016-03-01 10:02"},
+	} {
+		schedule, err := Parse(tc.spec)
+		if !assert.NoError(err, tc.spec) {
+			continue
+		}
+
+		assert.Equal(date(tc.next), schedule.Next(date(tc.from)).UTC(), tc.spec)
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every 10ms", "* * * smarch *"} {
+		_, err := Parse(spec)
+		assert.Error(err, spec)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := New([]Job{
+		{Name: "skip", Schedule: "*/10 * * * *"},
+		{Name: "latest", Schedule: "*/10 * * * *", CatchUp: Latest},
+		{Name: "all", Schedule: "*/10 * * * *", CatchUp: All},
+	}, Config{MaxCatchUp: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := date("2016-03-01 10:00")
+	now := date("2016-03-01 11:00").Add(30 * time.Second)
+	recent := date("2016-03-01 11:00")
+
+	ticks, skipped, through := s.due(s.jobs[0], last, now)
+	assert.Equal([]time.Time{recent}, ticks)
+	assert.Equal(5, skipped)
+	assert.Equal(recent, through)
+
+	ticks, skipped, _ = s.due(s.jobs[1], last, now)
+	assert.Equal([]time.Time{date("2016-03-01 10:50"), recent}, ticks)
+	assert.Equal(4, skipped)
+
+	ticks, skipped, _ = s.due(s.jobs[2], last, now)
+	assert.Equal([]time.Time{date("2016-03-01 10:30"), date("2016-03-01 10:40"), date("2016-03-01 10:50"), recent}, ticks)
+	assert.Equal(2, skipped)
+
+	// a missed tick with nothing recent still moves the job along
+	ticks, skipped, through = s.due(s.jobs[0], last, date("2016-03-01 10:15"))
+	assert.Len(ticks, 0)
+	assert.Equal(1, skipped)
+	assert.Equal(date("2016-03-01 10:10"), through)
+}
+
+type testElector struct {
+	leader   bool
+	resigned bool
+}
+
+func (e *testElector) Leader() (bool, error) {
+	return e.leader, nil
+}
+
+func (e *testElector) Resign() error {
+	e.resigned = true
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	elector := &testElector{}
+	state := NewMemoryState()
+	s, err := New([]Job{
+		{Name: "report", Schedule: "0 * * * *", Event: "send_report", Data: []byte("weekly"), CatchUp: All},
+	}, Config{Elector: elector, State: state, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitted := []*gmunch.Event{}
+	fail := false
+	emit := func(event *gmunch.Event) error {
+		if fail {
+			return errors.New("producer is down")
+		}
+		emitted = append(emitted, event)
+		return nil
+	}
+
+	// a new job starts from its first run
+	assert.Equal(0, s.run(date("2016-03-01 10:30"), emit))
+	last, _ := state.LastRun("report")
+	assert.True(last.IsZero())
+
+	elector.leader = true
+	assert.Equal(0, s.run(date("2016-03-01 10:30"), emit))
+	last, _ = state.LastRun("report")
+	assert.Equal(date("2016-03-01 10:30"), last)
+
+	// failed ticks are tried again
+	fail = true
+	assert.Equal(0, s.run(date("2016-03-01 11:00"), emit))
+	fail = false
+	assert.Equal(2, s.run(date("2016-03-01 12:00"), emit))
+	assert.Equal(0, s.run(date("2016-03-01 12:00"), emit))
+
+	if assert.Len(emitted, 2) {
+		assert.Equal("send_report", emitted[0].Name)
+		assert.Equal([]byte("weekly"), emitted[0].Data)
+		assert.Equal("report", emitted[0].Header(HeaderJob))
+		assert.Equal("2016-03-01T11:00:00Z", emitted[0].Header(HeaderTick))
+		assert.Equal("2016-03-01T12:00:00Z", emitted[1].Header(HeaderTick))
+
+		// ticks have the same ID wherever they're fired
+		assert.Equal(emitted[0].Id, s.jobs[0].event(date("2016-03-01 11:00")).Id)
+	}
+
+	s.Stop()
+	assert.True(elector.resigned)
+
+	_, err = New([]Job{{Name: "bad", Schedule: "* * *"}}, Config{})
+	assert.Error(err)
+	_, err = New([]Job{{Name: "../bad", Schedule: "* * * * *"}}, Config{})
+	assert.Error(err)
+	_, err = New([]Job{{Name: "twice", Schedule: "@daily"}, {Name: "twice", Schedule: "@hourly"}}, Config{})
+	assert.Error(err)
+}
+
+func TestFileState(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "cron")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state, err := NewFileState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := state.LastRun("report")
+	assert.NoError(err)
+	assert.True(last.IsZero())
+
+	tick := date("2016-03-01 11:00")
+	assert.NoError(state.SetLastRun("report", tick))
+	assert.Error(state.SetLastRun("../report", tick))
+
+	state, err = NewFileState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err = state.LastRun("report")
+	assert.NoError(err)
+	assert.True(tick.Equal(last))
+}
@@ -0,0 +1,147 @@
+package cron
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/opsee/gmunch"
+	"golang.org/x/net/context"
+)
+
+// DefaultLeaseTTL is how long an EtcdElector's leadership lasts without
+// being renewed.
+const DefaultLeaseTTL = 15 * time.Second
+
+// An Elector decides which of the instances running a scheduler fires its
+// ticks. Implementations must be safe for concurrent use.
+type Elector interface {
+	// Leader reports whether this instance is the leader, campaigning to
+	// become it, or renewing its leadership, as need be.
+	Leader() (bool, error)
+
+	// Resign gives up leadership if this instance has it.
+	Resign() error
+}
+
+// LocalElector always leads. It's for running a single instance.
+type LocalElector struct{}
+
+func (LocalElector) Leader() (bool, error) {
+	return true, nil
+}
+
+func (LocalElector) Resign() error {
+	return nil
+}
+
+// EtcdElector leads while it holds a key in etcd, which it creates with a
+// TTL if nobody holds it, and renews while it does. An instance that dies
+// loses leadership when the key expires.
+//
+// Leadership is a lease, so an instance that stalls for longer than the TTL
+// may fire a tick the new leader also fires. Emitted events have IDs derived
+// from their job and tick, so workers with a dedupe store skip the second.
+type EtcdElector struct {
+	keys    etcd.KeysAPI
+	key     string
+	id      string
+	ttl     time.Duration
+	leader  bool
+	checked time.Time
+	mut     sync.Mutex
+}
+
+// NewEtcdElector campaigns for key, with leadership lasting ttl,
+// DefaultLeaseTTL if it's zero.
+func NewEtcdElector(endpoints []string, key string, ttl time.Duration) (*EtcdElector, error) {
+	client, err := etcd.New(etcd.Config{
+		Endpoints:               endpoints,
+		Transport:               etcd.DefaultTransport,
+		HeaderTimeoutPerRequest: time.Second,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+
+	host, _ := os.Hostname()
+	return &EtcdElector{
+		keys: etcd.NewKeysAPI(client),
+		key:  key,
+		id:   fmt.Sprintf("%s-%s", host, gmunch.NewEventID()),
+		ttl:  ttl,
+	}, nil
+}
+
+// Leader talks to etcd at most every third of the TTL, so that a leader
+// renews well before its key expires without a request on every check.
+func (e *EtcdElector) Leader() (bool, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if time.Since(e.checked) < e.ttl/3 {
+		return e.leader, nil
+	}
+
+	var err error
+	if e.leader {
+		_, err = e.keys.Set(context.Background(), e.key, e.id, &etcd.SetOptions{
+			PrevValue: e.id,
+			TTL:       e.ttl,
+		})
+	} else {
+		_, err = e.keys.Set(context.Background(), e.key, e.id, &etcd.SetOptions{
+			PrevExist: etcd.PrevNoExist,
+			TTL:       e.ttl,
+		})
+	}
+
+	if err != nil {
+		e.leader = false
+		if etcdErr, ok := err.(etcd.Error); ok {
+			switch etcdErr.Code {
+			case etcd.ErrorCodeNodeExist, etcd.ErrorCodeTestFailed, etcd.ErrorCodeKeyNotFound:
+				// somebody else leads, or our key expired
+				e.checked = time.Now()
+				return false, nil
+			}
+		}
+
+		return false, err
+	}
+
+	e.leader = true
+	e.checked = time.Now()
+	return true, nil
+}
+
+func (e *EtcdElector) Resign() error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if !e.leader {
+		return nil
+	}
+
+	e.leader = false
+	e.checked = time.Time{}
+	_, err := e.keys.Delete(context.Background(), e.key, &etcd.DeleteOptions{
+		PrevValue: e.id,
+	})
+
+	if etcdErr, ok := err.(etcd.Error); ok {
+		switch etcdErr.Code {
+		case etcd.ErrorCodeTestFailed, etcd.ErrorCodeKeyNotFound:
+			return nil
+		}
+	}
+
+	return err
+}
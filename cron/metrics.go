@@ -0,0 +1,37 @@
+package cron
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	firedTicks = metrics.NewCounterVec(
+		"gmunch_cron_fired_ticks_total",
+		"Ticks whose events were emitted, by job.",
+		"job",
+	)
+
+	skippedTicks = metrics.NewCounterVec(
+		"gmunch_cron_skipped_ticks_total",
+		"Missed ticks dropped by the job's catch-up policy, by job.",
+		"job",
+	)
+
+	emitErrors = metrics.NewCounterVec(
+		"gmunch_cron_emit_errors_total",
+		"Failures emitting a tick's event, which is tried again later, by job.",
+		"job",
+	)
+
+	lateness = metrics.NewHistogramVec(
+		"gmunch_cron_lateness_seconds",
+		"How long after their tick jobs' events were emitted, by job.",
+		nil,
+		"job",
+	)
+
+	leading = metrics.NewGauge(
+		"gmunch_cron_leader",
+		"Whether this instance is the one firing ticks.",
+	)
+)
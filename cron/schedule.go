@@ -0,0 +1,222 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule says when a job's ticks are.
+type Schedule interface {
+	// Next returns the first tick after t, or the zero time if there
+	// isn't one in the next five years.
+	Next(t time.Time) time.Time
+}
+
+// Parse parses a schedule. It takes the usual five field cron expressions:
+//
+//	minute hour day-of-month month day-of-week
+//
+// where each field is *, a value, a range like 1-5, or a list of them like
+// 0,30, optionally with a step like */15 or 8-18/2. Months and days of the
+// week may be given by their three letter names, and Sunday is 0 or 7. As
+// with cron, when both day fields are restricted, a day matching either
+// counts. The descriptors @yearly, @monthly, @weekly, @daily and @hourly,
+// and "@every <duration>" for fixed intervals of at least a second, work
+// too.
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("cron: %s", err)
+		}
+
+		if d < time.Second {
+			return nil, fmt.Errorf("cron: interval %s is under a second", d)
+		}
+
+		return every(d.Truncate(time.Second)), nil
+	}
+
+	if e, ok := descriptors[expr]; ok {
+		expr = e
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields in %q, got %d", expr, len(fields))
+	}
+
+	s := &spec{}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+
+	if s.month, err = parseField(fields[3], 1, 12, months); err != nil {
+		return nil, err
+	}
+
+	if s.dow, err = parseField(fields[4], 0, 7, days); err != nil {
+		return nil, err
+	}
+
+	// 7 is another Sunday
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+
+	s.domAny = fields[2] == "*" || fields[2] == "?"
+	s.dowAny = fields[4] == "*" || fields[4] == "?"
+
+	return s, nil
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var months = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var days = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// bits is a set of the values a field matches.
+type bits uint64
+
+func (b bits) has(v int) bool {
+	return b&(1<<uint(v)) != 0
+}
+
+func parseField(field string, min, max int, names map[string]int) (bits, error) {
+	var b bits
+	for _, part := range strings.Split(field, ",") {
+		lo, hi, step := min, max, 1
+
+		rng := part
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron: bad step in %q", part)
+			}
+			step = n
+			rng = part[:i]
+		}
+
+		if rng != "*" && rng != "?" {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = parseValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+
+			switch {
+			case len(bounds) == 2:
+				if hi, err = parseValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			case step == 1:
+				// a lone value, rather than a value with a step, which
+				// runs to the end of the range
+				hi = lo
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("cron: %q is out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			b |= 1 << uint(v)
+		}
+	}
+
+	return b, nil
+}
+
+func parseValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: bad value %q", s)
+	}
+
+	return v, nil
+}
+
+type spec struct {
+	minute, hour, dom, month, dow bits
+	domAny, dowAny                bool
+}
+
+func (s *spec) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !s.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !s.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !s.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *spec) dayMatches(t time.Time) bool {
+	dom := s.dom.has(t.Day())
+	dow := s.dow.has(int(t.Weekday()))
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// every is a fixed interval, with ticks at multiples of it since the epoch
+// so that every instance agrees on when they are.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	d := time.Duration(e)
+	return t.Truncate(d).Add(d)
+}
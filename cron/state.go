@@ -0,0 +1,158 @@
+package cron
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// State remembers the last tick each job fired. Implementations must be
+// safe for concurrent use.
+type State interface {
+	// LastRun returns the job's last tick, or the zero time if it's never
+	// run.
+	LastRun(job string) (time.Time, error)
+
+	SetLastRun(job string, t time.Time) error
+}
+
+// MemoryState keeps jobs' last ticks in memory, so they're lost when the
+// process exits.
+type MemoryState struct {
+	runs map[string]time.Time
+	mut  sync.Mutex
+}
+
+func NewMemoryState() *MemoryState {
+	return &MemoryState{runs: make(map[string]time.Time)}
+}
+
+func (s *MemoryState) LastRun(job string) (time.Time, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.runs[job], nil
+}
+
+func (s *MemoryState) SetLastRun(job string, t time.Time) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.runs[job] = t
+	return nil
+}
+
+// FileState keeps each job's last tick in a file named for the job in a
+// local directory. It carries missed ticks across restarts of a single
+// instance.
+type FileState struct {
+	dir string
+}
+
+// NewFileState keeps state in dir, creating it if need be.
+func NewFileState(dir string) (*FileState, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileState{dir: dir}, nil
+}
+
+func (s *FileState) LastRun(job string) (time.Time, error) {
+	if err := validName(job); err != nil {
+		return time.Time{}, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, job))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+}
+
+// SetLastRun writes to a temporary file and renames it into place, so that
+// a crash never leaves a partial time behind.
+func (s *FileState) SetLastRun(job string, t time.Time) error {
+	if err := validName(job); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(t.UTC().Format(time.RFC3339Nano)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(s.dir, job)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// EtcdState keeps jobs' last ticks in etcd under a prefix, so that whichever
+// instance leads next knows what's been fired.
+type EtcdState struct {
+	keys   etcd.KeysAPI
+	prefix string
+}
+
+func NewEtcdState(endpoints []string, prefix string) (*EtcdState, error) {
+	client, err := etcd.New(etcd.Config{
+		Endpoints:               endpoints,
+		Transport:               etcd.DefaultTransport,
+		HeaderTimeoutPerRequest: time.Second,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdState{
+		keys:   etcd.NewKeysAPI(client),
+		prefix: prefix,
+	}, nil
+}
+
+func (s *EtcdState) LastRun(job string) (time.Time, error) {
+	resp, err := s.keys.Get(context.Background(), path.Join(s.prefix, job), &etcd.GetOptions{
+		Quorum: true,
+	})
+
+	if err != nil {
+		if etcdErr, ok := err.(etcd.Error); ok && etcdErr.Code == etcd.ErrorCodeKeyNotFound {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, err
+	}
+
+	return time.Parse(time.RFC3339Nano, resp.Node.Value)
+}
+
+func (s *EtcdState) SetLastRun(job string, t time.Time) error {
+	_, err := s.keys.Set(context.Background(), path.Join(s.prefix, job), t.UTC().Format(time.RFC3339Nano), nil)
+	return err
+}
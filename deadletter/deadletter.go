@@ -0,0 +1,103 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/producer"
+)
+
+// EventName is the name of the events a ProducerSink publishes. Dispatch it
+// to a handler that calls Decode to get the dead letter back.
+const EventName = "gmunch.dead_letter"
+
+// A DeadLetter is something the pipeline gave up on: either a record that
+// couldn't be decoded into an event at all, or an event whose tasks failed
+// for good.
+type DeadLetter struct {
+	// Payload is the raw record, if it couldn't be decoded.
+	Payload []byte `json:"payload,omitempty"`
+
+	// Event is the decoded event, if there is one.
+	Event *gmunch.Event `json:"event,omitempty"`
+
+	// Reason says why the letter is dead.
+	Reason string `json:"reason"`
+
+	// Origin is where the record was read from.
+	Origin *gmunch.Origin `json:"origin,omitempty"`
+
+	// Tasks lists the failed tasks, if the event was dispatched.
+	Tasks []string `json:"tasks,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// A Sink receives dead letters. Implementations must be safe for concurrent
+// use.
+type Sink interface {
+	Send(*DeadLetter) error
+}
+
+// ProducerSink publishes dead letters through a producer, typically one
+// configured for a separate dead letter stream.
+type ProducerSink struct {
+	producer producer.Producer
+}
+
+func NewProducerSink(p producer.Producer) *ProducerSink {
+	return &ProducerSink{producer: p}
+}
+
+func (s *ProducerSink) Send(letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	return s.producer.Publish(&gmunch.Event{
+		Name: EventName,
+		Id:   gmunch.NewEventID(),
+		Data: data,
+	})
+}
+
+// Decode unpacks a dead letter published by a ProducerSink.
+func Decode(event *gmunch.Event) (*DeadLetter, error) {
+	if event.Name != EventName {
+		return nil, fmt.Errorf("not a dead letter: %s", event.Name)
+	}
+
+	letter := &DeadLetter{}
+	err := json.Unmarshal(event.Data, letter)
+	return letter, err
+}
+
+// Replay publishes the events in dead letters back into the pipeline. Raw
+// payloads are decoded if possible, since the failure may have been ours.
+// Letters that still can't be decoded are skipped and counted. Replay stops
+// at the first publish error.
+func Replay(letters []*DeadLetter, p producer.Producer) (replayed, skipped int, err error) {
+	for _, letter := range letters {
+		event := &gmunch.Event{}
+		if letter.Event != nil {
+			*event = *letter.Event
+		} else if proto.Unmarshal(letter.Payload, event) != nil || event.Name == "" {
+			skipped++
+			continue
+		}
+
+		// the origin is where it was read last time, not where it's going
+		event.Origin = nil
+
+		if err = p.Publish(event); err != nil {
+			return
+		}
+		replayed++
+	}
+
+	return
+}
@@ -0,0 +1,87 @@
+package deadletter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opsee/gmunch"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProducer struct {
+	events []*gmunch.Event
+}
+
+func (p *testProducer) Publish(event *gmunch.Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestFileSinkReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.jsonl")
+
+	recoverable, err := proto.Marshal(&gmunch.Event{Name: "recovered", Id: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := &gmunch.Origin{Source: "kinesis", Shard: "shardId-000000000000", Sequence: "1"}
+	assert.NoError(sink.Send(&DeadLetter{
+		Event:  &gmunch.Event{Name: "cool", Id: "1", Origin: origin},
+		Reason: "*main.Task: downstream is down",
+		Origin: origin,
+		Tasks:  []string{"*main.Task"},
+		Time:   time.Now(),
+	}))
+	assert.NoError(sink.Send(&DeadLetter{Payload: recoverable, Reason: "our bad"}))
+	assert.NoError(sink.Send(&DeadLetter{Payload: []byte("garbage"), Reason: "proto: bad wiretype"}))
+	assert.NoError(sink.Close())
+
+	letters, err := ReadFile(path)
+	assert.NoError(err)
+	assert.Len(letters, 3)
+	assert.Equal("cool", letters[0].Event.Name)
+	assert.Equal("shardId-000000000000", letters[0].Origin.Shard)
+
+	p := &testProducer{}
+	replayed, skipped, err := Replay(letters, p)
+	assert.NoError(err)
+	assert.Equal(2, replayed)
+	assert.Equal(1, skipped)
+	assert.Equal("cool", p.events[0].Name)
+	assert.Nil(p.events[0].Origin)
+	assert.Equal("recovered", p.events[1].Name)
+}
+
+func TestProducerSink(t *testing.T) {
+	assert := assert.New(t)
+
+	p := &testProducer{}
+	sink := NewProducerSink(p)
+	assert.NoError(sink.Send(&DeadLetter{Event: &gmunch.Event{Name: "cool"}, Reason: "nope"}))
+	assert.Len(p.events, 1)
+
+	letter, err := Decode(p.events[0])
+	assert.NoError(err)
+	assert.Equal("cool", letter.Event.Name)
+	assert.Equal("nope", letter.Reason)
+
+	_, err = Decode(&gmunch.Event{Name: "cool"})
+	assert.Error(err)
+}
@@ -0,0 +1,39 @@
+package deadletter
+
+import (
+	"sync"
+)
+
+// MemorySink keeps dead letters in memory. It's handy for tests and for
+// short-lived tools that replay what they collect.
+type MemorySink struct {
+	letters []*DeadLetter
+	mut     sync.Mutex
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Send(letter *DeadLetter) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+// Letters returns the dead letters received so far.
+func (s *MemorySink) Letters() []*DeadLetter {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]*DeadLetter(nil), s.letters...)
+}
+
+// Drain returns the dead letters received so far and forgets them.
+func (s *MemorySink) Drain() []*DeadLetter {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	letters := s.letters
+	s.letters = nil
+	return letters
+}
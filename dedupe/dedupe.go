@@ -0,0 +1,36 @@
+// Package dedupe remembers which events have been processed, so that the
+// worker can skip the duplicates that redelivery and client retries cause.
+package dedupe
+
+import (
+	"time"
+)
+
+// DefaultTTL is how long completed events are remembered by default. It
+// should outlast the window in which duplicates turn up, e.g. the stream's
+// retention if consumers may restart from an old checkpoint.
+const DefaultTTL = 24 * time.Hour
+
+// DefaultLease is how long a claim on an event lasts by default. It should
+// outlast handling the event, retries included, or a copy that turns up
+// after it runs out runs alongside the first.
+const DefaultLease = 10 * time.Minute
+
+// A Store records completed event IDs, and claims on the events being
+// handled, so that copies of an event that arrive together don't all run.
+// Implementations must be safe for concurrent use, and may forget IDs after
+// a TTL.
+type Store interface {
+	// Begin claims the event with the given ID for up to lease, reporting
+	// false if it's complete or somebody else's claim on it is live. The
+	// claim is checked and taken atomically.
+	Begin(id string, lease time.Duration) (bool, error)
+
+	// Complete records that the event with the given ID is complete,
+	// replacing the claim on it.
+	Complete(id string) error
+
+	// Abandon gives up a claim on the event with the given ID without
+	// completing it, so that the next copy can run.
+	Abandon(id string) error
+}
@@ -0,0 +1,145 @@
+package dedupe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewMemoryStore(2, time.Hour)
+	for _, id := range []string{"1", "2"} {
+		completed, err := s.Completed(id)
+		assert.NoError(err)
+		assert.False(completed)
+		assert.NoError(s.Complete(id))
+	}
+
+	completed, _ := s.Completed("1")
+	assert.True(completed)
+
+	// 2 is least recently used, so it goes first
+	assert.NoError(s.Complete("3"))
+	assert.Equal(2, s.Len())
+	completed, _ = s.Completed("2")
+	assert.False(completed)
+	completed, _ = s.Completed("1")
+	assert.True(completed)
+
+	s = NewMemoryStore(0, time.Millisecond)
+	assert.NoError(s.Complete("1"))
+	time.Sleep(5 * time.Millisecond)
+	completed, _ = s.Completed("1")
+	assert.False(completed)
+	assert.Equal(0, s.Len())
+
+	testClaims(t, NewMemoryStore(0, time.Hour))
+}
+
+// completedStore is a Store that reports completed events, as all of the
+// package's stores do.
+type completedStore interface {
+	Store
+	Completed(id string) (bool, error)
+}
+
+// testClaims checks that a store's claims keep copies of an event from
+// running together, and that abandoned and expired claims let them run.
+func testClaims(t *testing.T, s completedStore) {
+	assert := assert.New(t)
+
+	claimed, err := s.Begin("a", time.Hour)
+	assert.NoError(err)
+	assert.True(claimed)
+	claimed, _ = s.Begin("a", time.Hour)
+	assert.False(claimed)
+	completed, _ := s.Completed("a")
+	assert.False(completed)
+
+	assert.NoError(s.Abandon("a"))
+	claimed, _ = s.Begin("a", time.Hour)
+	assert.True(claimed)
+
+	assert.NoError(s.Complete("a"))
+	completed, _ = s.Completed("a")
+	assert.True(completed)
+	claimed, _ = s.Begin("a", time.Hour)
+	assert.False(claimed)
+
+	// abandoning doesn't forget a completed event
+	assert.NoError(s.Abandon("a"))
+	completed, _ = s.Completed("a")
+	assert.True(completed)
+
+	claimed, _ = s.Begin("b", time.Millisecond)
+	assert.True(claimed)
+	time.Sleep(5 * time.Millisecond)
+	claimed, _ = s.Begin("b", time.Hour)
+	assert.True(claimed)
+}
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "dedupe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "completed")
+
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(s.Complete("1"))
+	assert.NoError(s.Complete("2"))
+	assert.Error(s.Complete("3\n4"))
+	assert.NoError(s.Close())
+
+	// a crash mid-write leaves a torn line, and expired IDs are forgotten
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	fmt.Fprintf(f, "%d expired\n1234", time.Now().Add(-time.Minute).Unix())
+	f.Close()
+
+	s, err = NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for id, want := range map[string]bool{"1": true, "2": true, "expired": false, "3": false} {
+		completed, err := s.Completed(id)
+		assert.NoError(err)
+		assert.Equal(want, completed, id)
+	}
+
+	// the log is compacted as it grows
+	for i := 0; i <= compactSlack; i++ {
+		assert.NoError(s.Complete("1"))
+	}
+	assert.True(s.lines < compactSlack)
+
+	completed, _ := s.Completed("2")
+	assert.True(completed)
+	assert.NoError(s.Complete("5"))
+
+	reopened, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	completed, _ = reopened.Completed("5")
+	assert.True(completed)
+	completed, _ = reopened.Completed("2")
+	assert.True(completed)
+
+	testClaims(t, reopened)
+}
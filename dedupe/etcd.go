@@ -0,0 +1,113 @@
+package dedupe
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/opsee/gmunch"
+	"golang.org/x/net/context"
+)
+
+// claimPrefix starts the value of a claimed event's key, which is followed
+// by the ID of the store holding the claim. Completed events' keys hold the
+// time they were completed.
+const claimPrefix = "claimed by "
+
+// EtcdStore keeps completed event IDs in etcd, under a prefix, with the TTL
+// set on each key so that etcd forgets them. Claims are keys created only
+// if there isn't one, with the lease as their TTL. It dedupes across every
+// worker sharing the prefix.
+type EtcdStore struct {
+	keys   etcd.KeysAPI
+	prefix string
+	ttl    time.Duration
+	claim  string
+}
+
+// NewEtcdStore stores IDs under prefix for ttl, DefaultTTL if it's zero.
+func NewEtcdStore(endpoints []string, prefix string, ttl time.Duration) (*EtcdStore, error) {
+	client, err := etcd.New(etcd.Config{
+		Endpoints:               endpoints,
+		Transport:               etcd.DefaultTransport,
+		HeaderTimeoutPerRequest: time.Second,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	host, _ := os.Hostname()
+	return &EtcdStore{
+		keys:   etcd.NewKeysAPI(client),
+		prefix: prefix,
+		ttl:    ttl,
+		claim:  fmt.Sprintf("%s%s-%s", claimPrefix, host, gmunch.NewEventID()),
+	}, nil
+}
+
+// Completed reports whether the event with the given ID has been recorded
+// as complete.
+func (s *EtcdStore) Completed(id string) (bool, error) {
+	resp, err := s.keys.Get(context.Background(), path.Join(s.prefix, id), &etcd.GetOptions{
+		Quorum: true,
+	})
+
+	if err != nil {
+		if etcdErr, ok := err.(etcd.Error); ok && etcdErr.Code == etcd.ErrorCodeKeyNotFound {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return !strings.HasPrefix(resp.Node.Value, claimPrefix), nil
+}
+
+func (s *EtcdStore) Begin(id string, lease time.Duration) (bool, error) {
+	_, err := s.keys.Set(context.Background(), path.Join(s.prefix, id), s.claim, &etcd.SetOptions{
+		PrevExist: etcd.PrevNoExist,
+		TTL:       lease,
+	})
+
+	if err != nil {
+		if etcdErr, ok := err.(etcd.Error); ok && etcdErr.Code == etcd.ErrorCodeNodeExist {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *EtcdStore) Complete(id string) error {
+	_, err := s.keys.Set(context.Background(), path.Join(s.prefix, id), time.Now().UTC().Format(time.RFC3339), &etcd.SetOptions{
+		TTL: s.ttl,
+	})
+	return err
+}
+
+// Abandon only deletes our own claim, leaving alone a completion or the
+// claim somebody else took once ours ran out.
+func (s *EtcdStore) Abandon(id string) error {
+	_, err := s.keys.Delete(context.Background(), path.Join(s.prefix, id), &etcd.DeleteOptions{
+		PrevValue: s.claim,
+	})
+
+	if etcdErr, ok := err.(etcd.Error); ok {
+		switch etcdErr.Code {
+		case etcd.ErrorCodeTestFailed, etcd.ErrorCodeKeyNotFound:
+			return nil
+		}
+	}
+
+	return err
+}
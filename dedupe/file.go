@@ -0,0 +1,212 @@
+package dedupe
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the log is compacted once it's this many lines past twice the live IDs
+const compactSlack = 1000
+
+// FileStore keeps completed event IDs in a local append-only log, synced on
+// every write, so that they survive restarts of a single worker. The live
+// IDs are also kept in memory, and the log is rewritten without the expired
+// ones as it grows. Claims are only kept in memory, since they'd be
+// abandoned by a restart anyway.
+type FileStore struct {
+	path    string
+	ttl     time.Duration
+	file    *os.File
+	expires map[string]time.Time
+	claims  map[string]time.Time
+	lines   int
+	mut     sync.Mutex
+}
+
+// NewFileStore opens or creates the log at path, remembering IDs for ttl,
+// DefaultTTL if it's zero.
+func NewFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	s := &FileStore{
+		path:    path,
+		ttl:     ttl,
+		expires: make(map[string]time.Time),
+		claims:  make(map[string]time.Time),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s.lines++
+
+		// a torn last line from a crash is skipped
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		unix, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if expires := time.Unix(unix, 0); expires.After(now) {
+			s.expires[parts[1]] = expires
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Completed reports whether the event with the given ID has been recorded
+// as complete.
+func (s *FileStore) Completed(id string) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	expires, ok := s.expires[id]
+	if ok && time.Now().After(expires) {
+		delete(s.expires, id)
+		return false, nil
+	}
+
+	return ok, nil
+}
+
+func (s *FileStore) Begin(id string, lease time.Duration) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := time.Now()
+	if expires, ok := s.expires[id]; ok && !now.After(expires) {
+		return false, nil
+	}
+
+	if expires, ok := s.claims[id]; ok && !now.After(expires) {
+		return false, nil
+	}
+
+	s.claims[id] = now.Add(lease)
+	return true, nil
+}
+
+func (s *FileStore) Complete(id string) error {
+	if strings.ContainsAny(id, "\n") {
+		return fmt.Errorf("dedupe: invalid event id %q", id)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	expires := time.Now().Add(s.ttl)
+	if _, err := fmt.Fprintf(s.file, "%d %s\n", expires.Unix(), id); err != nil {
+		return err
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.expires[id] = expires
+	delete(s.claims, id)
+	s.lines++
+
+	if s.lines > 2*len(s.expires)+compactSlack {
+		return s.compact()
+	}
+
+	return nil
+}
+
+func (s *FileStore) Abandon(id string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.claims, id)
+	return nil
+}
+
+// compact rewrites the log with only the live IDs. Callers must hold mut.
+func (s *FileStore) compact() error {
+	now := time.Now()
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	lines := 0
+	for id, expires := range s.expires {
+		if now.After(expires) {
+			delete(s.expires, id)
+			continue
+		}
+
+		fmt.Fprintf(w, "%d %s\n", expires.Unix(), id)
+		lines++
+	}
+
+	if err := w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.file.Close()
+	s.file = f
+	s.lines = lines
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.file.Close()
+}
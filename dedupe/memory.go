@@ -0,0 +1,131 @@
+package dedupe
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const defaultMemorySize = 100000
+
+type memoryEntry struct {
+	id      string
+	expires time.Time
+	claimed bool
+}
+
+// MemoryStore is an LRU of completed and claimed event IDs that also
+// forgets them after a TTL or lease. It only dedupes within one process,
+// which is enough for client retries and a consumer rereading after a
+// hiccup.
+type MemoryStore struct {
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+	mut     sync.Mutex
+}
+
+// NewMemoryStore remembers up to size IDs for ttl each. Zero values mean
+// 100000 IDs and DefaultTTL.
+func NewMemoryStore(size int, ttl time.Duration) *MemoryStore {
+	if size <= 0 {
+		size = defaultMemorySize
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &MemoryStore{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Completed reports whether the event with the given ID has been recorded
+// as complete.
+func (s *MemoryStore) Completed(id string) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	elem, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+
+	entry := elem.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		s.remove(elem)
+		return false, nil
+	}
+
+	s.lru.MoveToFront(elem)
+	return !entry.claimed, nil
+}
+
+func (s *MemoryStore) Begin(id string, lease time.Duration) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if elem, ok := s.entries[id]; ok {
+		if !time.Now().After(elem.Value.(*memoryEntry).expires) {
+			return false, nil
+		}
+		s.remove(elem)
+	}
+
+	s.set(id, time.Now().Add(lease), true)
+	return true, nil
+}
+
+func (s *MemoryStore) Complete(id string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.set(id, time.Now().Add(s.ttl), false)
+	return nil
+}
+
+func (s *MemoryStore) Abandon(id string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if elem, ok := s.entries[id]; ok && elem.Value.(*memoryEntry).claimed {
+		s.remove(elem)
+	}
+
+	return nil
+}
+
+// set adds or updates an entry, evicting the least recently used ones past
+// the size. Callers must hold mut.
+func (s *MemoryStore) set(id string, expires time.Time, claimed bool) {
+	if elem, ok := s.entries[id]; ok {
+		entry := elem.Value.(*memoryEntry)
+		entry.expires = expires
+		entry.claimed = claimed
+		s.lru.MoveToFront(elem)
+		return
+	}
+
+	s.entries[id] = s.lru.PushFront(&memoryEntry{id: id, expires: expires, claimed: claimed})
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+	}
+}
+
+// Len returns the number of IDs remembered, including claimed ones and
+// expired ones that haven't been evicted yet.
+func (s *MemoryStore) Len() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryStore) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*memoryEntry).id)
+}
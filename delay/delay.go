@@ -0,0 +1,193 @@
+// Package delay holds events that aren't to be handled until later. A
+// worker that's given an event with a delivery time in the future stores it
+// instead of dispatching it, and a Timer dispatches it once it's due. Stores
+// that keep events on disk carry them across restarts.
+package delay
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/metrics"
+	log "github.com/opsee/logrus"
+)
+
+const (
+	// DefaultInterval is how often a Timer looks for due events.
+	DefaultInterval = time.Second
+
+	// how many due events a Timer takes from the store at a time
+	batchSize = 100
+)
+
+// A Store holds delayed events until they're due. Events are keyed by ID,
+// so adding one again replaces it. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	Add(event *gmunch.Event) error
+
+	// Due returns up to limit of the events due at or before t, earliest
+	// first. Events it can't read are skipped, and reported in the error
+	// alongside the ones it could.
+	Due(t time.Time, limit int) ([]*gmunch.Event, error)
+
+	Remove(event *gmunch.Event) error
+}
+
+// IsDue reports whether the event should be handled by t.
+func IsDue(event *gmunch.Event, t time.Time) bool {
+	return !event.DeliveryTime().After(t)
+}
+
+func validID(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\*?[`) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("delay: invalid event id %q", id)
+	}
+
+	return nil
+}
+
+// A Timer takes events from a store as they come due and hands them to a
+// delivery function, removing them once it's succeeded. An event whose
+// delivery fails stays in the store for the next round, so delivery is at
+// least once, and the events due after it are delivered in the meantime.
+type Timer struct {
+	store    Store
+	deliver  func(*gmunch.Event) error
+	interval time.Duration
+	logger   *log.Entry
+
+	started  bool
+	stopChan chan struct{}
+	doneChan chan struct{}
+	mut      sync.Mutex
+}
+
+// NewTimer delivers due events from store every interval, which defaults to
+// DefaultInterval. A nil logger means the standard logger.
+func NewTimer(store Store, deliver func(*gmunch.Event) error, interval time.Duration, logger *log.Logger) *Timer {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	return &Timer{
+		store:    store,
+		deliver:  deliver,
+		interval: interval,
+		logger:   logger.WithField("delay", "timer"),
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+// Schedule stores an event to be delivered at its delivery time, giving it
+// an ID if it doesn't have one.
+func (t *Timer) Schedule(event *gmunch.Event) error {
+	if event.Id == "" {
+		event.Id = gmunch.NewEventID()
+	}
+
+	if err := t.store.Add(event); err != nil {
+		scheduleErrors.With(metrics.EventLabel(event.Name)).Inc()
+		return err
+	}
+
+	scheduledEvents.With(metrics.EventLabel(event.Name)).Inc()
+	return nil
+}
+
+// Start runs the timer in the background until Stop is called.
+func (t *Timer) Start() {
+	t.mut.Lock()
+	t.started = true
+	t.mut.Unlock()
+
+	go func() {
+		defer close(t.doneChan)
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		for {
+			t.Run()
+
+			select {
+			case <-ticker.C:
+			case <-t.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Run delivers everything that's due once, returning how many events were
+// delivered. Events that fail are left for the next round.
+func (t *Timer) Run() int {
+	n := 0
+
+	// failed events are still in the store, so each batch asks for enough
+	// to get past them
+	failed := make(map[string]bool)
+	for {
+		limit := batchSize + len(failed)
+		events, err := t.store.Due(time.Now(), limit)
+		if err != nil {
+			t.logger.WithError(err).Error("couldn't get all of the due events")
+			if len(events) == 0 {
+				return n
+			}
+		}
+
+		for _, event := range events {
+			select {
+			case <-t.stopChan:
+				return n
+			default:
+			}
+
+			if failed[event.Id] {
+				continue
+			}
+
+			logger := t.logger.WithFields(event.LogFields())
+			lateness.With(metrics.EventLabel(event.Name)).Observe(time.Since(event.DeliveryTime()).Seconds())
+
+			if err := t.deliver(event); err != nil {
+				logger.WithError(err).Error("couldn't deliver delayed event")
+				deliveryErrors.With(metrics.EventLabel(event.Name)).Inc()
+				failed[event.Id] = true
+				continue
+			}
+
+			if err := t.store.Remove(event); err != nil {
+				logger.WithError(err).Error("couldn't remove delivered event from the store")
+			}
+
+			deliveredEvents.With(metrics.EventLabel(event.Name)).Inc()
+			n++
+		}
+
+		if len(events) < limit {
+			return n
+		}
+	}
+}
+
+// Stop stops the timer, waiting for a delivery in progress to finish.
+func (t *Timer) Stop() {
+	t.mut.Lock()
+	started := t.started
+	t.mut.Unlock()
+
+	close(t.stopChan)
+	if started {
+		<-t.doneChan
+	}
+}
@@ -0,0 +1,170 @@
+package delay
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opsee/gmunch"
+	log "github.com/opsee/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent(id string, at time.Time) *gmunch.Event {
+	event := &gmunch.Event{Name: "trial_expired", Id: id, Data: []byte(id)}
+	event.SetDeliveryTime(at)
+	return event
+}
+
+func testStore(t *testing.T, store Store) {
+	assert := assert.New(t)
+	now := time.Now()
+
+	assert.NoError(store.Add(testEvent("later", now.Add(time.Hour))))
+	assert.NoError(store.Add(testEvent("second", now.Add(-time.Minute))))
+	assert.NoError(store.Add(testEvent("first", now.Add(-time.Hour))))
+	assert.NoError(store.Add(testEvent("x-first", now.Add(-time.Second))))
+	assert.Error(store.Add(testEvent("../escape", now)))
+
+	due, err := store.Due(now, 10)
+	assert.NoError(err)
+	ids := []string{}
+	for _, event := range due {
+		ids = append(ids, event.Id)
+	}
+	assert.Equal([]string{"first", "second", "x-first"}, ids)
+	assert.Equal([]byte("first"), due[0].Data)
+
+	due, err = store.Due(now, 1)
+	assert.NoError(err)
+	assert.Len(due, 1)
+
+	// adding an event again reschedules it
+	assert.NoError(store.Add(testEvent("first", now.Add(2*time.Hour))))
+	due, err = store.Due(now, 10)
+	assert.NoError(err)
+	assert.Len(due, 2)
+	assert.Equal("second", due[0].Id)
+
+	assert.NoError(store.Remove(due[0]))
+	due, err = store.Due(now.Add(3*time.Hour), 10)
+	assert.NoError(err)
+	ids = []string{}
+	for _, event := range due {
+		ids = append(ids, event.Id)
+	}
+	assert.Equal([]string{"x-first", "later", "first"}, ids)
+
+	// removing a stale copy leaves the rescheduled event alone
+	assert.NoError(store.Remove(testEvent("first", now.Add(-time.Hour))))
+	due, err = store.Due(now.Add(3*time.Hour), 10)
+	assert.NoError(err)
+	assert.Len(due, 3)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	// a new store on the same directory picks up where it left off
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := store.Due(time.Now().Add(3*time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Len(t, due, 3)
+
+	// a truncated file is reported and moved aside, and doesn't hold up
+	// the events after it
+	data, _ := proto.Marshal(testEvent("truncated", time.Now().Add(-2*time.Hour)))
+	truncated := name(testEvent("truncated", time.Now().Add(-2*time.Hour)))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, truncated), data[:len(data)-3], 0644))
+
+	due, err = store.Due(time.Now().Add(3*time.Hour), 10)
+	assert.Error(t, err)
+	assert.Len(t, due, 3)
+	_, err = os.Stat(filepath.Join(dir, CorruptPrefix+truncated))
+	assert.NoError(t, err)
+
+	due, err = store.Due(time.Now().Add(3*time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Len(t, due, 3)
+}
+
+func TestTimer(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	store := NewMemoryStore()
+	delivered := make(chan *gmunch.Event, 10)
+	fail := true
+	timer := NewTimer(store, func(event *gmunch.Event) error {
+		if fail {
+			return errors.New("worker is busy")
+		}
+
+		delivered <- event
+		return nil
+	}, 10*time.Millisecond, logger)
+
+	event := &gmunch.Event{Name: "trial_expired"}
+	event.SetDeliveryTime(time.Now().Add(-time.Second))
+	assert.NoError(timer.Schedule(event))
+	assert.NotEmpty(event.Id)
+	assert.NoError(timer.Schedule(testEvent("later", time.Now().Add(time.Hour))))
+
+	// failed deliveries stay in the store
+	assert.Equal(0, timer.Run())
+	assert.Equal(2, store.Len())
+
+	fail = false
+	timer.Start()
+	select {
+	case got := <-delivered:
+		assert.Equal(event.Id, got.Id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+	timer.Stop()
+
+	assert.Equal(1, store.Len())
+	assert.Len(delivered, 0)
+
+	// a failed delivery doesn't hold up the events due after it
+	store = NewMemoryStore()
+	timer = NewTimer(store, func(event *gmunch.Event) error {
+		if event.Id == "blocked" {
+			return errors.New("downstream is down")
+		}
+
+		delivered <- event
+		return nil
+	}, time.Hour, logger)
+	assert.NoError(timer.Schedule(testEvent("blocked", time.Now().Add(-2*time.Minute))))
+	assert.NoError(timer.Schedule(testEvent("after", time.Now().Add(-time.Minute))))
+
+	assert.Equal(1, timer.Run())
+	assert.Equal("after", (<-delivered).Id)
+	assert.Equal(1, store.Len())
+}
@@ -0,0 +1,180 @@
+package delay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opsee/gmunch"
+)
+
+// FileStore keeps each delayed event in its own file in a local directory,
+// named for its delivery time so that listing the directory lists events in
+// the order they're due. Events survive restarts, but only on the host that
+// stored them. Files that don't hold an event are moved aside, with a
+// CorruptPrefix, to be looked at.
+type FileStore struct {
+	dir string
+}
+
+// CorruptPrefix starts the names of files moved aside because they don't
+// hold an event. Like the temporary files, they're hidden from listings.
+const CorruptPrefix = ".corrupt-"
+
+// NewFileStore stores events in dir, creating it if need be.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// name is the event's file name: its zero padded delivery time, then its
+// ID.
+func name(event *gmunch.Event) string {
+	deliverAt := event.DeliverAt
+	if deliverAt < 0 {
+		deliverAt = 0
+	}
+
+	return fmt.Sprintf("%020d-%s", deliverAt, event.Id)
+}
+
+// Add writes the event to a temporary file, syncs it and renames it into
+// place, so that a crash never leaves a partial event behind. Any earlier
+// version of the event is removed after.
+func (s *FileStore) Add(event *gmunch.Event) error {
+	if err := validID(event.Id); err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	path := filepath.Join(s.dir, name(event))
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	previous, err := filepath.Glob(filepath.Join(s.dir, "*-"+event.Id))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range previous {
+		// the pattern also matches IDs that end in this one
+		if id := strings.SplitN(filepath.Base(p), "-", 2)[1]; p != path && id == event.Id {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) Due(t time.Time, limit int) ([]*gmunch.Event, error) {
+	// ReadDir sorts by name, which is delivery time order
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := t.UnixNano() / int64(time.Millisecond)
+	events := []*gmunch.Event{}
+	errs := []string{}
+	for _, fi := range files {
+		if len(events) == limit {
+			break
+		}
+
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+
+		i := strings.IndexByte(fi.Name(), '-')
+		if i < 0 {
+			continue
+		}
+
+		deliverAt, err := strconv.ParseInt(fi.Name()[:i], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if deliverAt > now {
+			break
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, fi.Name()))
+		if os.IsNotExist(err) {
+			// removed since we listed the directory
+			continue
+		}
+		if err != nil {
+			// it may be readable next time
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		event := &gmunch.Event{}
+		if err := proto.Unmarshal(data, event); err != nil {
+			errs = append(errs, fmt.Sprintf("%s is corrupt, moving it aside: %s", fi.Name(), err))
+			if err := os.Rename(filepath.Join(s.dir, fi.Name()), filepath.Join(s.dir, CorruptPrefix+fi.Name())); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if len(errs) > 0 {
+		return events, fmt.Errorf("delay: %s", strings.Join(errs, "; "))
+	}
+
+	return events, nil
+}
+
+func (s *FileStore) Remove(event *gmunch.Event) error {
+	if err := validID(event.Id); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.dir, name(event)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,77 @@
+package delay
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opsee/gmunch"
+)
+
+// MemoryStore keeps delayed events in memory, so they're lost when the
+// process exits. It's for tests and for events that can afford that.
+type MemoryStore struct {
+	events map[string]*gmunch.Event
+	mut    sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{events: make(map[string]*gmunch.Event)}
+}
+
+func (s *MemoryStore) Add(event *gmunch.Event) error {
+	if err := validID(event.Id); err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.events[event.Id] = event
+	return nil
+}
+
+func (s *MemoryStore) Due(t time.Time, limit int) ([]*gmunch.Event, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	due := []*gmunch.Event{}
+	for _, event := range s.events {
+		if IsDue(event, t) {
+			due = append(due, event)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].DeliverAt != due[j].DeliverAt {
+			return due[i].DeliverAt < due[j].DeliverAt
+		}
+		return due[i].Id < due[j].Id
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+func (s *MemoryStore) Remove(event *gmunch.Event) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	// the event may have been rescheduled since it was due
+	if stored, ok := s.events[event.Id]; ok && stored.DeliverAt == event.DeliverAt {
+		delete(s.events, event.Id)
+	}
+
+	return nil
+}
+
+// Len returns the number of events waiting.
+func (s *MemoryStore) Len() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return len(s.events)
+}
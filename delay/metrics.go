@@ -0,0 +1,38 @@
+package delay
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	scheduledEvents = metrics.NewCounterVec(
+		"gmunch_delay_scheduled_events_total",
+		"Events stored to be handled later, by event name.",
+		"name",
+	)
+
+	scheduleErrors = metrics.NewCounterVec(
+		"gmunch_delay_schedule_errors_total",
+		"Failures storing delayed events, by event name.",
+		"name",
+	)
+
+	deliveredEvents = metrics.NewCounterVec(
+		"gmunch_delay_delivered_events_total",
+		"Delayed events delivered once they were due, by event name.",
+		"name",
+	)
+
+	deliveryErrors = metrics.NewCounterVec(
+		"gmunch_delay_delivery_errors_total",
+		"Failures delivering due events, which are tried again later, by event name.",
+		"name",
+	)
+
+	lateness = metrics.NewHistogramVec(
+		"gmunch_delay_lateness_seconds",
+		"How long after their delivery time delayed events were delivered, by event name.",
+		nil,
+		"name",
+	)
+)
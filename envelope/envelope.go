@@ -0,0 +1,262 @@
+// Package envelope encrypts event data with envelope encryption: each
+// event's data is sealed with a data key, and the data key is itself
+// encrypted by a master key that never leaves the key provider. The
+// encrypted data key travels with the event, so a consumer with access to
+// the provider can open it.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opsee/gmunch"
+)
+
+const (
+	// HeaderKeyID is the event header naming the master key that encrypted
+	// the event's data key.
+	HeaderKeyID = "encryption-key-id"
+
+	// HeaderDataKey is the event header holding the encrypted data key,
+	// base64 encoded. Events with it are encrypted.
+	HeaderDataKey = "encryption-data-key"
+)
+
+const (
+	dataKeySize      = 32
+	defaultMaxKeyAge = 5 * time.Minute
+	maxCachedKeys    = 1000
+)
+
+var errCompressed = errors.New("envelope: event data is already compressed; compress after encrypting, in the producer")
+
+// A DataKey is a key for encrypting event data, both in the clear and
+// encrypted under the master key KeyID.
+type DataKey struct {
+	KeyID     string
+	Plaintext []byte
+	Encrypted []byte
+}
+
+// A KeyProvider makes and opens data keys. Implementations must be safe for
+// concurrent use.
+type KeyProvider interface {
+	// GenerateDataKey returns a new data key encrypted under the current
+	// master key.
+	GenerateDataKey() (*DataKey, error)
+
+	// DecryptDataKey opens a data key encrypted under the master key
+	// keyID.
+	DecryptDataKey(keyID string, encrypted []byte) ([]byte, error)
+}
+
+type Config struct {
+	// Provider makes and opens data keys.
+	Provider KeyProvider
+
+	// Names are the event names to encrypt. Other events are sent in the
+	// clear. Encrypted events are decrypted whatever their name.
+	Names []string
+
+	// MaxKeyAge is how long a data key is used before a new one is
+	// generated, which saves a provider call per event. Defaults to five
+	// minutes.
+	MaxKeyAge time.Duration
+}
+
+// An Encrypter encrypts the data of opted in events and decrypts any
+// encrypted event.
+type Encrypter struct {
+	provider  KeyProvider
+	names     map[string]bool
+	maxKeyAge time.Duration
+
+	current   *sealingKey
+	decrypted map[string]cipher.AEAD
+	mut       sync.Mutex
+}
+
+type sealingKey struct {
+	key     *DataKey
+	aead    cipher.AEAD
+	created time.Time
+}
+
+func New(config Config) *Encrypter {
+	if config.MaxKeyAge <= 0 {
+		config.MaxKeyAge = defaultMaxKeyAge
+	}
+
+	names := make(map[string]bool, len(config.Names))
+	for _, name := range config.Names {
+		names[name] = true
+	}
+
+	return &Encrypter{
+		provider:  config.Provider,
+		names:     names,
+		maxKeyAge: config.MaxKeyAge,
+		decrypted: make(map[string]cipher.AEAD),
+	}
+}
+
+// IsEncrypted reports whether the event's data is encrypted.
+func IsEncrypted(event *gmunch.Event) bool {
+	return event.Header(HeaderDataKey) != ""
+}
+
+// Encrypts reports whether events with the given name are encrypted.
+func (e *Encrypter) Encrypts(name string) bool {
+	return e.names[name]
+}
+
+// Encrypt encrypts the event's data if its name is opted in. Events that
+// are already encrypted are left alone. The event name is authenticated
+// along with the data, so ciphertext can't be passed off as another kind of
+// event.
+func (e *Encrypter) Encrypt(event *gmunch.Event) error {
+	if !e.Encrypts(event.Name) || IsEncrypted(event) {
+		return nil
+	}
+
+	// encrypted data doesn't compress, and the consumer would decompress
+	// before we decrypt
+	if event.Header(gmunch.HeaderContentEncoding) != "" {
+		return errCompressed
+	}
+
+	key, err := e.sealingKey()
+	if err != nil {
+		encryptErrors.With(event.Name).Inc()
+		return err
+	}
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	event.Data = key.aead.Seal(nonce, nonce, event.Data, []byte(event.Name))
+	event.SetHeader(HeaderKeyID, key.key.KeyID)
+	event.SetHeader(HeaderDataKey, base64.StdEncoding.EncodeToString(key.key.Encrypted))
+	encryptedEvents.With(event.Name).Inc()
+
+	return nil
+}
+
+// Decrypt decrypts the event's data and removes the encryption headers. It
+// does nothing to events that aren't encrypted.
+func (e *Encrypter) Decrypt(event *gmunch.Event) error {
+	if !IsEncrypted(event) {
+		return nil
+	}
+
+	data, err := e.open(event)
+	if err != nil {
+		decryptErrors.With(event.Name).Inc()
+		return err
+	}
+
+	event.Data = data
+	delete(event.Headers, HeaderKeyID)
+	delete(event.Headers, HeaderDataKey)
+	return nil
+}
+
+func (e *Encrypter) open(event *gmunch.Event) ([]byte, error) {
+	keyID := event.Header(HeaderKeyID)
+	encrypted, err := base64.StdEncoding.DecodeString(event.Header(HeaderDataKey))
+	if err != nil {
+		return nil, fmt.Errorf("envelope: bad data key: %s", err)
+	}
+
+	aead, err := e.openingKey(keyID, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(event.Data) < aead.NonceSize() {
+		return nil, errors.New("envelope: encrypted data is too short")
+	}
+
+	nonce, sealed := event.Data[:aead.NonceSize()], event.Data[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, sealed, []byte(event.Name))
+	if err != nil {
+		return nil, fmt.Errorf("envelope: couldn't decrypt event data: %s", err)
+	}
+
+	return data, nil
+}
+
+// sealingKey returns the data key to encrypt with, generating a new one
+// when the current one gets too old.
+func (e *Encrypter) sealingKey() (*sealingKey, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.current != nil && time.Since(e.current.created) < e.maxKeyAge {
+		return e.current, nil
+	}
+
+	key, err := e.provider.GenerateDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("envelope: couldn't generate data key: %s", err)
+	}
+
+	aead, err := newAEAD(key.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	dataKeysGenerated.With().Inc()
+
+	e.current = &sealingKey{key: key, aead: aead, created: time.Now()}
+	return e.current, nil
+}
+
+// openingKey returns the cipher for an encrypted data key. Producers reuse
+// data keys, so we cache them rather than asking the provider every time.
+func (e *Encrypter) openingKey(keyID string, encrypted []byte) (cipher.AEAD, error) {
+	cacheKey := keyID + "\x00" + string(encrypted)
+
+	e.mut.Lock()
+	aead, ok := e.decrypted[cacheKey]
+	e.mut.Unlock()
+
+	if ok {
+		return aead, nil
+	}
+
+	plaintext, err := e.provider.DecryptDataKey(keyID, encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: couldn't decrypt data key: %s", err)
+	}
+
+	aead, err = newAEAD(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mut.Lock()
+	if len(e.decrypted) >= maxCachedKeys {
+		e.decrypted = make(map[string]cipher.AEAD)
+	}
+	e.decrypted[cacheKey] = aead
+	e.mut.Unlock()
+
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: bad data key: %s", err)
+	}
+
+	return cipher.NewGCM(block)
+}
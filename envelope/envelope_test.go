@@ -0,0 +1,166 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/opsee/gmunch"
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, dataKeySize)
+}
+
+func TestEncrypt(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := NewStaticKeys("one", map[string][]byte{"one": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(Config{Provider: keys, Names: []string{"signup"}})
+	data := []byte("compuper@merkmertin")
+
+	// not opted in
+	event := &gmunch.Event{Name: "test_event", Data: data}
+	assert.NoError(e.Encrypt(event))
+	assert.Equal(data, event.Data)
+	assert.False(IsEncrypted(event))
+
+	event = &gmunch.Event{Name: "signup", Data: data}
+	assert.NoError(e.Encrypt(event))
+	assert.True(IsEncrypted(event))
+	assert.Equal("one", event.Header(HeaderKeyID))
+	assert.False(bytes.Contains(event.Data, data))
+
+	// encrypting twice is harmless
+	encrypted := event.Data
+	assert.NoError(e.Encrypt(event))
+	assert.Equal(encrypted, event.Data)
+
+	// a consumer only needs the keys
+	consumer := New(Config{Provider: keys})
+	assert.NoError(consumer.Decrypt(event))
+	assert.Equal(data, event.Data)
+	assert.False(IsEncrypted(event))
+	assert.Equal("", event.Header(HeaderKeyID))
+
+	// compressed data can't be encrypted
+	event = &gmunch.Event{Name: "signup", Data: data}
+	event.SetHeader(gmunch.HeaderContentEncoding, gmunch.EncodingGzip)
+	assert.Error(e.Encrypt(event))
+}
+
+func TestTampering(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, _ := NewStaticKeys("one", map[string][]byte{"one": testKey(1)})
+	e := New(Config{Provider: keys, Names: []string{"signup", "delete_account"}})
+
+	event := &gmunch.Event{Name: "signup", Data: []byte("compuper@merkmertin")}
+	assert.NoError(e.Encrypt(event))
+
+	renamed := *event
+	renamed.Name = "delete_account"
+	assert.Error(e.Decrypt(&renamed))
+
+	flipped := *event
+	flipped.Data = append([]byte(nil), event.Data...)
+	flipped.Data[len(flipped.Data)-1] ^= 1
+	assert.Error(e.Decrypt(&flipped))
+
+	other, _ := NewStaticKeys("one", map[string][]byte{"one": testKey(2)})
+	assert.Error(New(Config{Provider: other}).Decrypt(event))
+}
+
+func TestRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := ParseStaticKeys("one=" + base64.StdEncoding.EncodeToString(testKey(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a tiny max age means a new data key per event
+	e := New(Config{Provider: keys, Names: []string{"signup"}, MaxKeyAge: 1})
+
+	before := &gmunch.Event{Name: "signup", Data: []byte("before")}
+	assert.NoError(e.Encrypt(before))
+
+	assert.NoError(keys.Rotate("two", testKey(2)))
+	assert.Equal("two", keys.Current())
+
+	after := &gmunch.Event{Name: "signup", Data: []byte("after")}
+	assert.NoError(e.Encrypt(after))
+	assert.Equal("two", after.Header(HeaderKeyID))
+
+	assert.Error(keys.Retire("two"))
+	assert.NoError(New(Config{Provider: keys}).Decrypt(before))
+	assert.Equal([]byte("before"), before.Data)
+
+	assert.NoError(keys.Retire("one"))
+	assert.NoError(e.Encrypt(before))
+	assert.NoError(New(Config{Provider: keys}).Decrypt(before))
+
+	_, err = ParseStaticKeys("one=short")
+	assert.Error(err)
+	_, err = NewStaticKeys("missing", map[string][]byte{"one": testKey(1)})
+	assert.Error(err)
+}
+
+type fakeKMS struct {
+	keys      *StaticKeys
+	generated int
+	decrypted int
+}
+
+func (k *fakeKMS) GenerateDataKey(keyID string) ([]byte, []byte, error) {
+	if keyID != "alias/gmunch" {
+		return nil, nil, errors.New("no such key")
+	}
+
+	k.generated++
+	key, err := k.keys.GenerateDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key.Plaintext, key.Encrypted, nil
+}
+
+func (k *fakeKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	k.decrypted++
+	return k.keys.DecryptDataKey(k.keys.Current(), ciphertext)
+}
+
+func TestKMSProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, _ := NewStaticKeys("master", map[string][]byte{"master": testKey(1)})
+	kms := &fakeKMS{keys: keys}
+	e := New(Config{Provider: NewKMSProvider(kms, "alias/gmunch"), Names: []string{"signup"}})
+
+	events := []*gmunch.Event{}
+	for i := 0; i < 10; i++ {
+		event := &gmunch.Event{Name: "signup", Data: []byte{byte(i)}}
+		assert.NoError(e.Encrypt(event))
+		assert.Equal("alias/gmunch", event.Header(HeaderKeyID))
+		events = append(events, event)
+	}
+
+	for i, event := range events {
+		assert.NoError(e.Decrypt(event))
+		assert.Equal([]byte{byte(i)}, event.Data)
+	}
+
+	// data keys are reused and cached
+	assert.Equal(1, kms.generated)
+	assert.Equal(1, kms.decrypted)
+
+	e = New(Config{Provider: NewKMSProvider(kms, "alias/missing"), Names: []string{"signup"}})
+	assert.Error(e.Encrypt(&gmunch.Event{Name: "signup"}))
+}
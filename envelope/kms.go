@@ -0,0 +1,44 @@
+package envelope
+
+// KMSAPI is the part of a KMS client that KMSProvider uses, small enough
+// to adapt the aws-sdk-go kms client or a compatible service like vault's
+// transit backend to. Decrypt gets the key from the ciphertext, as KMS
+// does.
+type KMSAPI interface {
+	GenerateDataKey(keyID string) (plaintext, ciphertext []byte, err error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// KMSProvider is a key provider backed by a key management service, which
+// keeps the master keys and does the data key encryption. Rotating the
+// master key is up to the service.
+type KMSProvider struct {
+	client KMSAPI
+	keyID  string
+}
+
+// NewKMSProvider generates data keys under the master key keyID, which can
+// be whatever the service accepts, e.g. an ARN or an alias.
+func NewKMSProvider(client KMSAPI, keyID string) *KMSProvider {
+	return &KMSProvider{
+		client: client,
+		keyID:  keyID,
+	}
+}
+
+func (p *KMSProvider) GenerateDataKey() (*DataKey, error) {
+	plaintext, ciphertext, err := p.client.GenerateDataKey(p.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataKey{
+		KeyID:     p.keyID,
+		Plaintext: plaintext,
+		Encrypted: ciphertext,
+	}, nil
+}
+
+func (p *KMSProvider) DecryptDataKey(keyID string, encrypted []byte) ([]byte, error) {
+	return p.client.Decrypt(encrypted)
+}
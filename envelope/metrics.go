@@ -0,0 +1,30 @@
+package envelope
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	encryptedEvents = metrics.NewCounterVec(
+		"gmunch_envelope_encrypted_events_total",
+		"Events whose data was encrypted, by event name.",
+		"name",
+	)
+
+	encryptErrors = metrics.NewCounterVec(
+		"gmunch_envelope_encrypt_errors_total",
+		"Events that couldn't be encrypted, by event name.",
+		"name",
+	)
+
+	decryptErrors = metrics.NewCounterVec(
+		"gmunch_envelope_decrypt_errors_total",
+		"Events that couldn't be decrypted, by event name.",
+		"name",
+	)
+
+	dataKeysGenerated = metrics.NewCounterVec(
+		"gmunch_envelope_data_keys_generated_total",
+		"Data keys requested from the key provider.",
+	)
+)
@@ -0,0 +1,153 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// StaticKeys is a key provider with master keys held in memory, e.g. read
+// from the environment. The current key encrypts new data keys, and older
+// keys are kept so that events encrypted before a rotation can still be
+// read.
+type StaticKeys struct {
+	current string
+	keys    map[string]cipher.AEAD
+	mut     sync.RWMutex
+}
+
+// NewStaticKeys creates a provider from 32 byte master keys by key ID,
+// encrypting with the key current.
+func NewStaticKeys(current string, keys map[string][]byte) (*StaticKeys, error) {
+	k := &StaticKeys{keys: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if err := k.add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := k.keys[current]; !ok {
+		return nil, fmt.Errorf("envelope: no key with id %q", current)
+	}
+	k.current = current
+
+	return k, nil
+}
+
+// ParseStaticKeys creates a provider from a list of id=key pairs separated
+// by commas, where keys are base64 encoded. The first key is current.
+func ParseStaticKeys(spec string) (*StaticKeys, error) {
+	var current string
+	keys := make(map[string][]byte)
+
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("envelope: keys must be id=base64 pairs")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("envelope: key %s isn't base64: %s", parts[0], err)
+		}
+
+		if current == "" {
+			current = parts[0]
+		}
+		keys[parts[0]] = key
+	}
+
+	return NewStaticKeys(current, keys)
+}
+
+func (k *StaticKeys) add(id string, key []byte) error {
+	if len(key) != dataKeySize {
+		return fmt.Errorf("envelope: key %s is %d bytes, not %d", id, len(key), dataKeySize)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	k.keys[id] = aead
+	return nil
+}
+
+// Rotate adds a master key and makes it current. Data keys generated from
+// now on are encrypted with it.
+func (k *StaticKeys) Rotate(id string, key []byte) error {
+	k.mut.Lock()
+	defer k.mut.Unlock()
+
+	if err := k.add(id, key); err != nil {
+		return err
+	}
+	k.current = id
+
+	return nil
+}
+
+// Retire removes an old master key, once nothing encrypted with it is left
+// in the stream. The current key can't be retired.
+func (k *StaticKeys) Retire(id string) error {
+	k.mut.Lock()
+	defer k.mut.Unlock()
+
+	if id == k.current {
+		return fmt.Errorf("envelope: can't retire the current key %s", id)
+	}
+	delete(k.keys, id)
+
+	return nil
+}
+
+// Current returns the ID of the current master key.
+func (k *StaticKeys) Current() string {
+	k.mut.RLock()
+	defer k.mut.RUnlock()
+	return k.current
+}
+
+func (k *StaticKeys) GenerateDataKey() (*DataKey, error) {
+	k.mut.RLock()
+	id := k.current
+	aead := k.keys[id]
+	k.mut.RUnlock()
+
+	plaintext := make([]byte, dataKeySize)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &DataKey{
+		KeyID:     id,
+		Plaintext: plaintext,
+		Encrypted: aead.Seal(nonce, nonce, plaintext, []byte(id)),
+	}, nil
+}
+
+func (k *StaticKeys) DecryptDataKey(id string, encrypted []byte) ([]byte, error) {
+	k.mut.RLock()
+	aead, ok := k.keys[id]
+	k.mut.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("envelope: no key with id %q", id)
+	}
+
+	if len(encrypted) < aead.NonceSize() {
+		return nil, errors.New("envelope: encrypted data key is too short")
+	}
+
+	return aead.Open(nil, encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():], []byte(id))
+}
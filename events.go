@@ -2,9 +2,17 @@ package gmunch
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/gob"
+	"fmt"
+	"time"
 )
 
+// HeaderPartitionKey is the event header holding its partition key. Events
+// with the same key are put on the same kinesis shard, and an ordered worker
+// runs them one at a time.
+const HeaderPartitionKey = "partition-key"
+
 type Decoder interface {
 	Decode(interface{}) error
 }
@@ -14,6 +22,15 @@ func init() {
 	gob.Register([]interface{}{})
 }
 
+// NewEventID returns a random (version 4) UUID for identifying an event.
+func NewEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
 func (event *Event) EncodeData(data interface{}) error {
 	var b bytes.Buffer
 	err := gob.NewEncoder(&b).Encode(data)
@@ -24,6 +41,72 @@ func (event *Event) EncodeData(data interface{}) error {
 	return nil
 }
 
+// Decoder returns a decoder for data encoded with EncodeData. Compressed
+// data is decompressed as it's read.
 func (event *Event) Decoder() Decoder {
-	return gob.NewDecoder(bytes.NewBuffer(event.Data))
+	r, err := event.dataReader()
+	if err != nil {
+		return errDecoder{err}
+	}
+
+	return gob.NewDecoder(r)
+}
+
+// Header returns the value of the named header, or the empty string if it
+// isn't set.
+func (event *Event) Header(key string) string {
+	return event.GetHeaders()[key]
+}
+
+// SetHeader sets a header on the event, allocating the header map if this is
+// the first one.
+func (event *Event) SetHeader(key, value string) {
+	if event.Headers == nil {
+		event.Headers = make(map[string]string)
+	}
+	event.Headers[key] = value
+}
+
+// DeliveryTime returns when the event should be handled, or the zero time
+// if it should be handled right away.
+func (event *Event) DeliveryTime() time.Time {
+	if event.DeliverAt <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, event.DeliverAt*int64(time.Millisecond))
+}
+
+// SetDeliveryTime sets when the event should be handled, to the millisecond.
+// The zero time means right away.
+func (event *Event) SetDeliveryTime(t time.Time) {
+	if t.IsZero() {
+		event.DeliverAt = 0
+		return
+	}
+
+	event.DeliverAt = t.UnixNano() / int64(time.Millisecond)
+}
+
+// LogFields returns the structured fields that identify this event in logs.
+func (event *Event) LogFields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"name": event.Name,
+		"id":   event.Id,
+	}
+
+	if origin := event.GetOrigin(); origin != nil {
+		fields["source"] = origin.Source
+		fields["shard"] = origin.Shard
+		fields["sequence"] = origin.Sequence
+		if origin.SubSequence > 0 {
+			fields["sub_sequence"] = origin.SubSequence
+		}
+	}
+
+	if event.DeliverAt > 0 {
+		fields["deliver_at"] = event.DeliveryTime()
+	}
+
+	return fields
 }
@@ -6,10 +6,13 @@
 Package gmunch is a generated protocol buffer package.
 
 It is generated from these files:
+
 	events.proto
 
 It has these top-level messages:
+
 	Event
+	Origin
 	Response
 */
 package gmunch
@@ -35,8 +38,14 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type Event struct {
-	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
-	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Name    string            `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Data    []byte            `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Headers map[string]string `protobuf:"bytes,3,rep,name=headers" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Id      string            `protobuf:"bytes,4,opt,name=id" json:"id,omitempty"`
+	Origin  *Origin           `protobuf:"bytes,5,opt,name=origin" json:"origin,omitempty"`
+	// deliver_at is when the event should be handled, in Unix milliseconds.
+	// Zero means right away.
+	DeliverAt int64 `protobuf:"varint,6,opt,name=deliver_at,json=deliverAt" json:"deliver_at,omitempty"`
 }
 
 func (m *Event) Reset()                    { *m = Event{} }
@@ -44,6 +53,34 @@ func (m *Event) String() string            { return proto.CompactTextString(m) }
 func (*Event) ProtoMessage()               {}
 func (*Event) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
+func (m *Event) GetHeaders() map[string]string {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *Event) GetOrigin() *Origin {
+	if m != nil {
+		return m.Origin
+	}
+	return nil
+}
+
+// Origin records where a consumer read an event from.
+type Origin struct {
+	Source   string `protobuf:"bytes,1,opt,name=source" json:"source,omitempty"`
+	Shard    string `protobuf:"bytes,2,opt,name=shard" json:"shard,omitempty"`
+	Sequence string `protobuf:"bytes,3,opt,name=sequence" json:"sequence,omitempty"`
+	// sub_sequence is the event's index within an aggregated record.
+	SubSequence uint64 `protobuf:"varint,4,opt,name=sub_sequence,json=subSequence" json:"sub_sequence,omitempty"`
+}
+
+func (m *Origin) Reset()                    { *m = Origin{} }
+func (m *Origin) String() string            { return proto.CompactTextString(m) }
+func (*Origin) ProtoMessage()               {}
+func (*Origin) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
 type Response struct {
 	Ok bool `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
 }
@@ -51,10 +88,11 @@ type Response struct {
 func (m *Response) Reset()                    { *m = Response{} }
 func (m *Response) String() string            { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()               {}
-func (*Response) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*Response) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
 func init() {
 	proto.RegisterType((*Event)(nil), "gmunch.Event")
+	proto.RegisterType((*Origin)(nil), "gmunch.Origin")
 	proto.RegisterType((*Response)(nil), "gmunch.Response")
 }
 
@@ -133,15 +171,26 @@ var _Events_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("events.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 146 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0x49, 0x2d, 0x4b, 0xcd,
-	0x2b, 0x29, 0xd6, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x4b, 0xcf, 0x2d, 0xcd, 0x4b, 0xce,
-	0x50, 0xd2, 0xe7, 0x62, 0x75, 0x05, 0x89, 0x0b, 0x09, 0x71, 0xb1, 0xe4, 0x25, 0xe6, 0xa6, 0x4a,
-	0x30, 0x2a, 0x30, 0x6a, 0x70, 0x06, 0x81, 0xd9, 0x20, 0xb1, 0x94, 0xc4, 0x92, 0x44, 0x09, 0x26,
-	0xa0, 0x18, 0x4f, 0x10, 0x98, 0xad, 0x24, 0xc5, 0xc5, 0x11, 0x94, 0x5a, 0x5c, 0x90, 0x9f, 0x57,
-	0x9c, 0x2a, 0xc4, 0xc7, 0xc5, 0x94, 0x9f, 0x0d, 0xd6, 0xc1, 0x11, 0x04, 0x64, 0x19, 0x99, 0x71,
-	0xb1, 0x81, 0x0d, 0x2b, 0x16, 0xd2, 0xe1, 0x62, 0x0f, 0x28, 0x4d, 0xca, 0xc9, 0x2c, 0xce, 0x10,
-	0xe2, 0xd5, 0x83, 0x58, 0xa5, 0x07, 0x96, 0x92, 0x12, 0x80, 0x71, 0x61, 0xa6, 0x28, 0x31, 0x24,
-	0xb1, 0x81, 0xdd, 0x64, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff, 0x9f, 0xe2, 0xd6, 0x5b, 0xa3, 0x00,
-	0x00, 0x00,
+	// 321 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x54, 0x51, 0xc1, 0x4a, 0xc3, 0x40,
+	0x10, 0x35, 0x49, 0x9b, 0xb6, 0xd3, 0x58, 0xca, 0x20, 0x12, 0x02, 0x42, 0xcc, 0x41, 0x72, 0x90,
+	0x1c, 0xaa, 0x88, 0xf4, 0xe6, 0xa1, 0xe0, 0x4d, 0x59, 0x3f, 0xa0, 0x6c, 0x9a, 0xa1, 0x09, 0x6d,
+	0x77, 0xeb, 0x6e, 0xb6, 0xd0, 0x3f, 0xf7, 0x28, 0xd9, 0x24, 0x45, 0x6f, 0xf3, 0xde, 0xdb, 0x79,
+	0xc3, 0x7b, 0x0b, 0x01, 0x9d, 0x48, 0xd4, 0x3a, 0x3b, 0x2a, 0x59, 0x4b, 0xf4, 0xb7, 0x07, 0x23,
+	0x36, 0x65, 0xf2, 0xe3, 0xc0, 0x70, 0xd5, 0x08, 0x88, 0x30, 0x10, 0xfc, 0x40, 0xa1, 0x13, 0x3b,
+	0xe9, 0x84, 0xd9, 0xb9, 0xe1, 0x0a, 0x5e, 0xf3, 0xd0, 0x8d, 0x9d, 0x34, 0x60, 0x76, 0xc6, 0x67,
+	0x18, 0x95, 0xc4, 0x0b, 0x52, 0x3a, 0xf4, 0x62, 0x2f, 0x9d, 0x2e, 0xa2, 0xac, 0xf5, 0xca, 0xac,
+	0x4f, 0xf6, 0xde, 0x8a, 0x2b, 0x51, 0xab, 0x33, 0xeb, 0x9f, 0xe2, 0x0c, 0xdc, 0xaa, 0x08, 0x07,
+	0xd6, 0xdb, 0xad, 0x0a, 0x7c, 0x00, 0x5f, 0xaa, 0x6a, 0x5b, 0x89, 0x70, 0x18, 0x3b, 0xe9, 0x74,
+	0x31, 0xeb, 0x4d, 0x3e, 0x2c, 0xcb, 0x3a, 0x15, 0xef, 0x00, 0x0a, 0xda, 0x57, 0x27, 0x52, 0x6b,
+	0x5e, 0x87, 0x7e, 0xec, 0xa4, 0x1e, 0x9b, 0x74, 0xcc, 0x5b, 0x1d, 0x2d, 0x21, 0xf8, 0x7b, 0x0f,
+	0xe7, 0xe0, 0xed, 0xe8, 0xdc, 0x65, 0x68, 0x46, 0xbc, 0x81, 0xe1, 0x89, 0xef, 0x0d, 0xd9, 0x0c,
+	0x13, 0xd6, 0x82, 0xa5, 0xfb, 0xea, 0x24, 0x06, 0xfc, 0xf6, 0x18, 0xde, 0x82, 0xaf, 0xa5, 0x51,
+	0x9b, 0x3e, 0x7c, 0x87, 0x9a, 0x5d, 0x5d, 0x72, 0x55, 0xf4, 0xbb, 0x16, 0x60, 0x04, 0x63, 0x4d,
+	0xdf, 0x86, 0xc4, 0x86, 0x42, 0xcf, 0x0a, 0x17, 0x8c, 0xf7, 0x10, 0x68, 0x93, 0xaf, 0x2f, 0x7a,
+	0x13, 0x78, 0xc0, 0xa6, 0xda, 0xe4, 0x5f, 0x1d, 0x95, 0x44, 0x30, 0x66, 0xa4, 0x8f, 0x52, 0x68,
+	0x6a, 0x5a, 0x91, 0x3b, 0x7b, 0x74, 0xcc, 0x5c, 0xb9, 0x5b, 0xbc, 0x80, 0x6f, 0x4b, 0xd4, 0xf8,
+	0x08, 0xa3, 0x4f, 0x93, 0xef, 0x2b, 0x5d, 0xe2, 0xf5, 0xbf, 0x7e, 0xa3, 0x79, 0x0f, 0x7b, 0x97,
+	0xe4, 0x2a, 0xf7, 0xed, 0xa7, 0x3e, 0xfd, 0x0e, 0x00, 0x22, 0x44, 0x3a, 0x8a, 0xe4, 0x01, 0x00,
+	0x00,
 }
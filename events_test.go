@@ -2,6 +2,7 @@ package gmunch
 
 import (
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
@@ -52,3 +53,48 @@ func TestFieldsMap(t *testing.T) {
 	assert.Equal([]string{"read", "write", "admin"}, coolData.Permissions)
 	assert.True(coolData.Attributes["cool"].(bool))
 }
+
+func TestHeaders(t *testing.T) {
+	assert := assert.New(t)
+	coolEvent := &Event{Name: "cool"}
+	assert.Equal("", coolEvent.Header("traceparent"))
+
+	coolEvent.SetHeader("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	pbdata, err := proto.Marshal(coolEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := &Event{}
+	err = proto.Unmarshal(pbdata, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", event.Header("traceparent"))
+}
+
+func TestDeliveryTime(t *testing.T) {
+	assert := assert.New(t)
+	event := &Event{Name: "cool"}
+	assert.True(event.DeliveryTime().IsZero())
+
+	at := time.Date(2016, 5, 6, 14, 22, 58, 123456789, time.UTC)
+	event.SetDeliveryTime(at)
+	assert.Equal(at.Truncate(time.Millisecond), event.DeliveryTime().UTC())
+
+	pbdata, err := proto.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &Event{}
+	if err := proto.Unmarshal(pbdata, decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(event.DeliverAt, decoded.DeliverAt)
+
+	event.SetDeliveryTime(time.Time{})
+	assert.Equal(int64(0), event.DeliverAt)
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/tls"
 
 	"github.com/opsee/gmunch/client"
+	"github.com/opsee/gmunch/envelope"
+	"github.com/opsee/gmunch/signing"
 	"github.com/spf13/viper"
 )
 
@@ -16,6 +18,27 @@ func main() {
 			InsecureSkipVerify: true,
 		},
 	}
+
+	// test_event carries an email address, so encrypt it if we have keys
+	if spec := viper.GetString("encryption_keys"); spec != "" {
+		keys, err := envelope.ParseStaticKeys(spec)
+		if err != nil {
+			panic(err)
+		}
+
+		config.Encrypter = envelope.New(envelope.Config{
+			Provider: keys,
+			Names:    []string{"test_event"},
+		})
+	}
+	if spec := viper.GetString("signing_key"); spec != "" {
+		key, err := signing.ParseKey(spec)
+		if err != nil {
+			panic(err)
+		}
+		config.SigningKey = key
+	}
+
 	client, err := client.New(viper.GetString("address"), config)
 	if err != nil {
 		panic(err)
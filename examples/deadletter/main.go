@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/opsee/gmunch/deadletter"
+	producer "github.com/opsee/gmunch/producer/kinesis"
+	log "github.com/opsee/logrus"
+	"github.com/spf13/viper"
+)
+
+// replays the dead letters collected by a deadletter.FileSink back into the
+// kinesis stream
+func main() {
+	viper.SetEnvPrefix("gmunch")
+	viper.AutomaticEnv()
+
+	letters, err := deadletter.ReadFile(viper.GetString("dead_letter_file"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	replayed, skipped, err := deadletter.Replay(letters, producer.New(producer.Config{
+		Stream: viper.GetString("kinesis_stream"),
+		Region: viper.GetString("aws_region"),
+	}))
+
+	log.WithFields(log.Fields{
+		"replayed": replayed,
+		"skipped":  skipped,
+	}).Info("replayed dead letters")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,8 +1,8 @@
 package debug
 
 import (
-	log "github.com/opsee/logrus"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/worker"
 	"golang.org/x/net/context"
 )
 
@@ -11,10 +11,10 @@ type Job struct {
 	context context.Context
 }
 
-func New(evt *gmunch.Event) *Job {
+func New(ctx context.Context, evt *gmunch.Event) *Job {
 	return &Job{
 		event:   evt,
-		context: context.Background(),
+		context: ctx,
 	}
 }
 
@@ -23,15 +23,16 @@ func (j *Job) Context() context.Context {
 }
 
 func (j *Job) Execute() (interface{}, error) {
-	log.Infof("job: %s", j.event.Name)
+	logger := worker.Logger(j.context)
+	logger.Info("job")
 
 	stuff := make(map[string]interface{})
 	err := j.event.Decoder().Decode(&stuff)
 	if err != nil {
-		log.WithError(err).Error("couldn't decode fields")
+		logger.WithError(err).Error("couldn't decode fields")
 		return nil, err
 	}
 
-	log.Infof("fields: %#v", stuff)
+	logger.Infof("fields: %#v", stuff)
 	return struct{}{}, nil
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	consumer "github.com/opsee/gmunch/consumer/kinesis"
+	producer "github.com/opsee/gmunch/producer/kinesis"
+	log "github.com/opsee/logrus"
+	"github.com/spf13/viper"
+)
+
+// re-drives a window of the kinesis stream, e.g. after fixing a handler bug:
+//
+//	replay -name fix-123 -from 2016-05-01T10:00:00Z -to 2016-05-01T12:00:00Z -events test_event
+//
+// events are published to -target, which defaults to the stream they were
+// read from. a replay that's interrupted picks up where it left off when it's
+// run again with the same name.
+func main() {
+	var (
+		name         = flag.String("name", "", "replay name, used to namespace its checkpoints (required)")
+		from         = flag.String("from", "", "start at this time (RFC3339)")
+		fromSequence = flag.String("from-sequence", "", "start at this sequence number")
+		to           = flag.String("to", "", "end at this time (RFC3339), defaults to now")
+		toSequence   = flag.String("to-sequence", "", "end at this sequence number")
+		events       = flag.String("events", "", "comma separated event names to replay, defaults to all")
+		target       = flag.String("target", "", "stream to publish to, defaults to the source stream")
+	)
+	flag.Parse()
+
+	viper.SetEnvPrefix("gmunch")
+	viper.AutomaticEnv()
+
+	replay := &consumer.Replay{
+		Name:          *name,
+		StartSequence: *fromSequence,
+		EndSequence:   *toSequence,
+	}
+
+	var err error
+	if *from != "" {
+		if replay.StartTime, err = time.Parse(time.RFC3339, *from); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *to != "" {
+		if replay.EndTime, err = time.Parse(time.RFC3339, *to); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *events != "" {
+		replay.EventNames = strings.Split(*events, ",")
+	}
+
+	stream := viper.GetString("kinesis_stream")
+	if *target == "" {
+		*target = stream
+	}
+
+	c := consumer.New(consumer.Config{
+		Stream:        stream,
+		EtcdEndpoints: viper.GetStringSlice("etcd_address"),
+		ShardPath:     viper.GetString("shard_path"),
+		Region:        viper.GetString("aws_region"),
+		Replay:        replay,
+	})
+
+	p := producer.New(producer.Config{
+		Stream: *target,
+		Region: viper.GetString("aws_region"),
+	})
+
+	sigChan := make(chan os.Signal, 1)
+	errChan := make(chan error, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		errChan <- c.Start()
+	}()
+
+	go func() {
+		<-sigChan
+		log.Info("received interrupt")
+		c.Stop()
+	}()
+
+	replayed := 0
+LOOP:
+	for {
+		select {
+		case event, ok := <-c.Events():
+			if !ok {
+				err = <-errChan
+				break LOOP
+			}
+
+			logger := log.WithFields(event.LogFields())
+
+			// the origin is where it was read last time, not where it's going
+			event.Origin = nil
+
+			if err = p.Publish(event); err != nil {
+				logger.WithError(err).Error("couldn't publish event, rerun from its sequence")
+				break LOOP
+			}
+			replayed++
+
+		case err = <-errChan:
+			break LOOP
+		}
+	}
+
+	log.WithField("replayed", replayed).Info("replay finished")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
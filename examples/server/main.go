@@ -3,35 +3,138 @@ package main
 import (
 	"os"
 	"os/signal"
+	"path"
 	"syscall"
+	"time"
 
 	log "github.com/opsee/logrus"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/claimcheck"
 	consumer "github.com/opsee/gmunch/consumer/kinesis"
+	"github.com/opsee/gmunch/cron"
+	"github.com/opsee/gmunch/envelope"
 	"github.com/opsee/gmunch/examples/debug"
 	producer "github.com/opsee/gmunch/producer/kinesis"
 	"github.com/opsee/gmunch/server"
+	"github.com/opsee/gmunch/signing"
+	"github.com/opsee/gmunch/trace"
 	"github.com/opsee/gmunch/worker"
 	"github.com/spf13/viper"
+	"golang.org/x/net/context"
 )
 
 func main() {
 	viper.SetEnvPrefix("gmunch")
 	viper.AutomaticEnv()
 
+	if path := viper.GetString("trace_file"); path != "" {
+		exporter, err := trace.NewFileExporter(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trace.SetExporter(exporter)
+	}
+
+	// offloaded event data lives in a local directory, so this only works
+	// when every gmunch shares the host
+	var claimCheck claimcheck.Store
+	if dir := viper.GetString("claim_check_dir"); dir != "" {
+		store, err := claimcheck.NewFileStore(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		claimCheck = store
+
+		janitor := claimcheck.NewJanitor(store, 7*24*time.Hour, time.Hour, nil)
+		janitor.Start()
+		defer janitor.Stop()
+	}
+
+	// envelope encryption with static keys from the environment, e.g.
+	// GMUNCH_ENCRYPTION_KEYS=2016-06=<base64 32 bytes>, for the event names
+	// in GMUNCH_ENCRYPTED_EVENTS
+	var encrypter *envelope.Encrypter
+	if spec := viper.GetString("encryption_keys"); spec != "" {
+		keys, err := envelope.ParseStaticKeys(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		encrypter = envelope.New(envelope.Config{
+			Provider: keys,
+			Names:    viper.GetStringSlice("encrypted_events"),
+		})
+	}
+
+	var signingKey *signing.Key
+	if spec := viper.GetString("signing_key"); spec != "" {
+		var err error
+		signingKey, err = signing.ParseKey(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// keys to verify event signatures with, as id:algorithm:base64 pairs
+	var keyring *signing.Keyring
+	if spec := viper.GetString("signing_keyring"); spec != "" {
+		var err error
+		keyring, err = signing.ParseKeyring(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// publishes a test_event on GMUNCH_CRON_SCHEDULE, with the servers
+	// sharing etcd electing one of them to do it and remembering the last
+	// tick so that a new leader catches up on one it missed
+	var scheduler *cron.Scheduler
+	if schedule := viper.GetString("cron_schedule"); schedule != "" {
+		endpoints := viper.GetStringSlice("etcd_address")
+		prefix := viper.GetString("cron_prefix")
+
+		elector, err := cron.NewEtcdElector(endpoints, path.Join(prefix, "leader"), cron.DefaultLeaseTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		state, err := cron.NewEtcdState(endpoints, path.Join(prefix, "jobs"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		scheduler, err = cron.New([]cron.Job{
+			{Name: "test", Schedule: schedule, Event: "test_event", CatchUp: cron.Latest},
+		}, cron.Config{Elector: elector, State: state})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	server := server.New(server.Config{
-		LogLevel: viper.GetString("log_level"),
+		LogLevel:   viper.GetString("log_level"),
+		AdminAddr:  viper.GetString("admin_address"),
+		Encrypter:  encrypter,
+		SigningKey: signingKey,
+		Keyring:    keyring,
+		Cron:       scheduler,
 		Producer: producer.New(producer.Config{
-			Stream: viper.GetString("kinesis_stream"),
+			Stream:               viper.GetString("kinesis_stream"),
+			Compression:          viper.GetString("compression"),
+			CompressionThreshold: viper.GetInt("compression_threshold"),
+			ClaimCheck:           claimCheck,
 		}),
 		Consumer: consumer.New(consumer.Config{
 			Stream:        viper.GetString("kinesis_stream"),
 			EtcdEndpoints: viper.GetStringSlice("etcd_address"),
 			ShardPath:     viper.GetString("shard_path"),
+			ClaimCheck:    claimCheck,
+
+			InitialPosition: consumer.InitialPosition(viper.GetString("initial_position")),
 		}),
 		Dispatch: worker.Dispatch{
-			"test_event": func(evt *gmunch.Event) []worker.Task {
-				return []worker.Task{debug.New(evt)}
+			"test_event": func(ctx context.Context, evt *gmunch.Event) []worker.Task {
+				return []worker.Task{debug.New(ctx, evt)}
 			},
 		},
 	})
@@ -4,30 +4,116 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	log "github.com/opsee/logrus"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/claimcheck"
 	consumer "github.com/opsee/gmunch/consumer/kinesis"
+	"github.com/opsee/gmunch/dedupe"
+	"github.com/opsee/gmunch/delay"
+	"github.com/opsee/gmunch/envelope"
 	"github.com/opsee/gmunch/examples/debug"
+	"github.com/opsee/gmunch/signing"
+	"github.com/opsee/gmunch/trace"
 	"github.com/opsee/gmunch/worker"
 	"github.com/spf13/viper"
+	"golang.org/x/net/context"
 )
 
 func main() {
 	viper.SetEnvPrefix("gmunch")
 	viper.AutomaticEnv()
 
+	if path := viper.GetString("trace_file"); path != "" {
+		exporter, err := trace.NewFileExporter(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trace.SetExporter(exporter)
+	}
+
+	// offloaded event data lives in a local directory, so this only works
+	// when every gmunch shares the host
+	var claimCheck claimcheck.Store
+	if dir := viper.GetString("claim_check_dir"); dir != "" {
+		store, err := claimcheck.NewFileStore(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		claimCheck = store
+
+		janitor := claimcheck.NewJanitor(store, 7*24*time.Hour, time.Hour, nil)
+		janitor.Start()
+		defer janitor.Stop()
+	}
+
+	// envelope encryption with static keys from the environment, e.g.
+	// GMUNCH_ENCRYPTION_KEYS=2016-06=<base64 32 bytes>, for the event names
+	// in GMUNCH_ENCRYPTED_EVENTS
+	var encrypter *envelope.Encrypter
+	if spec := viper.GetString("encryption_keys"); spec != "" {
+		keys, err := envelope.ParseStaticKeys(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		encrypter = envelope.New(envelope.Config{
+			Provider: keys,
+			Names:    viper.GetStringSlice("encrypted_events"),
+		})
+	}
+
+	// keys to verify event signatures with, as id:algorithm:base64 pairs
+	var keyring *signing.Keyring
+	if spec := viper.GetString("signing_keyring"); spec != "" {
+		var err error
+		keyring, err = signing.ParseKeyring(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// completed events are remembered in etcd, next to the checkpoints, so
+	// that every worker skips them
+	var dedupeStore dedupe.Store
+	if prefix := viper.GetString("dedupe_prefix"); prefix != "" {
+		store, err := dedupe.NewEtcdStore(viper.GetStringSlice("etcd_address"), prefix, dedupe.DefaultTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dedupeStore = store
+	}
+
+	// delayed events wait on local disk, so they survive a restart
+	var delayStore delay.Store
+	if dir := viper.GetString("delay_dir"); dir != "" {
+		store, err := delay.NewFileStore(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		delayStore = store
+	}
+
 	worker := worker.New(worker.Config{
 		Consumer: consumer.New(consumer.Config{
 			Stream:        viper.GetString("kinesis_stream"),
 			EtcdEndpoints: viper.GetStringSlice("etcd_address"),
 			ShardPath:     viper.GetString("shard_path"),
+			ClaimCheck:    claimCheck,
+
+			InitialPosition: consumer.InitialPosition(viper.GetString("initial_position")),
 		}),
 		Dispatch: worker.Dispatch{
-			"test_event": func(evt *gmunch.Event) []worker.Task {
-				return []worker.Task{debug.New(evt)}
+			"test_event": func(ctx context.Context, evt *gmunch.Event) []worker.Task {
+				return []worker.Task{debug.New(ctx, evt)}
 			},
 		},
+		AdminAddr: viper.GetString("admin_address"),
+		Encrypter: encrypter,
+		Keyring:   keyring,
+		Dedupe:    dedupeStore,
+		Delay:     delayStore,
 	})
 
 	sigChan := make(chan os.Signal, 1)
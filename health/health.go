@@ -0,0 +1,123 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Checker is an optional interface that producers, consumers and anything
+// else with moving parts can implement to report whether or not they are
+// healthy. A nil error means healthy.
+type Checker interface {
+	Healthy() error
+}
+
+// CheckerFunc adapts a plain function to a Checker.
+type CheckerFunc func() error
+
+func (f CheckerFunc) Healthy() error {
+	return f()
+}
+
+// Health collects named liveness and readiness checks. Liveness checks answer
+// "should this process be restarted", readiness checks answer "should this
+// process be handed work".
+type Health struct {
+	liveness  map[string]Checker
+	readiness map[string]Checker
+	mut       sync.Mutex
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func New() *Health {
+	return &Health{
+		liveness:  make(map[string]Checker),
+		readiness: make(map[string]Checker),
+	}
+}
+
+// AddLiveness registers a check reported by /healthz. Liveness checks are
+// also readiness checks, since a dead process can't be ready.
+func (h *Health) AddLiveness(name string, checker Checker) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.liveness[name] = checker
+}
+
+// AddReadiness registers a check reported by /readyz.
+func (h *Health) AddReadiness(name string, checker Checker) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.readiness[name] = checker
+}
+
+// Live runs the liveness checks, returning the errors of any that failed.
+func (h *Health) Live() map[string]error {
+	return h.run(false)
+}
+
+// Ready runs the liveness and readiness checks, returning the errors of
+// any that failed.
+func (h *Health) Ready() map[string]error {
+	return h.run(true)
+}
+
+// Register mounts /healthz and /readyz on the given mux.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, h.Live())
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, h.Ready())
+	})
+}
+
+func (h *Health) run(readiness bool) map[string]error {
+	h.mut.Lock()
+	checkers := make(map[string]Checker, len(h.liveness)+len(h.readiness))
+	for name, checker := range h.liveness {
+		checkers[name] = checker
+	}
+	if readiness {
+		for name, checker := range h.readiness {
+			checkers[name] = checker
+		}
+	}
+	h.mut.Unlock()
+
+	results := make(map[string]error, len(checkers))
+	for name, checker := range checkers {
+		results[name] = checker.Healthy()
+	}
+
+	return results
+}
+
+func (h *Health) serve(w http.ResponseWriter, results map[string]error) {
+	rep := report{
+		Status: "ok",
+		Checks: make(map[string]string, len(results)),
+	}
+
+	status := http.StatusOK
+	for name, err := range results {
+		if err != nil {
+			rep.Checks[name] = err.Error()
+			rep.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+
+		rep.Checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rep)
+}
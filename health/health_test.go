@@ -0,0 +1,53 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessIncludesLiveness(t *testing.T) {
+	assert := assert.New(t)
+
+	h := New()
+	h.AddLiveness("consumer", CheckerFunc(func() error { return nil }))
+	h.AddReadiness("producer", CheckerFunc(func() error { return errors.New("stream is DELETING") }))
+
+	live := h.Live()
+	assert.Len(live, 1)
+	assert.NoError(live["consumer"])
+
+	ready := h.Ready()
+	assert.Len(ready, 2)
+	assert.NoError(ready["consumer"])
+	assert.EqualError(ready["producer"], "stream is DELETING")
+}
+
+func TestHandlers(t *testing.T) {
+	assert := assert.New(t)
+
+	h := New()
+	h.AddLiveness("consumer", CheckerFunc(func() error { return nil }))
+	h.AddReadiness("scheduler", CheckerFunc(func() error { return errors.New("queue is full") }))
+
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(http.StatusServiceUnavailable, rec.Code)
+
+	rep := report{}
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&rep))
+	assert.Equal("unavailable", rep.Status)
+	assert.Equal("ok", rep.Checks["consumer"])
+	assert.Equal("queue is full", rep.Checks["scheduler"])
+}
@@ -0,0 +1,378 @@
+package kinesistest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/big"
+	"time"
+)
+
+// request and response bodies, in the JSON shapes the SDK uses. Blobs are
+// base64 and timestamps are epoch seconds, which encoding/json handles for
+// us.
+
+type describeStreamInput struct {
+	StreamName            string
+	Limit                 int
+	ExclusiveStartShardId string
+}
+
+type hashKeyRange struct {
+	StartingHashKey string
+	EndingHashKey   string
+}
+
+type sequenceNumberRange struct {
+	StartingSequenceNumber string
+	EndingSequenceNumber   string `json:",omitempty"`
+}
+
+type shardDescription struct {
+	ShardId               string
+	ParentShardId         string `json:",omitempty"`
+	AdjacentParentShardId string `json:",omitempty"`
+	HashKeyRange          hashKeyRange
+	SequenceNumberRange   sequenceNumberRange
+}
+
+type streamDescription struct {
+	StreamName           string
+	StreamARN            string
+	StreamStatus         string
+	Shards               []shardDescription
+	HasMoreShards        bool
+	RetentionPeriodHours int
+}
+
+type describeStreamOutput struct {
+	StreamDescription streamDescription
+}
+
+type getShardIteratorInput struct {
+	StreamName             string
+	ShardId                string
+	ShardIteratorType      string
+	StartingSequenceNumber string
+	Timestamp              float64
+}
+
+type getShardIteratorOutput struct {
+	ShardIterator string
+}
+
+type getRecordsInput struct {
+	ShardIterator string
+	Limit         int
+}
+
+type record struct {
+	Data                        []byte
+	PartitionKey                string
+	SequenceNumber              string
+	ApproximateArrivalTimestamp float64
+}
+
+type getRecordsOutput struct {
+	Records            []record
+	NextShardIterator  *string
+	MillisBehindLatest int64
+}
+
+type putRecordInput struct {
+	StreamName      string
+	Data            []byte
+	PartitionKey    string
+	ExplicitHashKey string
+}
+
+type putRecordOutput struct {
+	ShardId        string
+	SequenceNumber string
+}
+
+type putRecordsEntry struct {
+	Data            []byte
+	PartitionKey    string
+	ExplicitHashKey string
+}
+
+type putRecordsInput struct {
+	StreamName string
+	Records    []putRecordsEntry
+}
+
+type putRecordsResult struct {
+	ShardId        string `json:",omitempty"`
+	SequenceNumber string `json:",omitempty"`
+	ErrorCode      string `json:",omitempty"`
+	ErrorMessage   string `json:",omitempty"`
+}
+
+type putRecordsOutput struct {
+	FailedRecordCount int
+	Records           []putRecordsResult
+}
+
+// iterator is what's encoded in a shard iterator. Real iterators are just
+// as opaque.
+type iterator struct {
+	Stream   string
+	Shard    string
+	Position int
+	Issued   time.Time
+}
+
+func (it *iterator) encode() *string {
+	data, _ := json.Marshal(it)
+	s := base64.StdEncoding.EncodeToString(data)
+	return &s
+}
+
+func decodeIterator(s string) (*iterator, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidArgument, "invalid shard iterator")
+	}
+
+	it := &iterator{}
+	if err := json.Unmarshal(data, it); err != nil {
+		return nil, newError(ErrCodeInvalidArgument, "invalid shard iterator")
+	}
+
+	return it, nil
+}
+
+func (s *Server) stream(name string) (*stream, error) {
+	st, ok := s.streams[name]
+	if !ok {
+		return nil, newError(ErrCodeResourceNotFound, "stream %s not found", name)
+	}
+
+	return st, nil
+}
+
+func (s *Server) describeStream(in *describeStreamInput) (*describeStreamOutput, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, err := s.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &describeStreamOutput{
+		StreamDescription: streamDescription{
+			StreamName:           st.name,
+			StreamARN:            "arn:aws:kinesis:us-west-2:000000000000:stream/" + st.name,
+			StreamStatus:         "ACTIVE",
+			Shards:               []shardDescription{},
+			RetentionPeriodHours: 24,
+		},
+	}
+
+	started := in.ExclusiveStartShardId == ""
+	for _, sh := range st.shards {
+		if !started {
+			started = sh.id == in.ExclusiveStartShardId
+			continue
+		}
+
+		if in.Limit > 0 && len(out.StreamDescription.Shards) == in.Limit {
+			out.StreamDescription.HasMoreShards = true
+			break
+		}
+
+		out.StreamDescription.Shards = append(out.StreamDescription.Shards, shardDescription{
+			ShardId:               sh.id,
+			ParentShardId:         sh.parent,
+			AdjacentParentShardId: sh.adjacentParent,
+			HashKeyRange: hashKeyRange{
+				StartingHashKey: sh.startHash.String(),
+				EndingHashKey:   sh.endHash.String(),
+			},
+			SequenceNumberRange: sequenceNumberRange{
+				StartingSequenceNumber: sh.startSequence,
+				EndingSequenceNumber:   sh.endSequence,
+			},
+		})
+	}
+
+	return out, nil
+}
+
+func (s *Server) getShardIterator(in *getShardIteratorInput) (*getShardIteratorOutput, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, err := s.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	sh := st.shard(in.ShardId)
+	if sh == nil {
+		return nil, newError(ErrCodeResourceNotFound, "shard %s not found", in.ShardId)
+	}
+
+	pos := sh.trimmed
+	switch in.ShardIteratorType {
+	case "TRIM_HORIZON":
+	case "LATEST":
+		pos = len(sh.records)
+	case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+		seq, ok := new(big.Int).SetString(in.StartingSequenceNumber, 10)
+		if !ok {
+			return nil, newError(ErrCodeInvalidArgument, "invalid sequence number %q", in.StartingSequenceNumber)
+		}
+
+		// like kinesis, reject sequence numbers that have been trimmed
+		if sh.trimmed > 0 && seq.Cmp(sequenceValue(sh.records[sh.trimmed-1].Sequence)) <= 0 {
+			return nil, newError(ErrCodeInvalidArgument, "sequence number %s has been trimmed", in.StartingSequenceNumber)
+		}
+
+		for pos < len(sh.records) {
+			cmp := seq.Cmp(sequenceValue(sh.records[pos].Sequence))
+			if cmp < 0 || (cmp == 0 && in.ShardIteratorType == "AT_SEQUENCE_NUMBER") {
+				break
+			}
+			pos++
+		}
+	case "AT_TIMESTAMP":
+		sec, frac := math.Modf(in.Timestamp)
+		ts := time.Unix(int64(sec), int64(frac*float64(time.Second)))
+		for pos < len(sh.records) && sh.records[pos].Arrival.Before(ts) {
+			pos++
+		}
+	default:
+		return nil, newError(ErrCodeInvalidArgument, "invalid shard iterator type %q", in.ShardIteratorType)
+	}
+
+	it := &iterator{
+		Stream:   st.name,
+		Shard:    sh.id,
+		Position: pos,
+		Issued:   time.Now(),
+	}
+
+	return &getShardIteratorOutput{ShardIterator: *it.encode()}, nil
+}
+
+func (s *Server) getRecords(in *getRecordsInput) (*getRecordsOutput, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	it, err := decodeIterator(in.ShardIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	if !it.Issued.After(s.iteratorEpoch) || time.Since(it.Issued) > iteratorTTL {
+		return nil, newError(ErrCodeExpiredIterator, "iterator expired")
+	}
+
+	st, err := s.stream(it.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	sh := st.shard(it.Shard)
+	if sh == nil {
+		return nil, newError(ErrCodeResourceNotFound, "shard %s not found", it.Shard)
+	}
+
+	limit := in.Limit
+	if limit <= 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+
+	pos := it.Position
+	if pos < sh.trimmed {
+		pos = sh.trimmed
+	}
+
+	end := pos + limit
+	if end > len(sh.records) {
+		end = len(sh.records)
+	}
+
+	out := &getRecordsOutput{Records: []record{}}
+	for _, rec := range sh.records[pos:end] {
+		out.Records = append(out.Records, record{
+			Data:                        rec.Data,
+			PartitionKey:                rec.PartitionKey,
+			SequenceNumber:              rec.Sequence,
+			ApproximateArrivalTimestamp: float64(rec.Arrival.UnixNano()) / float64(time.Second),
+		})
+	}
+
+	if end < len(sh.records) {
+		behind := sh.records[len(sh.records)-1].Arrival.Sub(sh.records[end].Arrival)
+		out.MillisBehindLatest = int64(behind / time.Millisecond)
+		if out.MillisBehindLatest < 1 {
+			out.MillisBehindLatest = 1
+		}
+	}
+
+	// a closed shard that's been read to the end has no next iterator
+	if !sh.closed() || end < len(sh.records) {
+		next := &iterator{
+			Stream:   st.name,
+			Shard:    sh.id,
+			Position: end,
+			Issued:   time.Now(),
+		}
+		out.NextShardIterator = next.encode()
+	}
+
+	return out, nil
+}
+
+func (s *Server) putRecord(in *putRecordInput) (*putRecordOutput, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, err := s.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	shardID, sequence, err := s.put(st, in.PartitionKey, in.ExplicitHashKey, in.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &putRecordOutput{ShardId: shardID, SequenceNumber: sequence}, nil
+}
+
+func (s *Server) putRecords(in *putRecordsInput) (*putRecordsOutput, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, err := s.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &putRecordsOutput{}
+	for _, entry := range in.Records {
+		shardID, sequence, err := s.put(st, entry.PartitionKey, entry.ExplicitHashKey, entry.Data)
+		if err != nil {
+			out.FailedRecordCount++
+			out.Records = append(out.Records, putRecordsResult{
+				ErrorCode:    ErrCodeInvalidArgument,
+				ErrorMessage: err.Error(),
+			})
+			continue
+		}
+
+		out.Records = append(out.Records, putRecordsResult{ShardId: shardID, SequenceNumber: sequence})
+	}
+
+	return out, nil
+}
+
+func sequenceValue(sequence string) *big.Int {
+	v, _ := new(big.Int).SetString(sequence, 10)
+	return v
+}
@@ -0,0 +1,462 @@
+// Package kinesistest provides an in-process fake of the parts of the
+// Kinesis API that gmunch uses, for tests that would otherwise need AWS.
+//
+// The fake speaks the real JSON protocol, so it's used through the regular
+// SDK client:
+//
+//	srv := kinesistest.NewServer()
+//	defer srv.Close()
+//	srv.CreateStream("events", 2)
+//
+//	producer := kinesis.New(kinesis.Config{Stream: "events", AWSConfig: srv.Config()})
+//
+// It supports DescribeStream, GetShardIterator, GetRecords, PutRecord and
+// PutRecords, along with resharding, trimming, iterator expiry and error
+// injection through methods on the Server.
+package kinesistest
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+const (
+	targetPrefix = "Kinesis_20131202."
+	contentType  = "application/x-amz-json-1.1"
+
+	// the most GetRecords will return, as with the real thing
+	maxLimit = 10000
+
+	// how long iterators last before they expire
+	iteratorTTL = 5 * time.Minute
+)
+
+// Error codes returned by the fake.
+const (
+	ErrCodeResourceNotFound   = "ResourceNotFoundException"
+	ErrCodeInvalidArgument    = "InvalidArgumentException"
+	ErrCodeThroughputExceeded = "ProvisionedThroughputExceededException"
+	ErrCodeExpiredIterator    = "ExpiredIteratorException"
+)
+
+// the whole 128 bit hash key space
+var maxHashKey = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// Record is a record stored in the fake.
+type Record struct {
+	Data         []byte
+	PartitionKey string
+	Sequence     string
+	Arrival      time.Time
+}
+
+type shard struct {
+	id             string
+	parent         string
+	adjacentParent string
+	startHash      *big.Int
+	endHash        *big.Int
+	startSequence  string
+	endSequence    string
+	records        []*Record
+
+	// records before this index have been trimmed
+	trimmed int
+}
+
+func (s *shard) closed() bool {
+	return s.endSequence != ""
+}
+
+type stream struct {
+	name      string
+	shards    []*shard
+	nextShard int
+}
+
+// Server is a fake Kinesis endpoint. It's safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	streams  map[string]*stream
+	sequence int64
+
+	// iterators issued before this are expired
+	iteratorEpoch time.Time
+
+	failures map[string][]string
+	calls    map[string]int
+	mut      sync.Mutex
+}
+
+// NewServer starts a fake with no streams. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		streams:  make(map[string]*stream),
+		failures: make(map[string][]string),
+		calls:    make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Config returns an AWS config for clients of the fake. It disables the
+// SDK's retries so that injected errors reach the caller.
+func (s *Server) Config() *aws.Config {
+	return aws.NewConfig().
+		WithEndpoint(s.URL).
+		WithRegion("us-west-2").
+		WithDisableSSL(true).
+		WithMaxRetries(0).
+		WithCredentials(credentials.NewStaticCredentials("fake", "fake", ""))
+}
+
+// CreateStream creates an active stream, splitting the hash key space
+// evenly between n shards.
+func (s *Server) CreateStream(name string, n int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st := &stream{name: name}
+	width := new(big.Int).Div(maxHashKey, big.NewInt(int64(n)))
+	for i := 0; i < n; i++ {
+		start := new(big.Int).Mul(width, big.NewInt(int64(i)))
+		end := new(big.Int).Sub(new(big.Int).Add(start, width), big.NewInt(1))
+		if i == n-1 {
+			end = new(big.Int).Set(maxHashKey)
+		}
+
+		st.addShard(&shard{startHash: start, endHash: end, startSequence: s.nextSequence()})
+	}
+
+	s.streams[name] = st
+}
+
+func (st *stream) addShard(sh *shard) {
+	sh.id = fmt.Sprintf("shardId-%012d", st.nextShard)
+	st.nextShard++
+	st.shards = append(st.shards, sh)
+}
+
+func (st *stream) shard(id string) *shard {
+	for _, sh := range st.shards {
+		if sh.id == id {
+			return sh
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) nextSequence() string {
+	s.sequence++
+	return fmt.Sprintf("4956%052d", s.sequence)
+}
+
+// Put adds a record as PutRecord would, returning its shard and sequence
+// number.
+func (s *Server) Put(streamName, partitionKey string, data []byte) (string, string, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, ok := s.streams[streamName]
+	if !ok {
+		return "", "", newError(ErrCodeResourceNotFound, "no such stream: %s", streamName)
+	}
+
+	return s.put(st, partitionKey, "", data)
+}
+
+// put routes a record to the open shard covering its hash key, which is the
+// MD5 of the partition key unless an explicit one is given.
+func (s *Server) put(st *stream, partitionKey, explicitHashKey string, data []byte) (string, string, error) {
+	sum := md5.Sum([]byte(partitionKey))
+	hash := new(big.Int).SetBytes(sum[:])
+	if explicitHashKey != "" {
+		var ok bool
+		if hash, ok = new(big.Int).SetString(explicitHashKey, 10); !ok || hash.Cmp(maxHashKey) > 0 {
+			return "", "", newError(ErrCodeInvalidArgument, "invalid explicit hash key %s", explicitHashKey)
+		}
+	}
+
+	for _, sh := range st.shards {
+		if sh.closed() || hash.Cmp(sh.startHash) < 0 || hash.Cmp(sh.endHash) > 0 {
+			continue
+		}
+
+		rec := &Record{
+			Data:         data,
+			PartitionKey: partitionKey,
+			Sequence:     s.nextSequence(),
+			Arrival:      time.Now(),
+		}
+		sh.records = append(sh.records, rec)
+		return sh.id, rec.Sequence, nil
+	}
+
+	return "", "", newError(ErrCodeInvalidArgument, "no open shard for partition key %s", partitionKey)
+}
+
+// Records returns the untrimmed records in a shard.
+func (s *Server) Records(streamName, shardID string) []*Record {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	sh := s.findShard(streamName, shardID)
+	if sh == nil {
+		return nil
+	}
+
+	return append([]*Record(nil), sh.records[sh.trimmed:]...)
+}
+
+// SplitShard closes a shard and replaces it with two children, each taking
+// half of its hash key range.
+func (s *Server) SplitShard(streamName, shardID string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, parent, err := s.openShard(streamName, shardID)
+	if err != nil {
+		return err
+	}
+
+	parent.endSequence = s.nextSequence()
+	mid := new(big.Int).Add(parent.startHash, new(big.Int).Rsh(new(big.Int).Sub(parent.endHash, parent.startHash), 1))
+	st.addShard(&shard{
+		parent:        parent.id,
+		startHash:     parent.startHash,
+		endHash:       mid,
+		startSequence: s.nextSequence(),
+	})
+	st.addShard(&shard{
+		parent:        parent.id,
+		startHash:     new(big.Int).Add(mid, big.NewInt(1)),
+		endHash:       parent.endHash,
+		startSequence: s.nextSequence(),
+	})
+
+	return nil
+}
+
+// MergeShards closes two shards with adjacent hash key ranges and replaces
+// them with one child covering both.
+func (s *Server) MergeShards(streamName, shardID, adjacentShardID string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, first, err := s.openShard(streamName, shardID)
+	if err != nil {
+		return err
+	}
+
+	_, second, err := s.openShard(streamName, adjacentShardID)
+	if err != nil {
+		return err
+	}
+
+	if first.startHash.Cmp(second.startHash) > 0 {
+		first, second = second, first
+	}
+
+	if new(big.Int).Add(first.endHash, big.NewInt(1)).Cmp(second.startHash) != 0 {
+		return fmt.Errorf("shards %s and %s aren't adjacent", shardID, adjacentShardID)
+	}
+
+	first.endSequence = s.nextSequence()
+	second.endSequence = s.nextSequence()
+	st.addShard(&shard{
+		parent:         shardID,
+		adjacentParent: adjacentShardID,
+		startHash:      first.startHash,
+		endHash:        second.endHash,
+		startSequence:  s.nextSequence(),
+	})
+
+	return nil
+}
+
+// CloseShard closes a shard without replacing it, so it reads to its end
+// and then has no next iterator.
+func (s *Server) CloseShard(streamName, shardID string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	_, sh, err := s.openShard(streamName, shardID)
+	if err != nil {
+		return err
+	}
+
+	sh.endSequence = s.nextSequence()
+	return nil
+}
+
+// TrimBefore drops records that arrived before t, as if they'd aged out of
+// the stream's retention period.
+func (s *Server) TrimBefore(streamName string, t time.Time) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	st, ok := s.streams[streamName]
+	if !ok {
+		return
+	}
+
+	for _, sh := range st.shards {
+		for sh.trimmed < len(sh.records) && sh.records[sh.trimmed].Arrival.Before(t) {
+			sh.trimmed++
+		}
+	}
+}
+
+// ExpireIterators expires every iterator issued so far.
+func (s *Server) ExpireIterators() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.iteratorEpoch = time.Now()
+}
+
+// FailNext makes the next n calls to operation, e.g. "GetRecords", fail
+// with the given error code.
+func (s *Server) FailNext(operation, code string, n int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures[operation] = append(s.failures[operation], code)
+	}
+}
+
+// Calls returns how many times operation has been called.
+func (s *Server) Calls(operation string) int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.calls[operation]
+}
+
+func (s *Server) findShard(streamName, shardID string) *shard {
+	st, ok := s.streams[streamName]
+	if !ok {
+		return nil
+	}
+
+	return st.shard(shardID)
+}
+
+func (s *Server) openShard(streamName, shardID string) (*stream, *shard, error) {
+	st, ok := s.streams[streamName]
+	if !ok {
+		return nil, nil, fmt.Errorf("no such stream: %s", streamName)
+	}
+
+	sh := st.shard(shardID)
+	if sh == nil {
+		return nil, nil, fmt.Errorf("no such shard: %s", shardID)
+	}
+
+	if sh.closed() {
+		return nil, nil, fmt.Errorf("shard %s is closed", shardID)
+	}
+
+	return st, sh, nil
+}
+
+type apiError struct {
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.code, e.message)
+}
+
+func newError(code, format string, args ...interface{}) error {
+	return &apiError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	if !strings.HasPrefix(target, targetPrefix) {
+		writeError(w, newError("UnknownOperationException", "unknown target %q", target))
+		return
+	}
+	operation := strings.TrimPrefix(target, targetPrefix)
+
+	s.mut.Lock()
+	s.calls[operation]++
+	if failures := s.failures[operation]; len(failures) > 0 {
+		s.failures[operation] = failures[1:]
+		s.mut.Unlock()
+		writeError(w, newError(failures[0], "injected failure"))
+		return
+	}
+	s.mut.Unlock()
+
+	var (
+		out interface{}
+		err error
+	)
+
+	switch operation {
+	case "DescribeStream":
+		in := &describeStreamInput{}
+		if err = json.NewDecoder(r.Body).Decode(in); err == nil {
+			out, err = s.describeStream(in)
+		}
+	case "GetShardIterator":
+		in := &getShardIteratorInput{}
+		if err = json.NewDecoder(r.Body).Decode(in); err == nil {
+			out, err = s.getShardIterator(in)
+		}
+	case "GetRecords":
+		in := &getRecordsInput{}
+		if err = json.NewDecoder(r.Body).Decode(in); err == nil {
+			out, err = s.getRecords(in)
+		}
+	case "PutRecord":
+		in := &putRecordInput{}
+		if err = json.NewDecoder(r.Body).Decode(in); err == nil {
+			out, err = s.putRecord(in)
+		}
+	case "PutRecords":
+		in := &putRecordsInput{}
+		if err = json.NewDecoder(r.Body).Decode(in); err == nil {
+			out, err = s.putRecords(in)
+		}
+	default:
+		err = newError("UnknownOperationException", "unsupported operation %s", operation)
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	json.NewEncoder(w).Encode(out)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*apiError)
+	if !ok {
+		apiErr = &apiError{code: "SerializationException", message: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"__type":  apiErr.code,
+		"message": apiErr.message,
+	})
+}
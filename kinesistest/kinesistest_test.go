@@ -0,0 +1,111 @@
+package kinesistest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResharding(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 2)
+	client := kinesis.New(session.New(srv.Config()))
+
+	assert.NoError(srv.SplitShard("test", "shardId-000000000000"))
+	assert.Error(srv.SplitShard("test", "shardId-000000000000"))
+	assert.NoError(srv.MergeShards("test", "shardId-000000000003", "shardId-000000000001"))
+
+	out, err := client.DescribeStream(&kinesis.DescribeStreamInput{StreamName: aws.String("test")})
+	assert.NoError(err)
+
+	shards := out.StreamDescription.Shards
+	assert.Len(shards, 5)
+	assert.Equal("shardId-000000000000", aws.StringValue(shards[2].ParentShardId))
+	assert.Equal("shardId-000000000003", aws.StringValue(shards[4].ParentShardId))
+	assert.Equal("shardId-000000000001", aws.StringValue(shards[4].AdjacentParentShardId))
+	assert.NotNil(shards[0].SequenceNumberRange.EndingSequenceNumber)
+	assert.Nil(shards[2].SequenceNumberRange.EndingSequenceNumber)
+
+	// records only go to open shards
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		_, err := client.PutRecord(&kinesis.PutRecordInput{
+			StreamName:   aws.String("test"),
+			PartitionKey: aws.String(key),
+			Data:         []byte(key),
+		})
+		assert.NoError(err)
+	}
+	assert.Empty(srv.Records("test", "shardId-000000000000"))
+	assert.Empty(srv.Records("test", "shardId-000000000001"))
+	assert.Empty(srv.Records("test", "shardId-000000000003"))
+	assert.Len(append(srv.Records("test", "shardId-000000000002"), srv.Records("test", "shardId-000000000004")...), 6)
+
+	out, err = client.DescribeStream(&kinesis.DescribeStreamInput{StreamName: aws.String("test"), Limit: aws.Int64(2)})
+	assert.NoError(err)
+	assert.Len(out.StreamDescription.Shards, 2)
+	assert.True(aws.BoolValue(out.StreamDescription.HasMoreShards))
+}
+
+func TestIterators(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 1)
+	client := kinesis.New(session.New(srv.Config()))
+
+	for _, key := range []string{"a", "b", "c"} {
+		srv.Put("test", key, []byte(key))
+	}
+	records := srv.Records("test", "shardId-000000000000")
+
+	read := func(input *kinesis.GetShardIteratorInput) []string {
+		input.StreamName = aws.String("test")
+		input.ShardId = aws.String("shardId-000000000000")
+
+		iter, err := client.GetShardIterator(input)
+		assert.NoError(err)
+
+		out, err := client.GetRecords(&kinesis.GetRecordsInput{ShardIterator: iter.ShardIterator})
+		assert.NoError(err)
+
+		data := []string{}
+		for _, rec := range out.Records {
+			data = append(data, string(rec.Data))
+		}
+		return data
+	}
+
+	assert.Equal([]string{"a", "b", "c"}, read(&kinesis.GetShardIteratorInput{ShardIteratorType: aws.String("TRIM_HORIZON")}))
+	assert.Equal([]string{}, read(&kinesis.GetShardIteratorInput{ShardIteratorType: aws.String("LATEST")}))
+	assert.Equal([]string{"b", "c"}, read(&kinesis.GetShardIteratorInput{
+		ShardIteratorType:      aws.String("AT_SEQUENCE_NUMBER"),
+		StartingSequenceNumber: aws.String(records[1].Sequence),
+	}))
+	assert.Equal([]string{"c"}, read(&kinesis.GetShardIteratorInput{
+		ShardIteratorType:      aws.String("AFTER_SEQUENCE_NUMBER"),
+		StartingSequenceNumber: aws.String(records[1].Sequence),
+	}))
+	assert.Equal([]string{}, read(&kinesis.GetShardIteratorInput{
+		ShardIteratorType: aws.String("AT_TIMESTAMP"),
+		Timestamp:         aws.Time(time.Now().Add(time.Hour)),
+	}))
+
+	iter, err := client.GetShardIterator(&kinesis.GetShardIteratorInput{
+		StreamName:        aws.String("test"),
+		ShardId:           aws.String("shardId-000000000000"),
+		ShardIteratorType: aws.String("TRIM_HORIZON"),
+	})
+	assert.NoError(err)
+
+	srv.ExpireIterators()
+	_, err = client.GetRecords(&kinesis.GetRecordsInput{ShardIterator: iter.ShardIterator})
+	assert.Error(err)
+}
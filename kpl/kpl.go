@@ -0,0 +1,193 @@
+package kpl
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Magic is the prefix that marks an aggregated record.
+var Magic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+// ErrNotAggregated is returned by Deaggregate for data that isn't an
+// aggregated record.
+var ErrNotAggregated = errors.New("kpl: not an aggregated record")
+
+// the magic prefix and the md5 suffix
+const overhead = 4 + md5.Size
+
+// UserRecord is one of the records packed into an aggregated record.
+type UserRecord struct {
+	PartitionKey    string
+	ExplicitHashKey string
+	Data            []byte
+}
+
+// IsAggregated reports whether data is an aggregated record. Like the KCL,
+// data with the magic prefix but a bad checksum isn't one.
+func IsAggregated(data []byte) bool {
+	if len(data) < overhead || !bytes.HasPrefix(data, Magic) {
+		return false
+	}
+
+	body := data[len(Magic) : len(data)-md5.Size]
+	sum := md5.Sum(body)
+	return bytes.Equal(sum[:], data[len(data)-md5.Size:])
+}
+
+// Deaggregate unpacks an aggregated record.
+func Deaggregate(data []byte) ([]*UserRecord, error) {
+	if !IsAggregated(data) {
+		return nil, ErrNotAggregated
+	}
+
+	agg := &AggregatedRecord{}
+	if err := proto.Unmarshal(data[len(Magic):len(data)-md5.Size], agg); err != nil {
+		return nil, err
+	}
+
+	records := make([]*UserRecord, len(agg.Records))
+	for i, rec := range agg.Records {
+		keyIndex := rec.GetPartitionKeyIndex()
+		if keyIndex >= uint64(len(agg.PartitionKeyTable)) {
+			return nil, fmt.Errorf("kpl: record %d has partition key index %d out of range", i, keyIndex)
+		}
+
+		records[i] = &UserRecord{
+			PartitionKey: agg.PartitionKeyTable[keyIndex],
+			Data:         rec.Data,
+		}
+
+		if rec.ExplicitHashKeyIndex != nil {
+			hashIndex := rec.GetExplicitHashKeyIndex()
+			if hashIndex >= uint64(len(agg.ExplicitHashKeyTable)) {
+				return nil, fmt.Errorf("kpl: record %d has explicit hash key index %d out of range", i, hashIndex)
+			}
+			records[i].ExplicitHashKey = agg.ExplicitHashKeyTable[hashIndex]
+		}
+	}
+
+	return records, nil
+}
+
+// Aggregator packs user records into an aggregated record, keeping track of
+// how big it's getting so callers can stay under the Kinesis record limit.
+// The zero value is ready to use. It isn't safe for concurrent use.
+type Aggregator struct {
+	record   AggregatedRecord
+	keys     map[string]uint64
+	hashKeys map[string]uint64
+	size     int
+}
+
+// Len returns the number of user records added.
+func (a *Aggregator) Len() int {
+	return len(a.record.Records)
+}
+
+// Size returns the encoded size of the aggregated record.
+func (a *Aggregator) Size() int {
+	return overhead + a.size
+}
+
+// SizeWith returns what the encoded size would be with r added.
+func (a *Aggregator) SizeWith(r *UserRecord) int {
+	size, _ := a.sizeOf(r)
+	return a.Size() + size
+}
+
+func (a *Aggregator) sizeOf(r *UserRecord) (int, *Record) {
+	size := 0
+	rec := &Record{Data: r.Data}
+
+	if i, ok := a.keys[r.PartitionKey]; ok {
+		rec.PartitionKeyIndex = proto.Uint64(i)
+	} else {
+		rec.PartitionKeyIndex = proto.Uint64(uint64(len(a.record.PartitionKeyTable)))
+		size += fieldSize(len(r.PartitionKey))
+	}
+
+	if r.ExplicitHashKey != "" {
+		if i, ok := a.hashKeys[r.ExplicitHashKey]; ok {
+			rec.ExplicitHashKeyIndex = proto.Uint64(i)
+		} else {
+			rec.ExplicitHashKeyIndex = proto.Uint64(uint64(len(a.record.ExplicitHashKeyTable)))
+			size += fieldSize(len(r.ExplicitHashKey))
+		}
+	}
+
+	return size + fieldSize(proto.Size(rec)), rec
+}
+
+// Add adds a user record.
+func (a *Aggregator) Add(r *UserRecord) {
+	if a.keys == nil {
+		a.keys = make(map[string]uint64)
+		a.hashKeys = make(map[string]uint64)
+	}
+
+	size, rec := a.sizeOf(r)
+	a.size += size
+
+	if _, ok := a.keys[r.PartitionKey]; !ok {
+		a.keys[r.PartitionKey] = rec.GetPartitionKeyIndex()
+		a.record.PartitionKeyTable = append(a.record.PartitionKeyTable, r.PartitionKey)
+	}
+
+	if r.ExplicitHashKey != "" {
+		if _, ok := a.hashKeys[r.ExplicitHashKey]; !ok {
+			a.hashKeys[r.ExplicitHashKey] = rec.GetExplicitHashKeyIndex()
+			a.record.ExplicitHashKeyTable = append(a.record.ExplicitHashKeyTable, r.ExplicitHashKey)
+		}
+	}
+
+	a.record.Records = append(a.record.Records, rec)
+}
+
+// PartitionKey returns the partition key to put the aggregated record
+// with, which is that of the first user record.
+func (a *Aggregator) PartitionKey() string {
+	if len(a.record.PartitionKeyTable) == 0 {
+		return ""
+	}
+
+	return a.record.PartitionKeyTable[0]
+}
+
+// Bytes encodes the aggregated record.
+func (a *Aggregator) Bytes() ([]byte, error) {
+	body, err := proto.Marshal(&a.record)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := md5.Sum(body)
+	data := make([]byte, 0, len(Magic)+len(body)+len(sum))
+	data = append(data, Magic...)
+	data = append(data, body...)
+	return append(data, sum[:]...), nil
+}
+
+// Reset empties the aggregator for reuse.
+func (a *Aggregator) Reset() {
+	*a = Aggregator{}
+}
+
+// Aggregate packs records into one aggregated record.
+func Aggregate(records []*UserRecord) ([]byte, error) {
+	a := &Aggregator{}
+	for _, r := range records {
+		a.Add(r)
+	}
+
+	return a.Bytes()
+}
+
+// fieldSize is the encoded size of a length delimited field with a one
+// byte tag, which all of ours have.
+func fieldSize(n int) int {
+	return 1 + proto.SizeVarint(uint64(n)) + n
+}
@@ -0,0 +1,144 @@
+// Code generated by protoc-gen-go.
+// source: kpl.proto
+// DO NOT EDIT!
+
+/*
+Package kpl is a generated protocol buffer package.
+
+It is generated from these files:
+	kpl.proto
+
+It has these top-level messages:
+	AggregatedRecord
+	Tag
+	Record
+*/
+package kpl
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type AggregatedRecord struct {
+	PartitionKeyTable    []string  `protobuf:"bytes,1,rep,name=partition_key_table,json=partitionKeyTable" json:"partition_key_table,omitempty"`
+	ExplicitHashKeyTable []string  `protobuf:"bytes,2,rep,name=explicit_hash_key_table,json=explicitHashKeyTable" json:"explicit_hash_key_table,omitempty"`
+	Records              []*Record `protobuf:"bytes,3,rep,name=records" json:"records,omitempty"`
+	XXX_unrecognized     []byte    `json:"-"`
+}
+
+func (m *AggregatedRecord) Reset()                    { *m = AggregatedRecord{} }
+func (m *AggregatedRecord) String() string            { return proto.CompactTextString(m) }
+func (*AggregatedRecord) ProtoMessage()               {}
+func (*AggregatedRecord) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *AggregatedRecord) GetRecords() []*Record {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+type Tag struct {
+	Key              *string `protobuf:"bytes,1,req,name=key" json:"key,omitempty"`
+	Value            *string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Tag) Reset()                    { *m = Tag{} }
+func (m *Tag) String() string            { return proto.CompactTextString(m) }
+func (*Tag) ProtoMessage()               {}
+func (*Tag) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *Tag) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *Tag) GetValue() string {
+	if m != nil && m.Value != nil {
+		return *m.Value
+	}
+	return ""
+}
+
+type Record struct {
+	PartitionKeyIndex    *uint64 `protobuf:"varint,1,req,name=partition_key_index,json=partitionKeyIndex" json:"partition_key_index,omitempty"`
+	ExplicitHashKeyIndex *uint64 `protobuf:"varint,2,opt,name=explicit_hash_key_index,json=explicitHashKeyIndex" json:"explicit_hash_key_index,omitempty"`
+	Data                 []byte  `protobuf:"bytes,3,req,name=data" json:"data,omitempty"`
+	Tags                 []*Tag  `protobuf:"bytes,4,rep,name=tags" json:"tags,omitempty"`
+	XXX_unrecognized     []byte  `json:"-"`
+}
+
+func (m *Record) Reset()                    { *m = Record{} }
+func (m *Record) String() string            { return proto.CompactTextString(m) }
+func (*Record) ProtoMessage()               {}
+func (*Record) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *Record) GetPartitionKeyIndex() uint64 {
+	if m != nil && m.PartitionKeyIndex != nil {
+		return *m.PartitionKeyIndex
+	}
+	return 0
+}
+
+func (m *Record) GetExplicitHashKeyIndex() uint64 {
+	if m != nil && m.ExplicitHashKeyIndex != nil {
+		return *m.ExplicitHashKeyIndex
+	}
+	return 0
+}
+
+func (m *Record) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *Record) GetTags() []*Tag {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*AggregatedRecord)(nil), "kpl.AggregatedRecord")
+	proto.RegisterType((*Tag)(nil), "kpl.Tag")
+	proto.RegisterType((*Record)(nil), "kpl.Record")
+}
+
+func init() { proto.RegisterFile("kpl.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 250 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x8f, 0xcf, 0x4a, 0xc4, 0x30,
+	0x10, 0xc6, 0x69, 0x13, 0xff, 0x74, 0xd6, 0xc3, 0x1a, 0x17, 0xcc, 0xc1, 0x43, 0x29, 0x08, 0xb9,
+	0xd8, 0x83, 0xe0, 0x03, 0x78, 0x53, 0xbc, 0x85, 0xde, 0xcb, 0xb8, 0x0d, 0x69, 0x68, 0xd8, 0x86,
+	0x34, 0xca, 0xf6, 0x6d, 0xf4, 0x4d, 0x25, 0x29, 0xbb, 0x28, 0xea, 0x6d, 0x92, 0x8f, 0xdf, 0x7c,
+	0xf3, 0x83, 0x62, 0x70, 0xb6, 0x76, 0x7e, 0x0c, 0x23, 0x23, 0x83, 0xb3, 0xd5, 0x47, 0x06, 0xeb,
+	0x47, 0xad, 0xbd, 0xd2, 0x18, 0x54, 0x27, 0xd5, 0x76, 0xf4, 0x1d, 0xab, 0xe1, 0xca, 0xa1, 0x0f,
+	0x26, 0x98, 0x71, 0xd7, 0x0e, 0x6a, 0x6e, 0x03, 0xbe, 0x5a, 0xc5, 0xb3, 0x92, 0x88, 0x42, 0x5e,
+	0x1e, 0xa3, 0x17, 0x35, 0x37, 0x31, 0x60, 0x0f, 0x70, 0xad, 0xf6, 0xce, 0x9a, 0xad, 0x09, 0x6d,
+	0x8f, 0x53, 0xff, 0x8d, 0xc9, 0x13, 0xb3, 0x39, 0xc4, 0x4f, 0x38, 0xf5, 0x47, 0xec, 0x16, 0xce,
+	0x7c, 0x2a, 0x9c, 0x38, 0x29, 0x89, 0x58, 0xdd, 0xaf, 0xea, 0x78, 0xdd, 0x72, 0x84, 0x3c, 0x64,
+	0xd5, 0x1d, 0x90, 0x06, 0x35, 0x5b, 0x03, 0x19, 0xd4, 0xcc, 0xb3, 0x32, 0x17, 0x85, 0x8c, 0x23,
+	0xdb, 0xc0, 0xc9, 0x3b, 0xda, 0xb7, 0x58, 0x92, 0x89, 0x42, 0x2e, 0x8f, 0xea, 0x33, 0x83, 0xd3,
+	0xff, 0x3c, 0xcc, 0xae, 0x53, 0xfb, 0xb4, 0x82, 0xfe, 0xf4, 0x78, 0x8e, 0xc1, 0xdf, 0x1e, 0x0b,
+	0x13, 0x2b, 0xe8, 0x2f, 0x8f, 0x05, 0x63, 0x40, 0x3b, 0x0c, 0xc8, 0x49, 0x99, 0x8b, 0x0b, 0x99,
+	0x66, 0x76, 0x03, 0x34, 0xa0, 0x9e, 0x38, 0x4d, 0x62, 0xe7, 0x49, 0xac, 0x41, 0x2d, 0xd3, 0xef,
+	0xd7, 0x00, 0xeb, 0x2f, 0x39, 0x2a, 0x86, 0x01, 0x00, 0x00,
+}
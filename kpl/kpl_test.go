@@ -0,0 +1,74 @@
+package kpl
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	assert := assert.New(t)
+
+	records := []*UserRecord{
+		{PartitionKey: "a", Data: []byte("one")},
+		{PartitionKey: "b", Data: []byte("two"), ExplicitHashKey: "12345"},
+		{PartitionKey: "a", Data: []byte("three")},
+	}
+
+	a := &Aggregator{}
+	for i, r := range records {
+		size := a.SizeWith(r)
+		a.Add(r)
+		assert.Equal(size, a.Size())
+		assert.Equal(i+1, a.Len())
+	}
+	assert.Equal("a", a.PartitionKey())
+
+	data, err := a.Bytes()
+	assert.NoError(err)
+	assert.Len(data, a.Size())
+
+	// magic, protobuf, md5 of the protobuf
+	assert.Equal(Magic, data[:4])
+	sum := md5.Sum(data[4 : len(data)-16])
+	assert.Equal(sum[:], data[len(data)-16:])
+
+	agg := &AggregatedRecord{}
+	assert.NoError(proto.Unmarshal(data[4:len(data)-16], agg))
+	assert.Equal([]string{"a", "b"}, agg.PartitionKeyTable)
+	assert.Equal([]string{"12345"}, agg.ExplicitHashKeyTable)
+	assert.Equal(uint64(0), agg.Records[2].GetPartitionKeyIndex())
+
+	assert.True(IsAggregated(data))
+	out, err := Deaggregate(data)
+	assert.NoError(err)
+	assert.Equal(records, out)
+
+	a.Reset()
+	assert.Equal(0, a.Len())
+}
+
+func TestDeaggregateInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := Aggregate([]*UserRecord{{PartitionKey: "a", Data: []byte("one")}})
+	assert.NoError(err)
+
+	_, err = Deaggregate([]byte("plain old data"))
+	assert.Equal(ErrNotAggregated, err)
+
+	// a bad checksum means it's not aggregated after all
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1]++
+	assert.False(IsAggregated(corrupt))
+	_, err = Deaggregate(corrupt)
+	assert.Equal(ErrNotAggregated, err)
+
+	// a valid checksum over a bad index is an error
+	body, _ := proto.Marshal(&AggregatedRecord{Records: []*Record{{PartitionKeyIndex: proto.Uint64(1), Data: []byte("x")}}})
+	sum := md5.Sum(body)
+	_, err = Deaggregate(append(append(append([]byte(nil), Magic...), body...), sum[:]...))
+	assert.Error(err)
+}
@@ -0,0 +1,427 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4"
+
+// DefBuckets are the default histogram buckets, in seconds. They're tuned
+// for things like API calls and task durations.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// DefaultRegistry is where the New* constructors register their metrics.
+var DefaultRegistry = NewRegistry()
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics and renders them in the Prometheus text
+// exposition format.
+type Registry struct {
+	collectors []collector
+	names      map[string]bool
+	mut        sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]bool),
+	}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %s", name))
+	}
+
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Render writes every registered metric to w.
+func (r *Registry) Render(w io.Writer) {
+	r.mut.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mut.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	buf.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.Render(w)
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+// UnknownEvent is the label value EventLabel gives events whose names
+// aren't known.
+const UnknownEvent = "unknown"
+
+var eventLabel struct {
+	fn  func(name string) string
+	mut sync.RWMutex
+}
+
+// SetEventLabel sets the function EventLabel uses, which should map the
+// names of events the process doesn't handle to UnknownEvent. Workers set
+// it to label events by the dispatch function they matched.
+func SetEventLabel(fn func(name string) string) {
+	eventLabel.mut.Lock()
+	defer eventLabel.mut.Unlock()
+
+	eventLabel.fn = fn
+}
+
+// EventLabel returns the value to label metrics by event name with. Event
+// names come from clients, so labelling with them as they are would let
+// anyone add series without bound. Until SetEventLabel is called, as in a
+// process that only produces events of its own, names are used as they are.
+func EventLabel(name string) string {
+	eventLabel.mut.RLock()
+	fn := eventLabel.fn
+	eventLabel.mut.RUnlock()
+
+	if fn == nil {
+		return name
+	}
+
+	return fn(name)
+}
+
+// vec is the bookkeeping shared by all of the metric types: a family name,
+// its label names and a child per distinct set of label values.
+type vec struct {
+	name     string
+	help     string
+	kind     string
+	labels   []string
+	children map[string]interface{}
+	values   map[string][]string
+	mut      sync.Mutex
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{
+		name:     name,
+		help:     help,
+		kind:     kind,
+		labels:   labels,
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+	}
+}
+
+func (v *vec) child(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.mut.Lock()
+	defer v.mut.Unlock()
+
+	if c, ok := v.children[key]; ok {
+		return c
+	}
+
+	c := create()
+	v.children[key] = c
+	v.values[key] = append([]string(nil), values...)
+	return c
+}
+
+// each visits children in a stable order so scrapes are diffable.
+func (v *vec) each(fn func(values []string, child interface{})) {
+	v.mut.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	children := make([]interface{}, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+		values[i] = v.values[key]
+	}
+	v.mut.Unlock()
+
+	for i := range children {
+		fn(values[i], children[i])
+	}
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	value float64
+	mut   sync.Mutex
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counters can't decrease")
+	}
+
+	c.mut.Lock()
+	c.value += delta
+	c.mut.Unlock()
+}
+
+func (c *Counter) get() float64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.value
+}
+
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a counter family partitioned by the given labels and
+// registers it with the default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec creates a counter family registered with r.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(name, help, "counter", labels)}
+	r.register(name, v)
+	return v
+}
+
+// With returns the counter for the given label values, in the order the
+// labels were declared.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.child(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, child interface{}) {
+		writeSample(w, v.name, v.labels, values, "", "", child.(*Counter).get())
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	value float64
+	mut   sync.Mutex
+}
+
+func (g *Gauge) Set(value float64) {
+	g.mut.Lock()
+	g.value = value
+	g.mut.Unlock()
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.mut.Lock()
+	g.value += delta
+	g.mut.Unlock()
+}
+
+func (g *Gauge) get() float64 {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.value
+}
+
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec creates a gauge family partitioned by the given labels and
+// registers it with the default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeVec creates a gauge family registered with r.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(name, help, "gauge", labels)}
+	r.register(name, v)
+	return v
+}
+
+// NewGauge creates an unlabeled gauge and registers it with the default
+// registry.
+func NewGauge(name, help string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help)
+}
+
+// NewGauge creates an unlabeled gauge registered with r.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.child(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (v *GaugeVec) write(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, child interface{}) {
+		writeSample(w, v.name, v.labels, values, "", "", child.(*Gauge).get())
+	})
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+	mut     sync.Mutex
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram family partitioned by the given labels
+// and registers it with the default registry. If buckets is nil, DefBuckets
+// is used.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec creates a histogram family registered with r.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	v := &HistogramVec{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: buckets,
+	}
+	r.register(name, v)
+	return v
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.child(values, func() interface{} {
+		return &Histogram{
+			buckets: v.buckets,
+			counts:  make([]uint64, len(v.buckets)),
+		}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) write(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, child interface{}) {
+		h := child.(*Histogram)
+
+		h.mut.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		count, sum := h.count, h.sum
+		h.mut.Unlock()
+
+		for i, upper := range v.buckets {
+			writeSample(w, v.name+"_bucket", v.labels, values, "le", formatFloat(upper), float64(counts[i]))
+		}
+		writeSample(w, v.name+"_bucket", v.labels, values, "le", "+Inf", float64(count))
+		writeSample(w, v.name+"_sum", v.labels, values, "", "", sum)
+		writeSample(w, v.name+"_count", v.labels, values, "", "", float64(count))
+	})
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	pairs := make([]string, 0, len(labels)+1)
+	for i, label := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, escapeLabel(values[i])))
+	}
+	if extraLabel != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraLabel, extraValue))
+	}
+
+	if len(pairs) > 0 {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+		return
+	}
+
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	assert := assert.New(t)
+	registry := NewRegistry()
+
+	counter := registry.NewCounterVec("test_events_total", "Events seen.", "name")
+	counter.With("signup").Inc()
+	counter.With("signup").Add(2)
+	counter.With(`we"ird`).Inc()
+
+	gauge := registry.NewGauge("test_depth", "Queue depth.")
+	gauge.Set(4)
+	gauge.Dec()
+
+	histogram := registry.NewHistogramVec("test_duration_seconds", "Durations.", []float64{0.1, 1}, "name")
+	histogram.With("signup").Observe(0.05)
+	histogram.With("signup").Observe(0.5)
+	histogram.With("signup").Observe(5)
+
+	buf := &bytes.Buffer{}
+	registry.Render(buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE test_events_total counter",
+		`test_events_total{name="signup"} 3`,
+		`test_events_total{name="we\"ird"} 1`,
+		"# TYPE test_depth gauge",
+		"test_depth 3",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{name="signup",le="0.1"} 1`,
+		`test_duration_seconds_bucket{name="signup",le="1"} 2`,
+		`test_duration_seconds_bucket{name="signup",le="+Inf"} 3`,
+		`test_duration_seconds_sum{name="signup"} 5.55`,
+		`test_duration_seconds_count{name="signup"} 3`,
+	} {
+		assert.True(strings.Contains(out, line+"\n"), "missing line: %s", line)
+	}
+}
+
+func TestLabelMismatchPanics(t *testing.T) {
+	counter := NewRegistry().NewCounterVec("test_mismatch_total", "Mismatched.", "name", "result")
+	assert.Panics(t, func() { counter.With("signup") })
+}
+
+func TestEventLabel(t *testing.T) {
+	assert := assert.New(t)
+	defer SetEventLabel(nil)
+
+	assert.Equal("anything", EventLabel("anything"))
+
+	SetEventLabel(func(name string) string {
+		if name == "signup" {
+			return name
+		}
+		return UnknownEvent
+	})
+	assert.Equal("signup", EventLabel("signup"))
+	assert.Equal(UnknownEvent, EventLabel("made_up_1234"))
+}
@@ -0,0 +1,110 @@
+package kinesis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opsee/gmunch/kpl"
+)
+
+const (
+	// the kinesis record limit is 1MB, but the KPL keeps aggregates to a
+	// couple of PUT payload units by default and so do we
+	defaultAggregateMaxSize    = 50 * 1024
+	defaultAggregateMaxRecords = 500
+	defaultAggregateMaxDelay   = 100 * time.Millisecond
+)
+
+// batch is a filled aggregate and the publishers waiting on it.
+type batch struct {
+	agg     *kpl.Aggregator
+	first   *kpl.UserRecord
+	waiters []chan error
+}
+
+// aggregator packs events from concurrent publishers into KPL aggregated
+// records. Each publisher blocks until its record has been put, so Publish
+// still reports errors; the price is up to maxDelay of latency.
+type aggregator struct {
+	maxSize    int
+	maxRecords int
+	maxDelay   time.Duration
+	put        func(partitionKey string, data []byte, records int) error
+
+	current *batch
+	timer   *time.Timer
+	mut     sync.Mutex
+}
+
+func (a *aggregator) add(rec *kpl.UserRecord) error {
+	done := make(chan error, 1)
+
+	a.mut.Lock()
+	var full []*batch
+
+	// a record that would push us over the limit goes in the next batch
+	if a.current != nil && a.current.agg.SizeWith(rec) > a.maxSize {
+		full = append(full, a.take())
+	}
+
+	if a.current == nil {
+		a.current = &batch{agg: &kpl.Aggregator{}, first: rec}
+		a.timer = time.AfterFunc(a.maxDelay, a.flush)
+	}
+	a.current.agg.Add(rec)
+	a.current.waiters = append(a.current.waiters, done)
+
+	if a.current.agg.Len() >= a.maxRecords || a.current.agg.Size() >= a.maxSize {
+		full = append(full, a.take())
+	}
+	a.mut.Unlock()
+
+	for _, b := range full {
+		a.send(b)
+	}
+
+	return <-done
+}
+
+// take removes the current batch. Callers must hold mut.
+func (a *aggregator) take() *batch {
+	b := a.current
+	a.current = nil
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+
+	return b
+}
+
+func (a *aggregator) flush() {
+	a.mut.Lock()
+	b := a.take()
+	a.mut.Unlock()
+
+	if b != nil {
+		a.send(b)
+	}
+}
+
+func (a *aggregator) send(b *batch) {
+	err := a.sendBatch(b)
+	for _, done := range b.waiters {
+		done <- err
+	}
+}
+
+func (a *aggregator) sendBatch(b *batch) error {
+	// no point wrapping a lone record
+	if b.agg.Len() == 1 {
+		return a.put(b.first.PartitionKey, b.first.Data, 1)
+	}
+
+	data, err := b.agg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return a.put(b.agg.PartitionKey(), data, b.agg.Len())
+}
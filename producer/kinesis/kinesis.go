@@ -3,48 +3,252 @@ package kinesis
 import (
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/golang/protobuf/proto"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/claimcheck"
+	"github.com/opsee/gmunch/kpl"
 	log "github.com/opsee/logrus"
 )
 
+const errCodeThroughputExceeded = "ProvisionedThroughputExceededException"
+
 type producer struct {
-	stream string
-	rand   *rand.Rand
-	client *kinesis.Kinesis
+	logger  *log.Entry
+	stream  string
+	rand    *rand.Rand
+	randMut sync.Mutex
+	client  *kinesis.Kinesis
+
+	aggregator *aggregator
+
+	compression          string
+	compressionThreshold int
+
+	claimCheck          claimcheck.Store
+	claimCheckThreshold int
 }
 
 type Config struct {
 	Stream string
 	Region string
+
+	// Logger is used for all of the producer's logging. Defaults to the
+	// standard logger.
+	Logger *log.Logger
+
+	// AWSConfig, if set, is used for the kinesis client instead of the
+	// default config for Region, e.g. to point it at a local kinesis.
+	AWSConfig *aws.Config
+
+	// Aggregate packs events into KPL aggregated records, so that many
+	// small events cost one PUT. Publish blocks until its event's record
+	// has been put, which takes up to AggregateMaxDelay. A record is put
+	// with the partition key of its first event, so events with a
+	// partition-key header may share another key's shard.
+	Aggregate bool
+
+	// AggregateMaxSize is the largest aggregated record to put, in bytes.
+	// Defaults to 50KB.
+	AggregateMaxSize int
+
+	// AggregateMaxRecords is the most events to pack into a record.
+	// Defaults to 500.
+	AggregateMaxRecords int
+
+	// AggregateMaxDelay is the longest an event waits for others to
+	// share its record. Defaults to 100ms.
+	AggregateMaxDelay time.Duration
+
+	// Compression, if set, compresses event data with the named encoding,
+	// gmunch.EncodingGzip or gmunch.EncodingSnappy. Consumers decompress
+	// it before dispatch.
+	Compression string
+
+	// CompressionThreshold is the smallest event data to compress, in
+	// bytes. Defaults to gmunch.DefaultCompressionThreshold.
+	CompressionThreshold int
+
+	// ClaimCheck, if set, is where event data too big to send is stored.
+	// The event is sent with a reference to it instead, which consumers
+	// with the same store follow. Compression is applied first.
+	ClaimCheck claimcheck.Store
+
+	// ClaimCheckThreshold is the most event data to send inline, in bytes.
+	// Defaults to claimcheck.DefaultThreshold.
+	ClaimCheckThreshold int
 }
 
 func New(config Config) *producer {
-	return &producer{
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+
+	if config.AWSConfig == nil {
+		config.AWSConfig = aws.NewConfig().WithRegion(config.Region)
+	}
+
+	p := &producer{
+		logger: config.Logger.WithFields(log.Fields{"producer": "kinesis", "stream": config.Stream}),
 		stream: config.Stream,
 		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
-		client: kinesis.New(session.New(aws.NewConfig().WithRegion(config.Region))),
+		client: kinesis.New(session.New(config.AWSConfig)),
+
+		compression:          config.Compression,
+		compressionThreshold: config.CompressionThreshold,
+
+		claimCheck:          config.ClaimCheck,
+		claimCheckThreshold: config.ClaimCheckThreshold,
+	}
+
+	if p.compressionThreshold <= 0 {
+		p.compressionThreshold = gmunch.DefaultCompressionThreshold
+	}
+
+	if p.claimCheckThreshold <= 0 {
+		p.claimCheckThreshold = claimcheck.DefaultThreshold
 	}
+
+	if config.Aggregate {
+		if config.AggregateMaxSize <= 0 {
+			config.AggregateMaxSize = defaultAggregateMaxSize
+		}
+
+		if config.AggregateMaxRecords <= 0 {
+			config.AggregateMaxRecords = defaultAggregateMaxRecords
+		}
+
+		if config.AggregateMaxDelay <= 0 {
+			config.AggregateMaxDelay = defaultAggregateMaxDelay
+		}
+
+		p.aggregator = &aggregator{
+			maxSize:    config.AggregateMaxSize,
+			maxRecords: config.AggregateMaxRecords,
+			maxDelay:   config.AggregateMaxDelay,
+			put:        p.putRecord,
+		}
+	}
+
+	return p
 }
 
 func (p *producer) Publish(event *gmunch.Event) error {
+	if p.compression != "" || p.claimCheck != nil {
+		prepared, err := p.prepare(event)
+		if err != nil {
+			return err
+		}
+		event = prepared
+	}
+
 	pbdata, err := proto.Marshal(event)
 	if err != nil {
 		return err
 	}
 
+	// events with a partition key keep their order on one shard, the rest
+	// are spread out
+	partitionKey := event.Header(gmunch.HeaderPartitionKey)
+	if partitionKey == "" {
+		// rand.Rand isn't safe for concurrent use, and the server
+		// publishes concurrently
+		p.randMut.Lock()
+		partitionKey = fmt.Sprintf("%s-%d", event.Name, p.rand.Int63())
+		p.randMut.Unlock()
+	}
+
+	p.logger.WithFields(event.LogFields()).Debug("publishing event")
+
+	if p.aggregator != nil {
+		return p.aggregator.add(&kpl.UserRecord{
+			PartitionKey: partitionKey,
+			Data:         pbdata,
+		})
+	}
+
+	return p.putRecord(partitionKey, pbdata, 1)
+}
+
+// prepare returns a copy of the event compressed and offloaded as
+// configured, leaving the caller's alone.
+func (p *producer) prepare(event *gmunch.Event) (*gmunch.Event, error) {
+	prepared := *event
+	prepared.Headers = make(map[string]string, len(event.Headers)+1)
+	for k, v := range event.Headers {
+		prepared.Headers[k] = v
+	}
+
+	if p.compression != "" {
+		size := len(event.Data)
+		if err := prepared.Compress(p.compression, p.compressionThreshold); err != nil {
+			return nil, err
+		}
+
+		if prepared.Header(gmunch.HeaderContentEncoding) == p.compression {
+			compressedEvents.With(p.stream, p.compression).Inc()
+			compressionSavedBytes.With(p.stream, p.compression).Add(float64(size - len(prepared.Data)))
+		}
+	}
+
+	if p.claimCheck != nil {
+		if err := claimcheck.Offload(p.claimCheck, &prepared, p.claimCheckThreshold); err != nil {
+			return nil, err
+		}
+	}
+
+	return &prepared, nil
+}
+
+// putRecord puts a record holding one or more events.
+func (p *producer) putRecord(partitionKey string, data []byte, events int) error {
+	start := time.Now()
 	resp, err := p.client.PutRecord(&kinesis.PutRecordInput{
 		StreamName:   aws.String(p.stream),
-		Data:         pbdata,
-		PartitionKey: aws.String(fmt.Sprintf("%s-%d", event.Name, p.rand.Int63())),
+		Data:         data,
+		PartitionKey: aws.String(partitionKey),
 	})
+	putDuration.With(p.stream).Observe(time.Since(start).Seconds())
+	eventsPerRecord.With(p.stream).Observe(float64(events))
+
+	if err != nil {
+		putErrors.With(p.stream).Inc()
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == errCodeThroughputExceeded {
+			putThrottles.With(p.stream).Inc()
+		}
+	}
 
-	log.WithField("producer", "kinesis").Debugf("put record response: %#v", resp)
+	p.logger.WithField("events", events).Debugf("put record response: %#v", resp)
 
 	return err
 }
+
+// Healthy reports whether the stream we're producing to is reachable and
+// accepting writes.
+func (p *producer) Healthy() error {
+	out, err := p.client.DescribeStream(&kinesis.DescribeStreamInput{
+		StreamName: aws.String(p.stream),
+		Limit:      aws.Int64(1),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if out.StreamDescription == nil {
+		return fmt.Errorf("no stream found in kinesis")
+	}
+
+	switch status := aws.StringValue(out.StreamDescription.StreamStatus); status {
+	case kinesis.StreamStatusActive, kinesis.StreamStatusUpdating:
+		return nil
+	default:
+		return fmt.Errorf("kinesis stream %s is %s", p.stream, status)
+	}
+}
@@ -0,0 +1,216 @@
+package kinesis
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/claimcheck"
+	"github.com/opsee/gmunch/kinesistest"
+	"github.com/opsee/gmunch/kpl"
+	log "github.com/opsee/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProducer(srv *kinesistest.Server, stream string) *producer {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+
+	return New(Config{
+		Stream:    stream,
+		AWSConfig: srv.Config(),
+		Logger:    logger,
+	})
+}
+
+func TestPublish(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := kinesistest.NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 2)
+
+	p := newTestProducer(srv, "test")
+	for i := 0; i < 10; i++ {
+		assert.NoError(p.Publish(&gmunch.Event{Name: "test_event", Data: []byte{byte(i)}}))
+	}
+
+	// random partition keys spread events across both shards
+	records := append(srv.Records("test", "shardId-000000000000"), srv.Records("test", "shardId-000000000001")...)
+	assert.Len(records, 10)
+
+	event := &gmunch.Event{}
+	assert.NoError(proto.Unmarshal(records[0].Data, event))
+	assert.Equal("test_event", event.Name)
+}
+
+func TestPublishErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := kinesistest.NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 1)
+
+	p := newTestProducer(srv, "test")
+	srv.FailNext("PutRecord", kinesistest.ErrCodeThroughputExceeded, 1)
+	assert.Error(p.Publish(&gmunch.Event{Name: "test_event"}))
+	assert.NoError(p.Publish(&gmunch.Event{Name: "test_event"}))
+}
+
+func TestHealthy(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := kinesistest.NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 1)
+
+	assert.NoError(newTestProducer(srv, "test").Healthy())
+	assert.Error(newTestProducer(srv, "missing").Healthy())
+}
+
+func TestPublishAggregated(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := kinesistest.NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 1)
+
+	p := New(Config{
+		Stream:              "test",
+		AWSConfig:           srv.Config(),
+		Logger:              newTestProducer(srv, "test").logger.Logger,
+		Aggregate:           true,
+		AggregateMaxRecords: 10,
+		AggregateMaxDelay:   10 * time.Millisecond,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 25; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(p.Publish(&gmunch.Event{Name: "test_event", Data: []byte{byte(i)}}))
+		}(i)
+	}
+	wg.Wait()
+
+	records := srv.Records("test", "shardId-000000000000")
+	assert.True(len(records) < 25)
+
+	seen := make(map[byte]bool)
+	for _, rec := range records {
+		payloads := [][]byte{rec.Data}
+		if kpl.IsAggregated(rec.Data) {
+			userRecords, err := kpl.Deaggregate(rec.Data)
+			assert.NoError(err)
+			assert.True(len(userRecords) <= 10)
+
+			payloads = payloads[:0]
+			for _, userRecord := range userRecords {
+				payloads = append(payloads, userRecord.Data)
+			}
+		}
+
+		for _, data := range payloads {
+			event := &gmunch.Event{}
+			assert.NoError(proto.Unmarshal(data, event))
+			seen[event.Data[0]] = true
+		}
+	}
+	assert.Len(seen, 25)
+
+	// a lone event isn't wrapped
+	assert.NoError(p.Publish(&gmunch.Event{Name: "test_event"}))
+	records = srv.Records("test", "shardId-000000000000")
+	assert.False(kpl.IsAggregated(records[len(records)-1].Data))
+}
+
+func TestPublishCompressed(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := kinesistest.NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 1)
+
+	p := New(Config{
+		Stream:               "test",
+		AWSConfig:            srv.Config(),
+		Logger:               newTestProducer(srv, "test").logger.Logger,
+		Compression:          gmunch.EncodingGzip,
+		CompressionThreshold: 100,
+	})
+
+	data := bytes.Repeat([]byte("worms "), 100)
+	event := &gmunch.Event{Name: "test_event", Data: data}
+	assert.NoError(p.Publish(event))
+	assert.NoError(p.Publish(&gmunch.Event{Name: "test_event", Data: data[:10]}))
+
+	// the caller's event is left alone
+	assert.Equal(data, event.Data)
+	assert.Nil(event.Headers)
+
+	records := srv.Records("test", "shardId-000000000000")
+	assert.Len(records, 2)
+
+	published := &gmunch.Event{}
+	assert.NoError(proto.Unmarshal(records[0].Data, published))
+	assert.Equal(gmunch.EncodingGzip, published.Header(gmunch.HeaderContentEncoding))
+	assert.True(len(published.Data) < len(data))
+	assert.NoError(published.Decompress())
+	assert.Equal(data, published.Data)
+
+	// under the threshold
+	published = &gmunch.Event{}
+	assert.NoError(proto.Unmarshal(records[1].Data, published))
+	assert.Equal("", published.Header(gmunch.HeaderContentEncoding))
+
+	p = New(Config{Stream: "test", AWSConfig: srv.Config(), Compression: "lzma"})
+	assert.Error(p.Publish(event))
+}
+
+func TestPublishClaimCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := kinesistest.NewServer()
+	defer srv.Close()
+	srv.CreateStream("test", 1)
+
+	store := claimcheck.NewMemoryStore()
+	p := New(Config{
+		Stream:               "test",
+		AWSConfig:            srv.Config(),
+		Logger:               newTestProducer(srv, "test").logger.Logger,
+		Compression:          gmunch.EncodingSnappy,
+		ClaimCheck:           store,
+		ClaimCheckThreshold:  100,
+		CompressionThreshold: 100,
+	})
+
+	// compressible data is compressed under the threshold, random data isn't
+	data := bytes.Repeat([]byte("worms "), 100)
+	random := make([]byte, 1000)
+	rand.Read(random)
+
+	event := &gmunch.Event{Name: "test_event", Data: data}
+	assert.NoError(p.Publish(event))
+	assert.NoError(p.Publish(&gmunch.Event{Name: "test_event", Data: random}))
+	assert.Equal(data, event.Data)
+	assert.Equal(1, store.Len())
+
+	records := srv.Records("test", "shardId-000000000000")
+	published := &gmunch.Event{}
+	assert.NoError(proto.Unmarshal(records[0].Data, published))
+	assert.Equal("", published.Header(claimcheck.HeaderClaimCheck))
+	assert.Equal(gmunch.EncodingSnappy, published.Header(gmunch.HeaderContentEncoding))
+
+	published = &gmunch.Event{}
+	assert.NoError(proto.Unmarshal(records[1].Data, published))
+	assert.Empty(published.Data)
+	assert.NoError(claimcheck.Inline(store, published))
+	assert.Equal(random, published.Data)
+}
@@ -0,0 +1,45 @@
+package kinesis
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	putDuration = metrics.NewHistogramVec(
+		"gmunch_kinesis_producer_put_duration_seconds",
+		"Latency of kinesis PutRecord calls, by stream.",
+		nil,
+		"stream",
+	)
+
+	putErrors = metrics.NewCounterVec(
+		"gmunch_kinesis_producer_put_errors_total",
+		"Failed kinesis PutRecord calls, by stream.",
+		"stream",
+	)
+
+	putThrottles = metrics.NewCounterVec(
+		"gmunch_kinesis_producer_throttles_total",
+		"PutRecord calls rejected with ProvisionedThroughputExceededException, by stream.",
+		"stream",
+	)
+
+	eventsPerRecord = metrics.NewHistogramVec(
+		"gmunch_kinesis_producer_events_per_record",
+		"How many events were packed into each record put, by stream.",
+		[]float64{1, 2, 5, 10, 25, 50, 100, 250, 500},
+		"stream",
+	)
+
+	compressedEvents = metrics.NewCounterVec(
+		"gmunch_kinesis_producer_compressed_events_total",
+		"Events whose data was compressed before publishing, by stream and encoding.",
+		"stream", "encoding",
+	)
+
+	compressionSavedBytes = metrics.NewCounterVec(
+		"gmunch_kinesis_producer_compression_saved_bytes_total",
+		"Bytes of event data saved by compression, by stream and encoding.",
+		"stream", "encoding",
+	)
+)
@@ -5,5 +5,6 @@ import (
 )
 
 var (
-	errNoEvent = errors.New("no event provided")
+	errNoEvent         = errors.New("no event provided")
+	errSignedCleartext = errors.New("event is signed but not encrypted; clients that sign must encrypt too")
 )
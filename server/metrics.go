@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	publishTotal = metrics.NewCounterVec(
+		"gmunch_server_publish_total",
+		"Events published through the grpc service, by event name and result.",
+		"name", "result",
+	)
+
+	publishDuration = metrics.NewHistogramVec(
+		"gmunch_server_publish_duration_seconds",
+		"Time taken to hand a published event to the producer, by event name.",
+		nil,
+		"name",
+	)
+)
@@ -2,51 +2,223 @@ package server
 
 import (
 	"net"
+	"time"
 
 	log "github.com/opsee/logrus"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/admin"
+	"github.com/opsee/gmunch/cron"
+	"github.com/opsee/gmunch/deadletter"
+	"github.com/opsee/gmunch/dedupe"
+	"github.com/opsee/gmunch/delay"
+	"github.com/opsee/gmunch/envelope"
+	"github.com/opsee/gmunch/health"
 	"github.com/opsee/gmunch/producer"
+	"github.com/opsee/gmunch/signing"
+	"github.com/opsee/gmunch/trace"
 	"github.com/opsee/gmunch/worker"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	grpcauth "google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	eventsServiceName   = "gmunch.Events"
+	healthCheckInterval = 10 * time.Second
 )
 
 type server struct {
-	server   *grpc.Server
-	producer producer.Producer
-	worker   *worker.Worker
+	logger     *log.Entry
+	server     *grpc.Server
+	producer   producer.Producer
+	worker     *worker.Worker
+	health     *health.Health
+	grpcHealth *grpchealth.Server
+	admin      *admin.Server
+	stopChan   chan struct{}
+	encrypter  *envelope.Encrypter
+	signingKey *signing.Key
+	cron       *cron.Scheduler
 }
 
 type Config struct {
 	LogLevel string
+
+	// Logger is used for all of the server's and its worker's logging.
+	// Defaults to the standard logger.
+	Logger *log.Logger
+
 	Producer producer.Producer
 	Consumer worker.Consumer
 	Dispatch worker.Dispatch
 	MaxJobs  uint
+
+	// Fallback dispatches events that nothing in Dispatch matches. See
+	// worker.Config.
+	Fallback worker.DispatchFunc
+
+	// ResultHandler receives a completion record for every event the
+	// server's worker dispatches. See worker.Config.
+	ResultHandler worker.ResultHandler
+
+	// RetryPolicy and RetryPolicies retry the server's worker's failed
+	// tasks. See worker.Config.
+	RetryPolicy   *worker.RetryPolicy
+	RetryPolicies map[string]*worker.RetryPolicy
+
+	// DeadLetter receives events the server's worker gives up on. See
+	// worker.Config.
+	DeadLetter deadletter.Sink
+
+	// AdminAddr is an optional address for an http listener serving
+	// /healthz, /readyz and /metrics for both the server and its worker.
+	AdminAddr string
+
+	// Encrypter, if set, encrypts the data of the events it's configured
+	// for before they're published, unless the client already did. The
+	// server's worker uses it to decrypt them.
+	Encrypter *envelope.Encrypter
+
+	// SigningKey, if set, signs events the client didn't, after
+	// encryption.
+	SigningKey *signing.Key
+
+	// Keyring and SignaturePolicy are for the server's worker to verify
+	// event signatures with. See worker.Config.
+	Keyring         *signing.Keyring
+	SignaturePolicy signing.Policy
+
+	// Dedupe and DedupeLease are for the server's worker to skip completed
+	// and in flight events with. See worker.Config.
+	Dedupe      dedupe.Store
+	DedupeLease time.Duration
+
+	// Ordered, PartitionKeys and MaxPending make the server's worker run
+	// events with the same partition key one at a time. See worker.Config.
+	Ordered       bool
+	PartitionKeys map[string]worker.KeyFunc
+	MaxPending    int
+
+	// Lanes and EventLanes split the server's worker's MaxJobs between
+	// groups of events. See worker.Config.
+	Lanes      []worker.Lane
+	EventLanes map[string]string
+
+	// TaskTimeout and TaskTimeouts are how long the server's worker lets a
+	// task attempt run. See worker.Config.
+	TaskTimeout  time.Duration
+	TaskTimeouts map[string]time.Duration
+
+	// PanicLimit, PanicWindow and PanicCooldown disable the server's
+	// worker's handlers for events that keep panicking. See worker.Config.
+	PanicLimit    int
+	PanicWindow   time.Duration
+	PanicCooldown time.Duration
+
+	// Breaker, Breakers and TaskBreakers give the server's worker circuit
+	// breakers, whose state is served on the admin listener's /breakers.
+	// See worker.Config.
+	Breaker      *worker.BreakerConfig
+	Breakers     map[string]*worker.BreakerConfig
+	TaskBreakers map[string]*worker.BreakerConfig
+
+	// Delay holds delayed events for the server's worker until they're
+	// due, checking for them every DelayInterval. See worker.Config.
+	Delay         delay.Store
+	DelayInterval time.Duration
+
+	// Cron, if set, is started and stopped with the server, which publishes
+	// its jobs' events like any other, so that whichever worker consumes
+	// them handles them.
+	Cron *cron.Scheduler
 }
 
 func New(config Config) *server {
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+
 	level, err := log.ParseLevel(config.LogLevel)
 	if err != nil {
-		log.Warnf("couldn't parse log level: %s", config.LogLevel)
+		config.Logger.Warnf("couldn't parse log level: %s", config.LogLevel)
 	} else {
-		log.SetLevel(level)
+		config.Logger.Level = level
 	}
 
-	return &server{
+	h := health.New()
+	s := &server{
+		logger:   config.Logger.WithField("server", "grpc"),
 		producer: config.Producer,
 		worker: worker.New(worker.Config{
 			Consumer: config.Consumer,
 			Dispatch: config.Dispatch,
+			Fallback: config.Fallback,
 			MaxJobs:  config.MaxJobs,
+			Health:   h,
+			Logger:   config.Logger,
+
+			ResultHandler:   config.ResultHandler,
+			RetryPolicy:     config.RetryPolicy,
+			RetryPolicies:   config.RetryPolicies,
+			DeadLetter:      config.DeadLetter,
+			Encrypter:       config.Encrypter,
+			Keyring:         config.Keyring,
+			SignaturePolicy: config.SignaturePolicy,
+			Dedupe:          config.Dedupe,
+			DedupeLease:     config.DedupeLease,
+			Ordered:         config.Ordered,
+			PartitionKeys:   config.PartitionKeys,
+			MaxPending:      config.MaxPending,
+			Lanes:           config.Lanes,
+			EventLanes:      config.EventLanes,
+			TaskTimeout:     config.TaskTimeout,
+			TaskTimeouts:    config.TaskTimeouts,
+			PanicLimit:      config.PanicLimit,
+			PanicWindow:     config.PanicWindow,
+			PanicCooldown:   config.PanicCooldown,
+			Breaker:         config.Breaker,
+			Breakers:        config.Breakers,
+			TaskBreakers:    config.TaskBreakers,
+			Delay:           config.Delay,
+			DelayInterval:   config.DelayInterval,
 		}),
+		health:     h,
+		grpcHealth: grpchealth.NewServer(),
+		stopChan:   make(chan struct{}),
+		encrypter:  config.Encrypter,
+		signingKey: config.SigningKey,
+		cron:       config.Cron,
 	}
+
+	if checker, ok := config.Producer.(health.Checker); ok {
+		h.AddReadiness("producer", checker)
+	}
+
+	if config.AdminAddr != "" {
+		s.admin = admin.New(config.AdminAddr, h, config.Logger)
+		s.admin.Handle("/breakers", s.worker.BreakerHandler())
+	}
+
+	return s
 }
 
 func (s *server) Start(listenAddr, cert, certkey string) error {
 	go s.worker.Start()
 
+	if s.cron != nil {
+		s.cron.Start(s.emit)
+	}
+
+	if s.admin != nil {
+		go func() {
+			if err := s.admin.Start(); err != nil {
+				s.logger.WithError(err).Error("admin listener error")
+			}
+		}()
+	}
+
 	auth, err := grpcauth.NewServerTLSFromFile(cert, certkey)
 	if err != nil {
 		return err
@@ -54,6 +226,8 @@ func (s *server) Start(listenAddr, cert, certkey string) error {
 
 	s.server = grpc.NewServer(grpc.Creds(auth))
 	gmunch.RegisterEventsServer(s.server, s)
+	healthpb.RegisterHealthServer(s.server, s.grpcHealth)
+	go s.watchHealth()
 
 	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
@@ -68,14 +242,102 @@ func (s *server) Publish(ctx context.Context, event *gmunch.Event) (*gmunch.Resp
 		return nil, errNoEvent
 	}
 
-	if err := s.producer.Publish(event); err != nil {
+	if event.Id == "" {
+		event.Id = gmunch.NewEventID()
+	}
+
+	logger := s.logger.WithFields(event.LogFields())
+
+	span, ctx := trace.StartSpan(trace.Extract(ctx, event), "gmunch.produce")
+	span.SetAttribute("name", event.Name)
+	defer span.Finish()
+
+	trace.Inject(ctx, event)
+
+	if err := s.seal(event); err != nil {
+		publishTotal.With(event.Name, "error").Inc()
+		span.SetError(err)
+		logger.WithError(err).Error("couldn't seal event")
 		return nil, err
 	}
 
+	start := time.Now()
+	err := s.producer.Publish(event)
+	publishDuration.With(event.Name).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		publishTotal.With(event.Name, "error").Inc()
+		span.SetError(err)
+		logger.WithError(err).Error("couldn't publish event")
+		return nil, err
+	}
+
+	logger.Debug("published event")
+
+	publishTotal.With(event.Name, "ok").Inc()
 	return &gmunch.Response{Ok: true}, nil
 }
 
+// seal encrypts and signs the event as configured, leaving alone whatever
+// the client already did. A signed event can't be encrypted here without
+// breaking its signature.
+func (s *server) seal(event *gmunch.Event) error {
+	if s.encrypter != nil {
+		if s.encrypter.Encrypts(event.Name) && !envelope.IsEncrypted(event) && signing.IsSigned(event) {
+			return errSignedCleartext
+		}
+
+		if err := s.encrypter.Encrypt(event); err != nil {
+			return err
+		}
+	}
+
+	if s.signingKey != nil && !signing.IsSigned(event) {
+		return signing.Sign(event, s.signingKey)
+	}
+
+	return nil
+}
+
+// emit publishes an event from the cron scheduler.
+func (s *server) emit(event *gmunch.Event) error {
+	_, err := s.Publish(context.Background(), event)
+	return err
+}
+
 func (s *server) Stop() {
+	close(s.stopChan)
+
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+
 	s.worker.Stop()
 	s.server.Stop()
+
+	if s.admin != nil {
+		s.admin.Stop()
+	}
+}
+
+// watchHealth keeps the grpc health service's view of the Events service in
+// line with our readiness checks.
+func (s *server) watchHealth() {
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		for name, err := range s.health.Ready() {
+			if err != nil {
+				s.logger.WithError(err).Warnf("health check failed: %s", name)
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+		}
+		s.grpcHealth.SetServingStatus(eventsServiceName, status)
+
+		select {
+		case <-s.stopChan:
+			s.grpcHealth.SetServingStatus(eventsServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+			return
+		case <-time.After(healthCheckInterval):
+		}
+	}
 }
@@ -0,0 +1,304 @@
+// Package signing signs events so that workers can tell that they came from
+// a trusted producer and haven't been changed since. The signature covers
+// the event's name, ID, data, delivery time and headers, except for the
+// signature headers and trace context, which is rewritten at every hop.
+// Covering the ID keeps a captured event from being replayed under a new
+// one to get past deduplication. Events without the current version header
+// don't verify, so there's no older message to fall back to.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/trace"
+)
+
+const (
+	// HeaderKeyID is the event header naming the key that signed it.
+	HeaderKeyID = "signature-key-id"
+
+	// HeaderSignature is the event header holding the signature, base64
+	// encoded.
+	HeaderSignature = "signature"
+
+	// HeaderVersion is the event header naming the version of the signed
+	// message. It isn't signed itself, but changing it changes the message,
+	// so it can't be used to pass one version off as another.
+	HeaderVersion = "signature-version"
+)
+
+// version is the only version of the signed message that verifies.
+const version = "2"
+
+// Algorithm is a signature algorithm.
+type Algorithm string
+
+const (
+	HMACSHA256 Algorithm = "hmac-sha256"
+	Ed25519    Algorithm = "ed25519"
+)
+
+var (
+	ErrUnsigned     = errors.New("signing: event isn't signed")
+	ErrUnknownKey   = errors.New("signing: event is signed with an unknown key")
+	ErrBadSignature = errors.New("signing: bad signature")
+)
+
+// unsigned are headers left out of the signature.
+var unsigned = map[string]bool{
+	HeaderKeyID:             true,
+	HeaderSignature:         true,
+	HeaderVersion:           true,
+	trace.HeaderTraceParent: true,
+}
+
+// A Key signs or verifies events. HMAC keys do both; Ed25519 keys made from
+// a public key only verify.
+type Key struct {
+	ID        string
+	Algorithm Algorithm
+
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// NewHMACKey creates an HMAC-SHA256 key. The secret should be at least 32
+// random bytes.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: HMACSHA256, secret: secret}
+}
+
+// NewEd25519Key creates an Ed25519 key for signing.
+func NewEd25519Key(id string, private ed25519.PrivateKey) *Key {
+	return &Key{
+		ID:        id,
+		Algorithm: Ed25519,
+		private:   private,
+		public:    private.Public().(ed25519.PublicKey),
+	}
+}
+
+// NewEd25519PublicKey creates an Ed25519 key that can only verify.
+func NewEd25519PublicKey(id string, public ed25519.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: Ed25519, public: public}
+}
+
+// ParseKey parses a key in the form id:algorithm:base64, where algorithm is
+// hmac-sha256, ed25519 (a 32 byte seed, for signing) or ed25519-public.
+func ParseKey(spec string) (*Key, error) {
+	parts := strings.SplitN(strings.TrimSpace(spec), ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, errors.New("signing: keys must be id:algorithm:base64")
+	}
+
+	material, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signing: key %s isn't base64: %s", parts[0], err)
+	}
+
+	switch parts[1] {
+	case string(HMACSHA256):
+		return NewHMACKey(parts[0], material), nil
+	case string(Ed25519):
+		if len(material) != ed25519.SeedSize {
+			return nil, fmt.Errorf("signing: key %s isn't a %d byte ed25519 seed", parts[0], ed25519.SeedSize)
+		}
+		return NewEd25519Key(parts[0], ed25519.NewKeyFromSeed(material)), nil
+	case "ed25519-public":
+		if len(material) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("signing: key %s isn't a %d byte ed25519 public key", parts[0], ed25519.PublicKeySize)
+		}
+		return NewEd25519PublicKey(parts[0], ed25519.PublicKey(material)), nil
+	default:
+		return nil, fmt.Errorf("signing: key %s has unknown algorithm %q", parts[0], parts[1])
+	}
+}
+
+// CanSign reports whether the key can sign, rather than only verify.
+func (k *Key) CanSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k *Key) sign(message []byte) []byte {
+	if k.Algorithm == Ed25519 {
+		return ed25519.Sign(k.private, message)
+	}
+
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (k *Key) verify(message, signature []byte) bool {
+	if k.Algorithm == Ed25519 {
+		return ed25519.Verify(k.public, message, signature)
+	}
+
+	return hmac.Equal(k.sign(message), signature)
+}
+
+// IsSigned reports whether the event carries a signature.
+func IsSigned(event *gmunch.Event) bool {
+	return event.Header(HeaderSignature) != ""
+}
+
+// Sign signs the event with key, replacing any signature it had, and gives
+// it an ID first if it doesn't have one, since the ID is signed. Anything
+// that changes the event's data afterwards, like encryption, breaks the
+// signature, so sign last.
+func Sign(event *gmunch.Event, key *Key) error {
+	if !key.CanSign() {
+		return fmt.Errorf("signing: key %s can only verify", key.ID)
+	}
+
+	if event.Id == "" {
+		event.Id = gmunch.NewEventID()
+	}
+
+	event.SetHeader(HeaderKeyID, key.ID)
+	event.SetHeader(HeaderVersion, version)
+	event.SetHeader(HeaderSignature, base64.StdEncoding.EncodeToString(key.sign(message(event))))
+	return nil
+}
+
+// message is the canonical form of the event that gets signed: the name,
+// ID, data and sorted headers, each length prefixed so that fields can't
+// bleed into one another, and then the delivery time.
+func message(event *gmunch.Event) []byte {
+	keys := make([]string, 0, len(event.Headers))
+	for k := range event.Headers {
+		if !unsigned[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	m := []byte("gmunch-signature-v" + version)
+	m = appendField(m, []byte(event.Name))
+	m = appendField(m, []byte(event.Id))
+	m = appendField(m, event.Data)
+	m = binary.AppendUvarint(m, uint64(len(keys)))
+	for _, k := range keys {
+		m = appendField(m, []byte(k))
+		m = appendField(m, []byte(event.Headers[k]))
+	}
+
+	m = binary.AppendVarint(m, event.DeliverAt)
+
+	return m
+}
+
+func appendField(m, field []byte) []byte {
+	m = binary.AppendUvarint(m, uint64(len(field)))
+	return append(m, field...)
+}
+
+// A Keyring holds the keys that workers trust. It's safe for concurrent
+// use, so keys can be added and removed as they're rotated.
+type Keyring struct {
+	keys map[string]*Key
+	mut  sync.RWMutex
+}
+
+func NewKeyring(keys ...*Key) *Keyring {
+	k := &Keyring{keys: make(map[string]*Key)}
+	for _, key := range keys {
+		k.keys[key.ID] = key
+	}
+
+	return k
+}
+
+// ParseKeyring parses a comma separated list of keys in ParseKey's form.
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := NewKeyring()
+	for _, keySpec := range strings.Split(spec, ",") {
+		key, err := ParseKey(keySpec)
+		if err != nil {
+			return nil, err
+		}
+		k.Add(key)
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) Add(key *Key) {
+	k.mut.Lock()
+	defer k.mut.Unlock()
+	k.keys[key.ID] = key
+}
+
+func (k *Keyring) Remove(id string) {
+	k.mut.Lock()
+	defer k.mut.Unlock()
+	delete(k.keys, id)
+}
+
+// Key returns the key with the given ID, or nil.
+func (k *Keyring) Key(id string) *Key {
+	k.mut.RLock()
+	defer k.mut.RUnlock()
+	return k.keys[id]
+}
+
+// Verify checks the event's signature, returning ErrUnsigned,
+// ErrUnknownKey or ErrBadSignature if it can't be trusted.
+func (k *Keyring) Verify(event *gmunch.Event) error {
+	if !IsSigned(event) {
+		return ErrUnsigned
+	}
+
+	key := k.Key(event.Header(HeaderKeyID))
+	if key == nil {
+		return ErrUnknownKey
+	}
+
+	// a signature over any other message could be over one that leaves
+	// out the ID
+	if event.Header(HeaderVersion) != version {
+		return ErrBadSignature
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(event.Header(HeaderSignature))
+	if err != nil || !key.verify(message(event), signature) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// Action is what a worker does with an event that fails verification.
+type Action string
+
+const (
+	// DeadLetter drops the event and sends it to the dead letter sink, if
+	// there is one. It's the default.
+	DeadLetter Action = "dead_letter"
+
+	// Reject drops the event, only logging and counting it.
+	Reject Action = "reject"
+)
+
+// Policy says how strictly a worker verifies events.
+type Policy struct {
+	// AllowUnsigned lets unsigned events through, e.g. while producers are
+	// being moved over to signing. Events with bad signatures are still
+	// caught.
+	AllowUnsigned bool
+
+	// OnFailure is what happens to events that fail. Defaults to
+	// DeadLetter.
+	OnFailure Action
+}
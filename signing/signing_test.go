@@ -0,0 +1,132 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent() *gmunch.Event {
+	return &gmunch.Event{
+		Name:    "signup",
+		Data:    []byte("compuper@merkmertin"),
+		Headers: map[string]string{"content-encoding": "gzip"},
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	private := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	keys := []*Key{
+		NewHMACKey("hmac", bytes.Repeat([]byte{2}, 32)),
+		NewEd25519Key("ed", private),
+	}
+	keyring := NewKeyring(
+		NewHMACKey("hmac", bytes.Repeat([]byte{2}, 32)),
+		NewEd25519PublicKey("ed", private.Public().(ed25519.PublicKey)),
+	)
+
+	for _, key := range keys {
+		event := testEvent()
+		assert.Equal(ErrUnsigned, keyring.Verify(event))
+
+		assert.NoError(Sign(event, key))
+		assert.True(IsSigned(event))
+		assert.Equal(key.ID, event.Header(HeaderKeyID))
+		assert.NoError(keyring.Verify(event))
+
+		// trace context changes at every hop
+		event.SetHeader(trace.HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		assert.NoError(keyring.Verify(event))
+
+		renamed := *event
+		renamed.Name = "delete_account"
+		assert.Equal(ErrBadSignature, keyring.Verify(&renamed))
+
+		changed := *event
+		changed.Data = []byte("someone@else")
+		assert.Equal(ErrBadSignature, keyring.Verify(&changed))
+
+		delayed := *event
+		delayed.DeliverAt = 1
+		assert.Equal(ErrBadSignature, keyring.Verify(&delayed))
+
+		// a replay under a new ID would get past deduplication
+		replayed := *event
+		replayed.Id = gmunch.NewEventID()
+		assert.Equal(ErrBadSignature, keyring.Verify(&replayed))
+
+		event.SetHeader("content-encoding", "snappy")
+		assert.Equal(ErrBadSignature, keyring.Verify(event))
+		event.SetHeader("content-encoding", "gzip")
+		event.SetHeader("extra", "header")
+		assert.Equal(ErrBadSignature, keyring.Verify(event))
+	}
+
+	// only the current version verifies, since the ID is part of its message
+	key := keys[0]
+	event := testEvent()
+	assert.NoError(Sign(event, key))
+	assert.NotEmpty(event.Id)
+	assert.Equal(version, event.Header(HeaderVersion))
+	for _, v := range []string{"", "1", "3"} {
+		event.SetHeader(HeaderVersion, v)
+		assert.Equal(ErrBadSignature, keyring.Verify(event), v)
+	}
+	delete(event.Headers, HeaderVersion)
+	assert.Equal(ErrBadSignature, keyring.Verify(event))
+
+	// a signed event with its ID changed fails, so it can't be replayed
+	// past deduplication
+	event = testEvent()
+	event.Id = "original"
+	assert.NoError(Sign(event, key))
+	assert.NoError(keyring.Verify(event))
+	event.Id = "replayed"
+	assert.Equal(ErrBadSignature, keyring.Verify(event))
+
+	// a public key can't sign
+	assert.Error(Sign(testEvent(), keyring.Key("ed")))
+
+	event = testEvent()
+	assert.NoError(Sign(event, NewHMACKey("other", []byte("secret"))))
+	assert.Equal(ErrUnknownKey, keyring.Verify(event))
+
+	keyring.Remove("hmac")
+	event = testEvent()
+	assert.NoError(Sign(event, keys[0]))
+	assert.Equal(ErrUnknownKey, keyring.Verify(event))
+}
+
+func TestParseKeyring(t *testing.T) {
+	assert := assert.New(t)
+
+	seed := bytes.Repeat([]byte{1}, ed25519.SeedSize)
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+
+	signer, err := ParseKey("ed:ed25519:" + base64.StdEncoding.EncodeToString(seed))
+	assert.NoError(err)
+	assert.True(signer.CanSign())
+
+	keyring, err := ParseKeyring(
+		"ed:ed25519-public:" + base64.StdEncoding.EncodeToString(public) +
+			",hmac:hmac-sha256:" + base64.StdEncoding.EncodeToString([]byte("secret")))
+	assert.NoError(err)
+	assert.False(keyring.Key("ed").CanSign())
+	assert.NotNil(keyring.Key("hmac"))
+
+	event := testEvent()
+	assert.NoError(Sign(event, signer))
+	assert.NoError(keyring.Verify(event))
+
+	for _, spec := range []string{"ed25519:abc", "ed:rsa:" + base64.StdEncoding.EncodeToString(seed), "ed:ed25519:c2hvcnQ="} {
+		_, err = ParseKey(spec)
+		assert.Error(err)
+	}
+}
@@ -0,0 +1,79 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives finished spans. Implementations must be safe for
+// concurrent use; ExportSpan is called inline when a span finishes, so it
+// should be quick.
+type Exporter interface {
+	ExportSpan(*SpanData)
+}
+
+var (
+	globalExporter Exporter
+	exporterMut    sync.RWMutex
+)
+
+// SetExporter sets the process-wide span exporter. New traces are only
+// sampled while an exporter is set, but trace context is propagated either
+// way so that downstream services can still join the trace.
+func SetExporter(e Exporter) {
+	exporterMut.Lock()
+	defer exporterMut.Unlock()
+	globalExporter = e
+}
+
+func exporter() Exporter {
+	exporterMut.RLock()
+	defer exporterMut.RUnlock()
+
+	if globalExporter == nil {
+		return discardExporter{}
+	}
+
+	return globalExporter
+}
+
+func sampling() bool {
+	exporterMut.RLock()
+	defer exporterMut.RUnlock()
+	return globalExporter != nil
+}
+
+type discardExporter struct{}
+
+func (discardExporter) ExportSpan(*SpanData) {}
+
+// WriterExporter writes spans as JSON lines. It's meant for local
+// development, not production tracing.
+type WriterExporter struct {
+	writer io.Writer
+	mut    sync.Mutex
+}
+
+// NewWriterExporter exports spans to w, e.g. os.Stdout.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{writer: w}
+}
+
+// NewFileExporter exports spans to the file at path, appending if it
+// already exists.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterExporter(f), nil
+}
+
+func (e *WriterExporter) ExportSpan(span *SpanData) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	json.NewEncoder(e.writer).Encode(span)
+}
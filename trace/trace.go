@@ -0,0 +1,212 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsee/gmunch"
+	"golang.org/x/net/context"
+)
+
+// HeaderTraceParent is the event header we carry W3C trace context in.
+const HeaderTraceParent = "traceparent"
+
+const (
+	traceParentVersion = "00"
+	flagSampled        = 0x01
+)
+
+type spanKey struct{}
+
+// SpanContext identifies a span within a trace, and is what gets propagated
+// between processes.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	var flags byte
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%02x", traceParentVersion, hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceParent parses a W3C traceparent header value.
+func ParseTraceParent(value string) (SpanContext, error) {
+	sc := SpanContext{}
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("malformed traceparent: %q", value)
+	}
+
+	if parts[0] == traceParentVersion && len(parts) != 4 {
+		return sc, fmt.Errorf("malformed traceparent: %q", value)
+	}
+
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, err
+	}
+
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, err
+	}
+
+	flags := make([]byte, 1)
+	if err := decodeHex(flags, parts[3]); err != nil {
+		return sc, err
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("traceparent has zero ids: %q", value)
+	}
+
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("malformed traceparent field: %q", s)
+	}
+
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// SpanData is the finished record of a span handed to an Exporter.
+type SpanData struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Span is a timed operation within a trace. Spans are safe to annotate from
+// multiple goroutines, and Finish may only be called once.
+type Span struct {
+	context    SpanContext
+	parentID   [8]byte
+	name       string
+	start      time.Time
+	attributes map[string]string
+	err        error
+	mut        sync.Mutex
+}
+
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.err = err
+}
+
+// Finish ends the span and, if the trace is sampled, exports it.
+func (s *Span) Finish() {
+	if !s.context.Sampled {
+		return
+	}
+
+	s.mut.Lock()
+	data := &SpanData{
+		TraceID:    hex.EncodeToString(s.context.TraceID[:]),
+		SpanID:     hex.EncodeToString(s.context.SpanID[:]),
+		Name:       s.name,
+		Start:      s.start,
+		End:        time.Now(),
+		Attributes: s.attributes,
+	}
+	if s.parentID != [8]byte{} {
+		data.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != nil {
+		data.Error = s.err.Error()
+	}
+	s.mut.Unlock()
+
+	exporter().ExportSpan(data)
+}
+
+// StartSpan starts a span as a child of the span in ctx, or a new trace if
+// there isn't one, and returns a context carrying it.
+func StartSpan(ctx context.Context, name string) (*Span, context.Context) {
+	span := &Span{
+		name:  name,
+		start: time.Now(),
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parentID = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = sampling()
+	}
+	rand.Read(span.context.SpanID[:])
+
+	return span, context.WithValue(ctx, spanKey{}, span.context)
+}
+
+// SpanContextFromContext returns the span context in ctx, if any. This is
+// either the current local span or a remote parent restored by Extract.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Inject writes the span context in ctx to the event's traceparent header so
+// that it survives the trip through the stream.
+func Inject(ctx context.Context, event *gmunch.Event) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		event.SetHeader(HeaderTraceParent, sc.TraceParent())
+	}
+}
+
+// Extract restores the span context carried in the event's traceparent
+// header into ctx, so that spans started from it join the caller's trace.
+// Missing or malformed headers leave ctx untouched.
+func Extract(ctx context.Context, event *gmunch.Event) context.Context {
+	value := event.Header(HeaderTraceParent)
+	if value == "" {
+		return ctx
+	}
+
+	sc, err := ParseTraceParent(value)
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanKey{}, sc)
+}
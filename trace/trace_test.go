@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/opsee/gmunch"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	assert := assert.New(t)
+
+	sc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(err)
+	assert.True(sc.Sampled)
+	assert.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent())
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := ParseTraceParent(bad)
+		assert.Error(err, bad)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	SetExporter(NewWriterExporter(buf))
+	defer SetExporter(nil)
+
+	publish, ctx := StartSpan(context.Background(), "gmunch.publish")
+	event := &gmunch.Event{Name: "cool"}
+	Inject(ctx, event)
+	publish.Finish()
+
+	// pretend we've been through the stream
+	dispatch, _ := StartSpan(Extract(context.Background(), event), "gmunch.dispatch")
+	dispatch.Finish()
+
+	assert.Equal(publish.Context().TraceID, dispatch.Context().TraceID)
+	assert.NotEqual(publish.Context().SpanID, dispatch.Context().SpanID)
+
+	dec := json.NewDecoder(buf)
+	spans := []SpanData{}
+	for dec.More() {
+		span := SpanData{}
+		assert.NoError(dec.Decode(&span))
+		spans = append(spans, span)
+	}
+
+	assert.Len(spans, 2)
+	assert.Equal("gmunch.publish", spans[0].Name)
+	assert.Empty(spans[0].ParentSpanID)
+	assert.Equal("gmunch.dispatch", spans[1].Name)
+	assert.Equal(spans[0].SpanID, spans[1].ParentSpanID)
+}
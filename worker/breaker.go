@@ -0,0 +1,451 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets everything through.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen holds everything back until its cooldown has passed.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a few trial events through, closing again if
+	// they succeed and opening if they fail.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig configures a circuit breaker, which stops the worker
+// hammering a downstream service that's failing. Failed task attempts count
+// against it, unless the error isn't retryable, since a bad request doesn't
+// say anything about the service.
+type BreakerConfig struct {
+	// Threshold is the number of failed attempts in a row that opens the
+	// breaker. Defaults to 5.
+	Threshold int
+
+	// Cooldown is how long the breaker stays open before it lets trial
+	// events through. Defaults to 30 seconds.
+	Cooldown time.Duration
+
+	// Trials is the number of trial events that must succeed to close the
+	// breaker again. Defaults to 1.
+	Trials int
+
+	// DeadLetter sends events that arrive while the breaker is open to the
+	// dead letter sink, to be replayed later. Otherwise dispatch, and so
+	// consumption, pauses until the breaker lets them through.
+	DeadLetter bool
+}
+
+// BreakerStatus describes a circuit breaker, for the admin API.
+type BreakerStatus struct {
+	// Type is "event" or "task", for breakers keyed by event name and task
+	// type.
+	Type     string     `json:"type"`
+	Key      string     `json:"key"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+// circuitBreaker is the state for one event name or task type.
+type circuitBreaker struct {
+	BreakerConfig
+	kind string
+	key  string
+
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	trials    int
+	successes int
+
+	// generation counts transitions, so that trials from before one can't
+	// be handed back after it
+	generation int
+
+	// changed is closed, and replaced, whenever the state changes
+	changed chan struct{}
+	mut     sync.Mutex
+}
+
+func newCircuitBreaker(kind, key string, config BreakerConfig) *circuitBreaker {
+	if config.Threshold <= 0 {
+		config.Threshold = defaultBreakerThreshold
+	}
+
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaultBreakerCooldown
+	}
+
+	if config.Trials <= 0 {
+		config.Trials = 1
+	}
+
+	b := &circuitBreaker{
+		BreakerConfig: config,
+		kind:          kind,
+		key:           key,
+		changed:       make(chan struct{}),
+	}
+	breakerState.With(kind, key).Set(float64(BreakerClosed))
+
+	return b
+}
+
+// breakerTrial is an event let through a half-open breaker.
+type breakerTrial struct {
+	breaker    *circuitBreaker
+	generation int
+
+	// succeeded is set, under the breaker's mut, once one of the event's
+	// task attempts has succeeded
+	succeeded bool
+}
+
+// allow reports whether an event may go through, returning a trial to hand
+// back once it's done if the breaker is half-open. If it may not, it returns
+// how long until the breaker half-opens and a channel that's closed when its
+// state changes, for callers that want to wait.
+func (b *circuitBreaker) allow() (ok bool, trial *breakerTrial, retry time.Duration, changed <-chan struct{}) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.state == BreakerOpen {
+		retry = b.openedAt.Add(b.Cooldown).Sub(time.Now())
+		if retry > 0 {
+			return false, nil, retry, b.changed
+		}
+
+		b.transition(BreakerHalfOpen)
+	}
+
+	if b.state == BreakerClosed {
+		return true, nil, 0, nil
+	}
+
+	if b.trials+b.successes < b.Trials {
+		b.trials++
+		return true, &breakerTrial{breaker: b, generation: b.generation}, 0, nil
+	}
+
+	// all of the trials are out, and we'll hear back when they're done
+	return false, nil, b.Cooldown, b.changed
+}
+
+// done hands back a trial once its event is done. The event counts as one
+// success however many of its tasks succeeded, and one that ended without
+// any, because its tasks failed permanently or were cancelled, frees its
+// place for another.
+func (t *breakerTrial) done() {
+	b := t.breaker
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.generation != t.generation || b.trials == 0 {
+		return
+	}
+
+	b.trials--
+	if t.succeeded {
+		b.successes++
+		if b.successes >= b.Trials {
+			b.transition(BreakerClosed)
+			return
+		}
+	}
+
+	b.notify()
+}
+
+// record counts a task attempt's outcome. While the breaker is half-open,
+// only the outcomes of tasks holding one of its current trials count, and
+// not those of tasks let through before it opened. A failure opens it
+// again, and a success marks the trial, which counts once its event is
+// done.
+func (b *circuitBreaker) record(err error, trial *breakerTrial) {
+	if err != nil && !IsRetryable(err) {
+		return
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.state == BreakerHalfOpen && (trial == nil || trial.generation != b.generation) {
+		return
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		if err == nil {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.failures >= b.Threshold {
+			b.transition(BreakerOpen)
+		}
+
+	case BreakerHalfOpen:
+		if err != nil {
+			b.failures++
+			b.transition(BreakerOpen)
+			return
+		}
+
+		trial.succeeded = true
+	}
+}
+
+// isOpen reports whether the breaker is holding things back, so that tasks
+// can stop burning retries on it.
+func (b *circuitBreaker) isOpen() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	return b.state == BreakerOpen
+}
+
+// transition changes state. Callers must hold mut.
+func (b *circuitBreaker) transition(state BreakerState) {
+	b.state = state
+	b.generation++
+	b.trials = 0
+	b.successes = 0
+
+	switch state {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerClosed:
+		b.failures = 0
+		b.openedAt = time.Time{}
+	}
+
+	breakerState.With(b.kind, b.key).Set(float64(state))
+	breakerTransitions.With(b.kind, b.key, state.String()).Inc()
+	b.notify()
+}
+
+// notify wakes everyone waiting on a change. Callers must hold mut.
+func (b *circuitBreaker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	status := BreakerStatus{
+		Type:     b.kind,
+		Key:      b.key,
+		State:    b.state.String(),
+		Failures: b.failures,
+	}
+
+	if !b.openedAt.IsZero() {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return status
+}
+
+func (b *circuitBreaker) String() string {
+	return fmt.Sprintf("%s %s", b.kind, b.key)
+}
+
+// circuits holds the breakers for event names and task types, creating
+// them as they're first needed.
+type circuits struct {
+	defaultConfig *BreakerConfig
+	events        map[string]*BreakerConfig
+	tasks         map[string]*BreakerConfig
+
+	breakers map[string]*circuitBreaker
+	mut      sync.Mutex
+}
+
+func newCircuits(defaultConfig *BreakerConfig, events, tasks map[string]*BreakerConfig) *circuits {
+	return &circuits{
+		defaultConfig: defaultConfig,
+		events:        events,
+		tasks:         tasks,
+		breakers:      make(map[string]*circuitBreaker),
+	}
+}
+
+// forEvent returns the breaker for an event name, or nil if there isn't
+// one.
+func (c *circuits) forEvent(name string) *circuitBreaker {
+	config, ok := c.events[name]
+	if !ok {
+		config = c.defaultConfig
+	}
+
+	return c.get("event", name, config)
+}
+
+// forTask returns the breaker for a task type, or nil if there isn't one.
+func (c *circuits) forTask(kind string) *circuitBreaker {
+	return c.get("task", kind, c.tasks[kind])
+}
+
+func (c *circuits) get(kind, key string, config *BreakerConfig) *circuitBreaker {
+	if config == nil {
+		return nil
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	id := kind + ":" + key
+	b, ok := c.breakers[id]
+	if !ok {
+		b = newCircuitBreaker(kind, key, *config)
+		c.breakers[id] = b
+	}
+
+	return b
+}
+
+func (c *circuits) statuses() []BreakerStatus {
+	c.mut.Lock()
+	breakers := make([]*circuitBreaker, 0, len(c.breakers))
+	for _, b := range c.breakers {
+		breakers = append(breakers, b)
+	}
+	c.mut.Unlock()
+
+	statuses := make([]BreakerStatus, len(breakers))
+	for i, b := range breakers {
+		statuses[i] = b.status()
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Type != statuses[j].Type {
+			return statuses[i].Type < statuses[j].Type
+		}
+		return statuses[i].Key < statuses[j].Key
+	})
+
+	return statuses
+}
+
+// breakersFor returns the distinct breakers that an event's tasks answer
+// to: the event name's and those of the task types.
+func (w *Worker) breakersFor(name string, tasks []Task) []*circuitBreaker {
+	if w.circuits == nil {
+		return nil
+	}
+
+	breakers := []*circuitBreaker{}
+	seen := make(map[*circuitBreaker]bool)
+	for _, task := range tasks {
+		for _, b := range task.(*workerTask).breakers {
+			if !seen[b] {
+				seen[b] = true
+				breakers = append(breakers, b)
+			}
+		}
+	}
+
+	if b := w.circuits.forEvent(name); b != nil && !seen[b] {
+		breakers = append(breakers, b)
+	}
+
+	return breakers
+}
+
+// admit gets an event past its breakers, waiting for the ones that pause
+// dispatch. It returns the trials to hand back once the event's done, or
+// the breaker that turned it away.
+func (w *Worker) admit(ctx context.Context, breakers []*circuitBreaker) ([]*breakerTrial, error) {
+	trials := []*breakerTrial{}
+	release := func() {
+		for _, trial := range trials {
+			trial.done()
+		}
+	}
+
+	for _, b := range breakers {
+		for {
+			ok, trial, retry, changed := b.allow()
+			if ok {
+				if trial != nil {
+					trials = append(trials, trial)
+				}
+				break
+			}
+
+			if b.DeadLetter {
+				release()
+				return nil, fmt.Errorf("circuit breaker for %s is open", b)
+			}
+
+			w.logger.WithField("breaker", b.String()).Debugf("dispatch paused for up to %s", retry)
+
+			timer := time.NewTimer(retry)
+			select {
+			case <-timer.C:
+			case <-changed:
+				timer.Stop()
+			case <-ctx.Done():
+				timer.Stop()
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	return trials, nil
+}
+
+// Breakers returns the state of the worker's circuit breakers.
+func (w *Worker) Breakers() []BreakerStatus {
+	if w.circuits == nil {
+		return []BreakerStatus{}
+	}
+
+	return w.circuits.statuses()
+}
+
+// BreakerHandler serves the state of the worker's circuit breakers as JSON.
+// The worker's own admin listener serves it on /breakers.
+func (w *Worker) BreakerHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(w.Breakers())
+	})
+}
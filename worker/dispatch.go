@@ -0,0 +1,237 @@
+package worker
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opsee/gmunch/metrics"
+	log "github.com/opsee/logrus"
+)
+
+// RegexPrefix marks a dispatch pattern as a regular expression, e.g.
+// "re:^user_(created|deleted)$".
+const RegexPrefix = "re:"
+
+// dispatchTable finds the DispatchFunc for an event name. Patterns are
+// event names, globs as in path.Match, or regular expressions with
+// RegexPrefix. An exact name wins, then the longest prefix glob, i.e. one
+// whose only special character is a trailing *, then other globs and
+// regular expressions in the order they were registered, then the fallback.
+// Prefixes of the same length also go in registration order, and the
+// patterns of a Dispatch are registered in name order.
+type dispatchTable struct {
+	exact    map[string]DispatchFunc
+	prefixes []*dispatchPattern
+	patterns []*dispatchPattern
+	fallback DispatchFunc
+	mut      sync.RWMutex
+}
+
+type dispatchPattern struct {
+	pattern string
+	prefix  string
+	re      *regexp.Regexp
+	fn      DispatchFunc
+}
+
+func (p *dispatchPattern) match(name string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.prefix != "":
+		return strings.HasPrefix(name, p.prefix)
+	default:
+		ok, _ := path.Match(p.pattern, name)
+		return ok
+	}
+}
+
+func newDispatchTable(dispatch Dispatch, fallback DispatchFunc, logger *log.Entry) *dispatchTable {
+	t := &dispatchTable{
+		exact:    make(map[string]DispatchFunc),
+		fallback: fallback,
+	}
+
+	// map order is random, so register in name order to make the
+	// precedence of overlapping patterns predictable
+	patterns := make([]string, 0, len(dispatch))
+	for pattern := range dispatch {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if err := t.register(pattern, dispatch[pattern]); err != nil {
+			logger.WithError(err).Warn("ignoring dispatch function")
+		}
+	}
+
+	return t
+}
+
+func (t *dispatchTable) register(pattern string, fn DispatchFunc) error {
+	if fn == nil {
+		return fmt.Errorf("nil dispatch function for %q", pattern)
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.has(pattern) {
+		return fmt.Errorf("dispatch function for %q is already registered", pattern)
+	}
+
+	switch {
+	case strings.HasPrefix(pattern, RegexPrefix):
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, RegexPrefix))
+		if err != nil {
+			return fmt.Errorf("bad dispatch pattern %q: %s", pattern, err)
+		}
+		t.patterns = append(t.patterns, &dispatchPattern{pattern: pattern, re: re, fn: fn})
+
+	case !strings.ContainsAny(pattern, `*?[\`):
+		t.exact[pattern] = fn
+
+	default:
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad dispatch pattern %q: %s", pattern, err)
+		}
+
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix == "" || strings.ContainsAny(prefix, `*?[\`) {
+			t.patterns = append(t.patterns, &dispatchPattern{pattern: pattern, fn: fn})
+			break
+		}
+
+		t.prefixes = append(t.prefixes, &dispatchPattern{pattern: pattern, prefix: prefix, fn: fn})
+		sort.SliceStable(t.prefixes, func(i, j int) bool {
+			return len(t.prefixes[i].prefix) > len(t.prefixes[j].prefix)
+		})
+	}
+
+	return nil
+}
+
+// has reports whether the pattern is registered. The caller holds mut.
+func (t *dispatchTable) has(pattern string) bool {
+	if _, ok := t.exact[pattern]; ok {
+		return true
+	}
+
+	return t.find(pattern) != nil
+}
+
+// find returns the registered glob or regular expression with the given
+// pattern. The caller holds mut.
+func (t *dispatchTable) find(pattern string) *dispatchPattern {
+	for _, patterns := range [][]*dispatchPattern{t.prefixes, t.patterns} {
+		for _, p := range patterns {
+			if p.pattern == pattern {
+				return p
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *dispatchTable) unregister(pattern string) bool {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if _, ok := t.exact[pattern]; ok {
+		delete(t.exact, pattern)
+		return true
+	}
+
+	for _, patterns := range []*[]*dispatchPattern{&t.prefixes, &t.patterns} {
+		for i, p := range *patterns {
+			if p.pattern == pattern {
+				*patterns = append((*patterns)[:i], (*patterns)[i+1:]...)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (t *dispatchTable) replace(pattern string, fn DispatchFunc) error {
+	if fn == nil {
+		return fmt.Errorf("nil dispatch function for %q", pattern)
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if _, ok := t.exact[pattern]; ok {
+		t.exact[pattern] = fn
+		return nil
+	}
+
+	p := t.find(pattern)
+	if p == nil {
+		return fmt.Errorf("no dispatch function for %q is registered", pattern)
+	}
+
+	p.fn = fn
+	return nil
+}
+
+func (t *dispatchTable) setFallback(fn DispatchFunc) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	t.fallback = fn
+}
+
+// label returns the value to label an event's metrics with: its name if
+// that's registered, the pattern it matched, or metrics.UnknownEvent, so
+// that names made up by clients can't add series without bound.
+func (t *dispatchTable) label(name string) string {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	if _, ok := t.exact[name]; ok {
+		return name
+	}
+
+	for _, patterns := range [][]*dispatchPattern{t.prefixes, t.patterns} {
+		for _, p := range patterns {
+			if p.match(name) {
+				return p.pattern
+			}
+		}
+	}
+
+	return metrics.UnknownEvent
+}
+
+// lookup returns the DispatchFunc for an event name, and whether it's the
+// fallback.
+func (t *dispatchTable) lookup(name string) (DispatchFunc, bool, error) {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	if fn, ok := t.exact[name]; ok {
+		return fn, false, nil
+	}
+
+	for _, patterns := range [][]*dispatchPattern{t.prefixes, t.patterns} {
+		for _, p := range patterns {
+			if p.match(name) {
+				return p.fn, false, nil
+			}
+		}
+	}
+
+	if t.fallback != nil {
+		return t.fallback, true, nil
+	}
+
+	return nil, false, errNoDispatch
+}
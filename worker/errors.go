@@ -7,4 +7,7 @@ import (
 var (
 	errNoDispatch    = errors.New("no dispatch function found for event")
 	errMaxQueueDepth = errors.New("queue is full")
+	errNoEncrypter   = errors.New("event is encrypted, but there's no encrypter to decrypt it")
+	errDisabled      = errors.New("handler is disabled after repeated panics")
+	errNotConsuming  = errors.New("stopped reading from the consumer")
 )
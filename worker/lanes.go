@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"sync"
+
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+// DefaultLane is the lane for events that aren't assigned one.
+const DefaultLane = "default"
+
+// A Lane is a queue of tasks with its own limits, so that a flood of one kind
+// of event can't starve the others. Lanes share the worker's MaxJobs by
+// weight when more than one has tasks waiting.
+type Lane struct {
+	Name string
+
+	// MaxJobs is the most of the lane's tasks that run at once. Defaults
+	// to the worker's MaxJobs.
+	MaxJobs uint
+
+	// Weight is the lane's share of the worker's MaxJobs while other lanes
+	// are busy too: a lane with weight 4 starts four tasks for every one
+	// from a lane with weight 1. Defaults to 1.
+	Weight uint
+
+	// MaxQueueDepth is the most of the lane's tasks that may wait to run
+	// before dispatch blocks. Defaults to the lane's MaxJobs. An event with
+	// more tasks than that waits for the queue to empty.
+	MaxQueueDepth uint
+}
+
+// lane is a Lane's queue and accounting.
+type lane struct {
+	Lane
+	queue   []*laneJob
+	running uint
+
+	// finish is the lane's virtual finish time for weighted fair queueing:
+	// it advances by 1/Weight per task started, and the lane with the
+	// earliest one goes next
+	finish float64
+}
+
+// laneJob is a task waiting in, or started from, a lane.
+type laneJob struct {
+	task *workerTask
+	done chan struct{}
+
+	result interface{}
+	err    error
+	ran    bool
+}
+
+// wait blocks until the task has finished, or been dropped because its
+// context ended. ran reports whether it actually ran.
+func (j *laneJob) wait() (result interface{}, ran bool, err error) {
+	<-j.done
+	return j.result, j.ran, j.err
+}
+
+// laneScheduler queues tasks by lane, and hands them to the pool as slots
+// free up, picking between lanes by weighted fair queueing.
+type laneScheduler struct {
+	pool  *pool
+	lanes map[string]*lane
+	slots uint
+	clock float64
+
+	// room is closed, and replaced, whenever tasks leave a queue
+	room chan struct{}
+	mut  sync.Mutex
+}
+
+func newLaneScheduler(maxJobs uint, lanes []Lane, pool *pool, logger *log.Entry) *laneScheduler {
+	s := &laneScheduler{
+		pool:  pool,
+		lanes: make(map[string]*lane),
+		slots: maxJobs,
+		room:  make(chan struct{}),
+	}
+
+	hasDefault := false
+	for _, l := range lanes {
+		if l.Name == "" {
+			logger.Warn("ignoring lane without a name")
+			continue
+		}
+
+		if _, ok := s.lanes[l.Name]; ok {
+			logger.Warnf("ignoring duplicate lane %s", l.Name)
+			continue
+		}
+
+		if l.MaxJobs == 0 || l.MaxJobs > maxJobs {
+			l.MaxJobs = maxJobs
+		}
+
+		if l.Weight == 0 {
+			l.Weight = 1
+		}
+
+		if l.MaxQueueDepth == 0 {
+			l.MaxQueueDepth = l.MaxJobs
+		}
+
+		s.lanes[l.Name] = &lane{Lane: l}
+		hasDefault = hasDefault || l.Name == DefaultLane
+	}
+
+	if !hasDefault {
+		s.lanes[DefaultLane] = &lane{Lane: Lane{
+			Name:          DefaultLane,
+			MaxJobs:       maxJobs,
+			Weight:        1,
+			MaxQueueDepth: maxJobs,
+		}}
+	}
+
+	return s
+}
+
+// submit queues all of the tasks in the named lane, blocking until there's
+// room for them or ctx is done.
+func (s *laneScheduler) submit(ctx context.Context, name string, tasks []*workerTask) ([]*laneJob, error) {
+	for {
+		s.mut.Lock()
+		l, ok := s.lanes[name]
+		if !ok {
+			l = s.lanes[DefaultLane]
+		}
+
+		if len(l.queue) == 0 || uint(len(l.queue)+len(tasks)) <= l.MaxQueueDepth {
+			jobs := s.enqueue(l, tasks)
+			s.mut.Unlock()
+			return jobs, nil
+		}
+
+		room := s.room
+		s.mut.Unlock()
+
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// enqueue adds tasks to the lane's queue. Callers must hold mut.
+func (s *laneScheduler) enqueue(l *lane, tasks []*workerTask) []*laneJob {
+	// a lane that's been idle doesn't get to catch up on the turns it
+	// didn't need
+	if len(l.queue) == 0 && l.finish < s.clock {
+		l.finish = s.clock
+	}
+
+	jobs := make([]*laneJob, len(tasks))
+	for i, task := range tasks {
+		jobs[i] = &laneJob{task: task, done: make(chan struct{})}
+		l.queue = append(l.queue, jobs[i])
+	}
+	laneQueueDepth.With(l.Name).Set(float64(len(l.queue)))
+
+	s.schedule()
+	return jobs
+}
+
+// schedule starts tasks while there are free slots. Callers must hold mut.
+func (s *laneScheduler) schedule() {
+	started := false
+	defer func() {
+		if started {
+			close(s.room)
+			s.room = make(chan struct{})
+		}
+	}()
+
+	for s.slots > 0 {
+		var next *lane
+		for _, l := range s.lanes {
+			if len(l.queue) == 0 || l.running >= l.MaxJobs {
+				continue
+			}
+
+			if next == nil || l.finish < next.finish || (l.finish == next.finish && l.Name < next.Name) {
+				next = l
+			}
+		}
+
+		if next == nil {
+			return
+		}
+
+		job := next.queue[0]
+		next.queue = next.queue[1:]
+		next.running++
+		s.slots--
+		started = true
+
+		s.clock = next.finish
+		next.finish += 1 / float64(next.Weight)
+
+		laneQueueDepth.With(next.Name).Set(float64(len(next.queue)))
+		laneRunning.With(next.Name).Set(float64(next.running))
+
+		go s.run(next, job)
+	}
+}
+
+func (s *laneScheduler) run(l *lane, job *laneJob) {
+	defer close(job.done)
+
+	job.result, job.ran, job.err = s.pool.run(job.task)
+
+	s.mut.Lock()
+	l.running--
+	s.slots++
+	laneRunning.With(l.Name).Set(float64(l.running))
+	s.schedule()
+	s.mut.Unlock()
+}
+
+// queued returns the number of tasks waiting in every lane.
+func (s *laneScheduler) queued() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	n := 0
+	for _, l := range s.lanes {
+		n += len(l.queue)
+	}
+
+	return n
+}
+
+// full returns the name of a lane with no room left in its queue, or "".
+func (s *laneScheduler) full() string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for _, l := range s.lanes {
+		if uint(len(l.queue)) >= l.MaxQueueDepth {
+			return l.Name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,25 @@
+package worker
+
+import (
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+type loggerKey struct{}
+
+// NewLoggerContext returns a copy of ctx carrying logger.
+func NewLoggerContext(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger the worker attached to the context it handed to
+// a DispatchFunc. Its entries carry the event's name, id and origin, so
+// tasks should prefer it to the global logger. If there isn't one, an entry
+// on the standard logger is returned.
+func Logger(ctx context.Context) *log.Entry {
+	if logger, ok := ctx.Value(loggerKey{}).(*log.Entry); ok {
+		return logger
+	}
+
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"github.com/opsee/gmunch/metrics"
+)
+
+var (
+	dispatchMisses = metrics.NewCounterVec(
+		"gmunch_worker_dispatch_misses_total",
+		"Events dropped because no dispatch function was registered for them, by event name.",
+		"name",
+	)
+
+	dispatchFallbacks = metrics.NewCounterVec(
+		"gmunch_worker_dispatch_fallbacks_total",
+		"Events dispatched by the fallback because no pattern matched them, by event name.",
+		"name",
+	)
+
+	tasksTotal = metrics.NewCounterVec(
+		"gmunch_worker_tasks_total",
+		"Tasks executed, by event name, task type and outcome.",
+		"name", "task", "outcome",
+	)
+
+	taskDuration = metrics.NewHistogramVec(
+		"gmunch_worker_task_duration_seconds",
+		"Task execution time, by event name, task type and outcome.",
+		nil,
+		"name", "task", "outcome",
+	)
+
+	eventsTotal = metrics.NewCounterVec(
+		"gmunch_worker_events_total",
+		"Events whose tasks have all finished, by event name and status.",
+		"name", "status",
+	)
+
+	taskRetries = metrics.NewCounterVec(
+		"gmunch_worker_task_retries_total",
+		"Failed task attempts that were retried, by event name and task type.",
+		"name", "task",
+	)
+
+	deadLetters = metrics.NewCounterVec(
+		"gmunch_worker_dead_letters_total",
+		"Events sent to the dead letter sink, by event name.",
+		"name",
+	)
+
+	rejectedEvents = metrics.NewCounterVec(
+		"gmunch_worker_rejected_events_total",
+		"Events dropped before dispatch because they couldn't be trusted or read, by event name and reason.",
+		"name", "reason",
+	)
+
+	duplicateEvents = metrics.NewCounterVec(
+		"gmunch_worker_duplicate_events_total",
+		"Events skipped because the dedupe store had them as completed or in flight, by event name.",
+		"name",
+	)
+
+	dedupeErrors = metrics.NewCounterVec(
+		"gmunch_worker_dedupe_errors_total",
+		"Failed dedupe store claims and writes, by event name.",
+		"name",
+	)
+
+	taskPanics = metrics.NewCounterVec(
+		"gmunch_worker_task_panics_total",
+		"Task attempts that panicked, by event name and task type.",
+		"name", "task",
+	)
+
+	dispatchPanics = metrics.NewCounterVec(
+		"gmunch_worker_dispatch_panics_total",
+		"Dispatch functions that panicked, by event name.",
+		"name",
+	)
+
+	disabledHandlers = metrics.NewCounterVec(
+		"gmunch_worker_disabled_handlers_total",
+		"Times a handler was disabled after repeated panics, by event name.",
+		"name",
+	)
+
+	breakerState = metrics.NewGaugeVec(
+		"gmunch_worker_breaker_state",
+		"Circuit breaker state, by breaker type and key: 0 is closed, 1 open and 2 half open.",
+		"type", "key",
+	)
+
+	breakerTransitions = metrics.NewCounterVec(
+		"gmunch_worker_breaker_transitions_total",
+		"Circuit breaker state changes, by breaker type, key and the state changed to.",
+		"type", "key", "state",
+	)
+
+	tasksInFlight = metrics.NewGauge(
+		"gmunch_worker_tasks_in_flight",
+		"Tasks currently executing.",
+	)
+
+	orderedPending = metrics.NewGauge(
+		"gmunch_worker_ordered_pending_events",
+		"Ordered events waiting for earlier events with the same partition key to finish.",
+	)
+
+	queueDepth = metrics.NewGauge(
+		"gmunch_worker_queue_depth",
+		"Tasks queued in lanes and waiting to run.",
+	)
+
+	laneQueueDepth = metrics.NewGaugeVec(
+		"gmunch_worker_lane_queue_depth",
+		"Tasks waiting to run, by lane.",
+		"lane",
+	)
+
+	laneRunning = metrics.NewGaugeVec(
+		"gmunch_worker_lane_running_tasks",
+		"Tasks started from each lane that haven't finished yet, by lane.",
+		"lane",
+	)
+)
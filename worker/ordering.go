@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/opsee/gmunch"
+)
+
+const defaultMaxPending = 1000
+
+// A KeyFunc extracts the partition key that orders an event. Events with an
+// empty key aren't ordered.
+type KeyFunc func(*gmunch.Event) string
+
+// HeaderKey orders events by their partition-key header.
+func HeaderKey(event *gmunch.Event) string {
+	return event.Header(gmunch.HeaderPartitionKey)
+}
+
+// sequencer holds back events until everything before them with the same
+// partition key is done. Events with different keys don't wait for each
+// other.
+type sequencer struct {
+	// waiting holds, per key with an event in flight, the submissions of
+	// the events queued behind it
+	waiting map[string][]func()
+
+	// slots bounds the number of queued events, so that a hot key pushes
+	// back on the consumer rather than piling up in memory
+	slots chan struct{}
+	mut   sync.Mutex
+}
+
+func newSequencer(maxPending int) *sequencer {
+	return &sequencer{
+		waiting: make(map[string][]func()),
+		slots:   make(chan struct{}, maxPending),
+	}
+}
+
+// run calls submit now if nothing with key is in flight, or queues it to be
+// called once done has been called for everything before it. It blocks
+// while the queue is full, returning false if stop is closed first.
+func (s *sequencer) run(key string, submit func(), stop <-chan struct{}) bool {
+	s.mut.Lock()
+	queue, busy := s.waiting[key]
+	if !busy {
+		s.waiting[key] = nil
+		s.mut.Unlock()
+
+		submit()
+		return true
+	}
+	s.mut.Unlock()
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-stop:
+		return false
+	}
+
+	s.mut.Lock()
+	queue, busy = s.waiting[key]
+	if !busy {
+		// what we were waiting on finished while we waited for a slot
+		s.waiting[key] = nil
+		s.mut.Unlock()
+
+		<-s.slots
+		submit()
+		return true
+	}
+	s.waiting[key] = append(queue, submit)
+	orderedPending.Inc()
+	s.mut.Unlock()
+
+	return true
+}
+
+// done marks the event in flight for key as finished, submitting the next
+// one in line.
+func (s *sequencer) done(key string) {
+	s.mut.Lock()
+	queue := s.waiting[key]
+	if len(queue) == 0 {
+		delete(s.waiting, key)
+		s.mut.Unlock()
+		return
+	}
+
+	next := queue[0]
+	s.waiting[key] = queue[1:]
+	orderedPending.Dec()
+	s.mut.Unlock()
+
+	<-s.slots
+	next()
+}
@@ -0,0 +1,131 @@
+package worker
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/opsee/gmunch"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultPanicWindow   = time.Minute
+	defaultPanicCooldown = 5 * time.Minute
+)
+
+// PanicError is the error for a task or dispatch function that panicked.
+// Panicked tasks are retried like any other failure.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+// dispatchTasks calls the dispatch function, turning a panic into an error.
+func dispatchTasks(ctx context.Context, dispatchFunc DispatchFunc, event *gmunch.Event) (tasks []Task, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(r)
+		}
+	}()
+
+	return dispatchFunc(ctx, event), nil
+}
+
+// panicked logs and counts a panic in the handler for an event name, and
+// tells the breaker about it.
+func (w *Worker) panicked(logger *log.Entry, name string, err *PanicError) {
+	logger.WithError(err).WithField("stack", string(err.Stack)).Error("recovered from panic")
+
+	if w.breaker != nil && w.breaker.record(name) {
+		logger.Errorf("disabling handler for %s for %s after repeated panics", name, w.breaker.cooldown)
+		disabledHandlers.With(w.dispatch.label(name)).Inc()
+	}
+}
+
+// panicBreaker disables the handlers for event names that keep panicking,
+// so that a bad deploy or a poison event doesn't burn through the worker
+// one stack trace at a time.
+type panicBreaker struct {
+	limit    int
+	window   time.Duration
+	cooldown time.Duration
+
+	panics   map[string][]time.Time
+	disabled map[string]time.Time
+	mut      sync.Mutex
+}
+
+func newPanicBreaker(limit int, window, cooldown time.Duration) *panicBreaker {
+	if window <= 0 {
+		window = defaultPanicWindow
+	}
+
+	if cooldown <= 0 {
+		cooldown = defaultPanicCooldown
+	}
+
+	return &panicBreaker{
+		limit:    limit,
+		window:   window,
+		cooldown: cooldown,
+		panics:   make(map[string][]time.Time),
+		disabled: make(map[string]time.Time),
+	}
+}
+
+// record notes a panic for name, and reports whether it's the one that
+// disables it.
+func (b *panicBreaker) record(name string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	now := time.Now()
+	if _, ok := b.disabled[name]; ok {
+		return false
+	}
+
+	// forget panics that have aged out of the window
+	recent := b.panics[name]
+	for len(recent) > 0 && now.Sub(recent[0]) > b.window {
+		recent = recent[1:]
+	}
+	recent = append(recent, now)
+
+	if len(recent) < b.limit {
+		b.panics[name] = recent
+		return false
+	}
+
+	delete(b.panics, name)
+	b.disabled[name] = now.Add(b.cooldown)
+	return true
+}
+
+// allow reports whether the handler for name is enabled.
+func (b *panicBreaker) allow(name string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	until, ok := b.disabled[name]
+	if !ok {
+		return true
+	}
+
+	if time.Now().Before(until) {
+		return false
+	}
+
+	delete(b.disabled, name)
+	return true
+}
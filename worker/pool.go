@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrTaskTimeout is the error for a task attempt that ran past its timeout.
+var ErrTaskTimeout = errors.New("task timed out")
+
+// A TimeoutTask chooses its own timeout, overriding the worker's
+// per-event-name and default timeouts. Zero means no timeout.
+type TimeoutTask interface {
+	Timeout() time.Duration
+}
+
+// pool runs the tasks that lanes hand it and keeps count of them, so that
+// the worker can report what's in flight and wait for it when it stops.
+type pool struct {
+	running int
+	idle    chan struct{} // closed while nothing is running
+	mut     sync.Mutex
+}
+
+func newPool() *pool {
+	idle := make(chan struct{})
+	close(idle)
+
+	return &pool{idle: idle}
+}
+
+// run executes the task, unless its context is already done, in which case
+// it's dropped. ran reports whether it actually ran.
+func (p *pool) run(task Task) (result interface{}, ran bool, err error) {
+	if err := task.Context().Err(); err != nil {
+		return nil, false, err
+	}
+
+	p.mut.Lock()
+	p.running++
+	if p.running == 1 {
+		p.idle = make(chan struct{})
+	}
+	tasksInFlight.Set(float64(p.running))
+	p.mut.Unlock()
+
+	defer func() {
+		p.mut.Lock()
+		p.running--
+		if p.running == 0 {
+			close(p.idle)
+		}
+		tasksInFlight.Set(float64(p.running))
+		p.mut.Unlock()
+	}()
+
+	result, err = task.Execute()
+	return result, true, err
+}
+
+// inFlight returns the number of tasks running.
+func (p *pool) inFlight() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	return p.running
+}
+
+// wait blocks until nothing is running, or timeout has passed, and reports
+// whether everything finished.
+func (p *pool) wait(timeout time.Duration) bool {
+	p.mut.Lock()
+	idle := p.idle
+	p.mut.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// call runs fn with a context for the attempt, derived from ctx, and
+// turns a panic into an error. Once timeout has passed, if it's set, or
+// either context is done, the attempt is failed and its context canceled,
+// but call still waits for fn to return: Go can't stop a goroutine from the
+// outside, and an attempt that carried on in the background would run
+// alongside its retry and past the limits on what's in flight. Tasks should
+// watch the attempt's context to return promptly.
+func call(ctx, shutdown context.Context, timeout time.Duration, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+
+	attempt, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: newPanicError(r)}
+			}
+		}()
+
+		result, err := fn(attempt)
+		done <- outcome{result, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-expired:
+		err = ErrTaskTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-shutdown.Done():
+		err = shutdown.Err()
+	}
+
+	cancel()
+	<-done
+	return nil, err
+}
+
+func (w *Worker) taskTimeout(name string, task Task) time.Duration {
+	if t, ok := task.(TimeoutTask); ok {
+		return t.Timeout()
+	}
+
+	if timeout, ok := w.taskTimeouts[name]; ok {
+		return timeout
+	}
+
+	return w.defaultTaskTimeout
+}
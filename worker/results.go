@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/deadletter"
+	log "github.com/opsee/logrus"
+)
+
+// Status summarizes how all of the tasks for an event went.
+type Status int
+
+const (
+	StatusSuccess Status = iota
+	StatusPartialFailure
+	StatusFailure
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusSuccess:
+		return "success"
+	case StatusPartialFailure:
+		return "partial_failure"
+	case StatusFailure:
+		return "failure"
+	default:
+		return "unknown"
+	}
+}
+
+// TaskResult is the outcome of a single task.
+type TaskResult struct {
+	Task     Task
+	Result   interface{}
+	Err      error
+	Attempts int
+	Duration time.Duration
+
+	// Skipped is set for a task that never ran, because the worker
+	// stopped, or the event's context ended, before it got a slot. Err
+	// says why. Skipped tasks count as failed, but they didn't fail, so
+	// they aren't dead lettered.
+	Skipped bool
+}
+
+// EventResult is the completion record for an event, emitted once every task
+// dispatched for it has finished.
+type EventResult struct {
+	Event    *gmunch.Event
+	Status   Status
+	Tasks    []*TaskResult
+	Duration time.Duration
+}
+
+// Errors returns the errors of any failed tasks.
+func (r *EventResult) Errors() []error {
+	errs := []error{}
+	for _, task := range r.Tasks {
+		if task.Err != nil {
+			errs = append(errs, task.Err)
+		}
+	}
+
+	return errs
+}
+
+// A ResultHandler receives an EventResult for every event the worker
+// dispatches. It's called from its own goroutine per event, so it must be
+// safe for concurrent use.
+type ResultHandler interface {
+	HandleResult(*EventResult)
+}
+
+// ResultHandlerFunc adapts a plain function to a ResultHandler.
+type ResultHandlerFunc func(*EventResult)
+
+func (f ResultHandlerFunc) HandleResult(result *EventResult) {
+	f(result)
+}
+
+// collect waits for every job submitted for an event and hands the
+// completion record to the result handler. Tasks without a job, because
+// submitting them failed with submitErr, are reported as skipped. The
+// event is what was dispatched, and received, what's dead lettered. done,
+// if set, is called at the end.
+func (w *Worker) collect(event, received *gmunch.Event, start time.Time, logger *log.Entry, tasks []Task, jobs []*laneJob, submitErr error, done func()) {
+	if done != nil {
+		defer done()
+	}
+
+	result := &EventResult{
+		Event: event,
+		Tasks: make([]*TaskResult, len(tasks)),
+	}
+
+	failed, skipped := 0, 0
+	for i, t := range tasks {
+		task := t.(*workerTask)
+		taskResult := &TaskResult{Task: task.Task}
+
+		if i < len(jobs) {
+			var ran bool
+			taskResult.Result, ran, taskResult.Err = jobs[i].wait()
+			if ran {
+				taskResult.Attempts = task.attempts
+				taskResult.Duration = task.duration
+			} else {
+				taskResult.Skipped = true
+			}
+		} else {
+			taskResult.Err = submitErr
+			taskResult.Skipped = true
+		}
+
+		if taskResult.Err != nil {
+			failed++
+			if taskResult.Skipped {
+				skipped++
+			}
+		}
+		result.Tasks[i] = taskResult
+	}
+
+	switch {
+	case failed == 0:
+		result.Status = StatusSuccess
+	case failed == len(tasks):
+		result.Status = StatusFailure
+	default:
+		result.Status = StatusPartialFailure
+	}
+	result.Duration = time.Since(start)
+
+	eventsTotal.With(w.dispatch.label(event.Name), result.Status.String()).Inc()
+	logger.WithFields(log.Fields{
+		"status":   result.Status.String(),
+		"tasks":    len(tasks),
+		"failed":   failed,
+		"skipped":  skipped,
+		"duration": result.Duration,
+	}).Debug("event complete")
+
+	if result.Status != StatusSuccess {
+		w.abandon(logger, event)
+	} else if w.dedupe != nil && event.Id != "" {
+		if err := w.dedupe.Complete(event.Id); err != nil {
+			logger.WithError(err).Error("couldn't record event completion")
+			dedupeErrors.With(w.dispatch.label(event.Name)).Inc()
+		}
+	}
+
+	if failed > skipped && w.deadLetter != nil {
+		w.sendDeadLetter(logger, received, result)
+	}
+
+	if w.resultHandler != nil {
+		w.resultHandler.HandleResult(result)
+	}
+}
+
+// sendDeadLetter hands an event whose tasks failed for good to the dead
+// letter sink, as it was received rather than as it was dispatched, so that
+// it stays encrypted and its signature still verifies. Replaying it will
+// run all of its tasks again, not just the failed ones.
+func (w *Worker) sendDeadLetter(logger *log.Entry, received *gmunch.Event, result *EventResult) {
+	letter := &deadletter.DeadLetter{
+		Event:  received,
+		Origin: received.GetOrigin(),
+		Time:   time.Now(),
+	}
+
+	reasons := []string{}
+	for _, task := range result.Tasks {
+		if task.Err != nil && !task.Skipped {
+			kind := fmt.Sprintf("%T", task.Task)
+			letter.Tasks = append(letter.Tasks, kind)
+			reasons = append(reasons, fmt.Sprintf("%s: %s", kind, task.Err))
+		}
+	}
+	letter.Reason = strings.Join(reasons, "; ")
+
+	deadLetters.With(w.dispatch.label(result.Event.Name)).Inc()
+	if err := w.deadLetter.Send(letter); err != nil {
+		logger.WithError(err).Error("couldn't send dead letter")
+	}
+}
+
+// reject drops an event that can't be dispatched, dead lettering it as it
+// arrived if asked to and there's a sink.
+func (w *Worker) reject(logger *log.Entry, event *gmunch.Event, reason string, err error, deadLetter bool) {
+	logger.WithError(err).WithField("reason", reason).Error("rejecting event")
+	rejectedEvents.With(w.dispatch.label(event.Name), reason).Inc()
+
+	if !deadLetter || w.deadLetter == nil {
+		return
+	}
+
+	letter := &deadletter.DeadLetter{
+		Event:  event,
+		Origin: event.GetOrigin(),
+		Reason: err.Error(),
+		Time:   time.Now(),
+	}
+
+	deadLetters.With(w.dispatch.label(event.Name)).Inc()
+	if err := w.deadLetter.Send(letter); err != nil {
+		logger.WithError(err).Error("couldn't send dead letter")
+	}
+}
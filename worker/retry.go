@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"golang.org/x/net/context"
+)
+
+// RetryPolicy controls how many times a failed task is attempted and how
+// long the worker waits between attempts. Waiting happens in the task's
+// scheduler slot, so long backoffs reduce the worker's throughput.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a task is executed,
+	// including the first. Zero or one means no retries.
+	MaxAttempts int
+
+	// InitialInterval is the wait before the first retry. Each subsequent
+	// wait is Multiplier times the last, up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+
+	// RandomizationFactor jitters each wait by up to this fraction in
+	// either direction, so that a burst of failures doesn't retry in
+	// lockstep.
+	RandomizationFactor float64
+}
+
+// NoRetry is the policy used when nothing more specific is configured.
+var NoRetry = &RetryPolicy{MaxAttempts: 1}
+
+// DefaultRetryPolicy is a reasonable policy for tasks calling flaky
+// downstream APIs.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:         5,
+	InitialInterval:     500 * time.Millisecond,
+	MaxInterval:         30 * time.Second,
+	Multiplier:          2,
+	RandomizationFactor: 0.5,
+}
+
+// A RetryPolicyTask chooses its own retry policy, overriding the worker's
+// per-event-name and default policies.
+type RetryPolicyTask interface {
+	RetryPolicy() *RetryPolicy
+}
+
+// Retryable can be implemented by errors returned from Task.Execute to say
+// whether the task is worth retrying. Errors that don't implement it are
+// retried.
+type Retryable interface {
+	Retryable() bool
+}
+
+type permanentError struct {
+	error
+}
+
+func (permanentError) Retryable() bool {
+	return false
+}
+
+// Permanent marks an error as not worth retrying.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsRetryable reports whether a task that failed with err should be retried.
+func IsRetryable(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	if r, ok := err.(Retryable); ok {
+		return r.Retryable()
+	}
+
+	return true
+}
+
+func (p *RetryPolicy) backOff() backoff.BackOff {
+	if p.MaxAttempts <= 1 {
+		return &backoff.StopBackOff{}
+	}
+
+	b := &backoff.ExponentialBackOff{
+		InitialInterval:     p.InitialInterval,
+		RandomizationFactor: p.RandomizationFactor,
+		Multiplier:          p.Multiplier,
+		MaxInterval:         p.MaxInterval,
+		Clock:               &systemClock{},
+	}
+
+	// fill in anything left unset from the default policy
+	if b.InitialInterval == 0 {
+		b.InitialInterval = DefaultRetryPolicy.InitialInterval
+	}
+
+	if b.MaxInterval == 0 {
+		b.MaxInterval = DefaultRetryPolicy.MaxInterval
+	}
+
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+
+	return b
+}
+
+func (w *Worker) retryPolicy(name string, task Task) *RetryPolicy {
+	if t, ok := task.(RetryPolicyTask); ok {
+		if policy := t.RetryPolicy(); policy != nil {
+			return policy
+		}
+	}
+
+	if policy, ok := w.retryPolicies[name]; ok {
+		return policy
+	}
+
+	return w.defaultRetryPolicy
+}
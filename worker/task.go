@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opsee/gmunch/trace"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+// An AttemptAware task is told which attempt it's on, starting at 1, before
+// each call to Execute.
+type AttemptAware interface {
+	SetAttempt(attempt int)
+}
+
+// A ContextAware task is given a context for each attempt before the call
+// to Execute. It's derived from the task's own context, and also canceled
+// when the attempt times out or the worker stops. An attempt that's failed
+// holds its place among MaxJobs, and isn't retried, until Execute returns,
+// so tasks that can run long should watch it.
+type ContextAware interface {
+	SetContext(ctx context.Context)
+}
+
+// workerTask wraps every task the worker submits to a lane. It retries the
+// task according to its retry policy, times out attempts, and records how
+// each attempt went.
+type workerTask struct {
+	Task
+	name    string
+	label   string
+	kind    string
+	worker  *Worker
+	logger  *log.Entry
+	policy  *RetryPolicy
+	timeout time.Duration
+
+	// breakers count the task's attempts, and trials holds the trial
+	// each one let the task through with, if it was half-open
+	breakers []*circuitBreaker
+	trials   []*breakerTrial
+
+	// set once Execute returns
+	attempts int
+	duration time.Duration
+}
+
+func (w *Worker) wrap(name string, task Task, logger *log.Entry) *workerTask {
+	kind := fmt.Sprintf("%T", task)
+
+	t := &workerTask{
+		Task:    task,
+		name:    name,
+		label:   w.dispatch.label(name),
+		kind:    kind,
+		worker:  w,
+		logger:  logger.WithField("task", kind),
+		policy:  w.retryPolicy(name, task),
+		timeout: w.taskTimeout(name, task),
+	}
+
+	if w.circuits != nil {
+		for _, b := range []*circuitBreaker{w.circuits.forEvent(name), w.circuits.forTask(kind)} {
+			if b != nil {
+				t.breakers = append(t.breakers, b)
+			}
+		}
+	}
+
+	return t
+}
+
+func (t *workerTask) Execute() (interface{}, error) {
+	queueDepth.Set(float64(t.worker.lanes.queued()))
+
+	start := time.Now()
+	defer func() {
+		t.duration = time.Since(start)
+	}()
+
+	b := t.policy.backOff()
+	b.Reset()
+
+	for {
+		t.attempts++
+		result, err := t.execute()
+		if err == nil {
+			return result, nil
+		}
+
+		if t.attempts >= t.policy.MaxAttempts || !IsRetryable(err) {
+			return nil, err
+		}
+
+		// no point retrying against a service we've given up on for now
+		if b := t.openBreaker(); b != nil {
+			t.logger.WithError(err).WithField("breaker", b.String()).Warn("circuit breaker is open, not retrying task")
+			return nil, err
+		}
+
+		next := b.NextBackOff()
+		t.logger.WithError(err).WithField("attempt", t.attempts).Warnf("retrying task in %s", next)
+		taskRetries.With(t.label, t.kind).Inc()
+
+		select {
+		case <-time.After(next):
+		case <-t.Context().Done():
+			return nil, err
+		case <-t.worker.ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// execute runs a single attempt.
+func (t *workerTask) execute() (interface{}, error) {
+	logger := t.logger.WithField("attempt", t.attempts)
+
+	if aware, ok := t.Task.(AttemptAware); ok {
+		aware.SetAttempt(t.attempts)
+	}
+
+	// the attempt's context carries its span, so that the task's own spans
+	// nest under it
+	span, ctx := trace.StartSpan(t.Task.Context(), "gmunch.execute")
+	span.SetAttribute("name", t.name)
+	span.SetAttribute("task", t.kind)
+	span.SetAttribute("attempt", fmt.Sprint(t.attempts))
+	defer span.Finish()
+
+	start := time.Now()
+	result, err := call(ctx, t.worker.ctx, t.timeout, func(ctx context.Context) (interface{}, error) {
+		if aware, ok := t.Task.(ContextAware); ok {
+			aware.SetContext(ctx)
+		}
+
+		return t.Task.Execute()
+	})
+	duration := time.Since(start)
+
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+		span.SetError(err)
+		logger.WithError(err).Error("task failed")
+
+		if p, ok := err.(*PanicError); ok {
+			taskPanics.With(t.label, t.kind).Inc()
+			t.worker.panicked(logger, t.name, p)
+		}
+	}
+
+	for i, b := range t.breakers {
+		b.record(err, t.trials[i])
+	}
+
+	tasksTotal.With(t.label, t.kind, outcome).Inc()
+	taskDuration.With(t.label, t.kind, outcome).Observe(duration.Seconds())
+
+	return result, err
+}
+
+// hold gives the task the trials its event was admitted with, matched up
+// with its breakers.
+func (t *workerTask) hold(trials []*breakerTrial) {
+	t.trials = make([]*breakerTrial, len(t.breakers))
+	for i, b := range t.breakers {
+		for _, trial := range trials {
+			if trial.breaker == b {
+				t.trials[i] = trial
+			}
+		}
+	}
+}
+
+func (t *workerTask) openBreaker() *circuitBreaker {
+	for _, b := range t.breakers {
+		if b.isOpen() {
+			return b
+		}
+	}
+
+	return nil
+}
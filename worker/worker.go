@@ -1,20 +1,43 @@
 package worker
 
 import (
-	"sync"
+	"fmt"
 	"time"
 
 	log "github.com/opsee/logrus"
-	"github.com/cenkalti/backoff"
-	"github.com/grepory/scheduler"
+	"github.com/golang/protobuf/proto"
 	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/admin"
+	"github.com/opsee/gmunch/cron"
+	"github.com/opsee/gmunch/deadletter"
+	"github.com/opsee/gmunch/dedupe"
+	"github.com/opsee/gmunch/delay"
+	"github.com/opsee/gmunch/envelope"
+	"github.com/opsee/gmunch/health"
+	"github.com/opsee/gmunch/metrics"
+	"github.com/opsee/gmunch/signing"
+	"github.com/opsee/gmunch/trace"
+	"golang.org/x/net/context"
 )
 
+// Dispatch maps patterns to the DispatchFuncs for the events they match.
+// A pattern is an event name, a glob like "user_*" as in path.Match, or a
+// regular expression with RegexPrefix. An exact name takes precedence, then
+// the longest prefix glob, then the other patterns in the order they were
+// registered. The patterns in a Dispatch are registered in name order.
 type Dispatch map[string]DispatchFunc
-type DispatchFunc func(*gmunch.Event) []Task
 
+// DispatchFunc turns an event into the tasks that handle it. The context
+// carries the event's trace, and tasks should derive their own contexts
+// from it.
+type DispatchFunc func(context.Context, *gmunch.Event) []Task
+
+// A Task is a unit of work for an event. Execute is failed once the task's
+// context is done, though it holds its place until it returns, and the
+// worker cancels the contexts of the tasks it dispatches when it stops.
 type Task interface {
-	scheduler.Task
+	Context() context.Context
+	Execute() (interface{}, error)
 }
 
 type Consumer interface {
@@ -27,39 +50,272 @@ type Config struct {
 	Dispatch Dispatch
 	Consumer Consumer
 	MaxJobs  uint
+
+	// Fallback, if set, dispatches events that nothing in Dispatch
+	// matches. Without it, they're logged and dropped.
+	Fallback DispatchFunc
+
+	// Logger is used for all of the worker's logging. Defaults to the
+	// standard logger.
+	Logger *log.Logger
+
+	// AdminAddr is an optional address for an http listener serving
+	// /healthz, /readyz and /metrics.
+	AdminAddr string
+
+	// Health is where the worker registers its health checks. If it's nil,
+	// the worker keeps its own.
+	Health *health.Health
+
+	// ResultHandler, if set, receives a completion record for every event
+	// once all of its tasks have finished.
+	ResultHandler ResultHandler
+
+	// RetryPolicy is used for tasks that don't have a more specific policy.
+	// Defaults to NoRetry.
+	RetryPolicy *RetryPolicy
+
+	// RetryPolicies sets retry policies per event name.
+	RetryPolicies map[string]*RetryPolicy
+
+	// DeadLetter, if set, receives events with tasks that failed for good.
+	DeadLetter deadletter.Sink
+
+	// Encrypter decrypts encrypted events before dispatch. Events that
+	// can't be decrypted, or that arrive encrypted without one, are dead
+	// lettered and dropped.
+	Encrypter *envelope.Encrypter
+
+	// Keyring, if set, verifies event signatures before dispatch, and
+	// before decryption.
+	Keyring *signing.Keyring
+
+	// SignaturePolicy says what to do with unsigned events and events that
+	// fail verification. By default they're dead lettered and dropped.
+	SignaturePolicy signing.Policy
+
+	// Dedupe, if set, records the IDs of events whose tasks all succeeded,
+	// and events it has already seen complete are skipped. Completion is
+	// recorded after the tasks finish, so a crash in between still means
+	// the event is handled again. An event is claimed before it's
+	// dispatched, so copies that arrive while it's being handled are
+	// skipped too, and the claim is abandoned if it fails.
+	Dedupe dedupe.Store
+
+	// DedupeLease is how long a claim on an event lasts, in case the
+	// worker holding it dies. Defaults to dedupe.DefaultLease.
+	DedupeLease time.Duration
+
+	// Ordered makes events that share a partition key run one at a time,
+	// in the order they were consumed: an event's tasks aren't submitted
+	// until all of the tasks of the one before it have finished. Events
+	// with different keys still run in parallel, up to MaxJobs.
+	Ordered bool
+
+	// PartitionKeys extracts partition keys by event name. Other events
+	// are keyed by their partition-key header, and events without a key
+	// aren't ordered.
+	PartitionKeys map[string]KeyFunc
+
+	// MaxPending is the most ordered events that may wait on the ones
+	// before them before dispatch blocks. Defaults to 1000.
+	MaxPending int
+
+	// Lanes give groups of events their own queues and limits, sharing
+	// MaxJobs between them by weight. Events go in the default lane unless
+	// EventLanes says otherwise, and the default lane, if it isn't
+	// configured here, may use all of MaxJobs.
+	Lanes []Lane
+
+	// EventLanes assigns events to lanes by event name.
+	EventLanes map[string]string
+
+	// TaskTimeout, if set, is how long a task attempt may run before it's
+	// failed with ErrTaskTimeout and, for ContextAware tasks, its context
+	// is canceled. It isn't retried until it returns.
+	TaskTimeout time.Duration
+
+	// TaskTimeouts sets task timeouts per event name.
+	TaskTimeouts map[string]time.Duration
+
+	// PanicLimit, if set, disables the handler for an event name once its
+	// dispatch function or tasks have panicked this many times within
+	// PanicWindow, which defaults to a minute. Events for it are dead
+	// lettered until PanicCooldown, five minutes by default, has passed.
+	PanicLimit    int
+	PanicWindow   time.Duration
+	PanicCooldown time.Duration
+
+	// Breaker, if set, gives every event name a circuit breaker that opens
+	// when its tasks keep failing. Breakers overrides it by event name, and
+	// TaskBreakers adds breakers by task type, for downstream services that
+	// tasks for several events call. Breaker state is served on the admin
+	// listener's /breakers.
+	Breaker      *BreakerConfig
+	Breakers     map[string]*BreakerConfig
+	TaskBreakers map[string]*BreakerConfig
+
+	// Delay, if set, holds events with a delivery time in the future
+	// until they're due, checking for due events every DelayInterval.
+	// Without it, such events are dispatched as soon as they arrive.
+	Delay         delay.Store
+	DelayInterval time.Duration
+
+	// Cron, if set, is started and stopped with the worker, which
+	// dispatches its jobs' events itself rather than publishing them. To
+	// have any instance's worker handle them, attach it to a server
+	// instead. They skip signature verification, since they're made here,
+	// and a tick whose event is rejected is fired again, not dead lettered.
+	Cron *cron.Scheduler
 }
 
+// how long Stop waits for the worker loop, and then its tasks, to finish
+const stopTimeout = 5 * time.Second
+
 type Worker struct {
-	dispatch    Dispatch
-	consumer    Consumer
-	scheduler   *scheduler.Scheduler
-	dispatchMut sync.Mutex
-	stopChan    chan struct{}
-	stoppedChan chan struct{}
-	stopping    bool
-	logger      *log.Entry
+	dispatch *dispatchTable
+	consumer Consumer
+	lanes    *laneScheduler
+	pool     *pool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	logger   *log.Entry
+	health   *health.Health
+	admin    *admin.Server
+
+	resultHandler      ResultHandler
+	defaultRetryPolicy *RetryPolicy
+	retryPolicies      map[string]*RetryPolicy
+	deadLetter         deadletter.Sink
+	encrypter          *envelope.Encrypter
+	keyring            *signing.Keyring
+	signaturePolicy    signing.Policy
+	dedupe             dedupe.Store
+	dedupeLease        time.Duration
+	sequencer          *sequencer
+	partitionKeys      map[string]KeyFunc
+	eventLanes         map[string]string
+	defaultTaskTimeout time.Duration
+	taskTimeouts       map[string]time.Duration
+	breaker            *panicBreaker
+	circuits           *circuits
+	timer              *delay.Timer
+	cron               *cron.Scheduler
 }
 
 func New(config Config) *Worker {
-	logger := log.WithField("worker", "worker")
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+
+	logger := config.Logger.WithField("worker", "worker")
 
 	if config.MaxJobs == 0 {
 		config.MaxJobs = 4
 		logger.Warn("MaxJobs not set, defaulting to 4")
 	}
 
-	return &Worker{
-		dispatch:    config.Dispatch,
-		consumer:    config.Consumer,
-		scheduler:   scheduler.NewScheduler(config.MaxJobs),
-		stopChan:    make(chan struct{}, 1),
-		stoppedChan: make(chan struct{}, 1),
-		logger:      logger,
+	if config.Health == nil {
+		config.Health = health.New()
+	}
+
+	if config.RetryPolicy == nil {
+		config.RetryPolicy = NoRetry
+	}
+
+	if config.DedupeLease <= 0 {
+		config.DedupeLease = dedupe.DefaultLease
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := newPool()
+
+	w := &Worker{
+		dispatch: newDispatchTable(config.Dispatch, config.Fallback, logger),
+		consumer: config.Consumer,
+		lanes:    newLaneScheduler(config.MaxJobs, config.Lanes, pool, logger),
+		pool:     pool,
+		ctx:      ctx,
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+		logger:   logger,
+		health:   config.Health,
+
+		resultHandler:      config.ResultHandler,
+		defaultRetryPolicy: config.RetryPolicy,
+		retryPolicies:      config.RetryPolicies,
+		deadLetter:         config.DeadLetter,
+		encrypter:          config.Encrypter,
+		keyring:            config.Keyring,
+		signaturePolicy:    config.SignaturePolicy,
+		dedupe:             config.Dedupe,
+		dedupeLease:        config.DedupeLease,
+		partitionKeys:      config.PartitionKeys,
+		eventLanes:         config.EventLanes,
+		defaultTaskTimeout: config.TaskTimeout,
+		taskTimeouts:       config.TaskTimeouts,
+		cron:               config.Cron,
+	}
+
+	if config.Ordered {
+		if config.MaxPending <= 0 {
+			config.MaxPending = defaultMaxPending
+		}
+		w.sequencer = newSequencer(config.MaxPending)
+	}
+
+	if config.PanicLimit > 0 {
+		w.breaker = newPanicBreaker(config.PanicLimit, config.PanicWindow, config.PanicCooldown)
+	}
+
+	if config.Breaker != nil || len(config.Breakers) > 0 || len(config.TaskBreakers) > 0 {
+		w.circuits = newCircuits(config.Breaker, config.Breakers, config.TaskBreakers)
+	}
+
+	// the other packages labelling metrics by event name, like delay and
+	// claimcheck, use the names we handle too
+	metrics.SetEventLabel(w.dispatch.label)
+
+	if config.Delay != nil {
+		w.timer = delay.NewTimer(config.Delay, w.DispatchEvent, config.DelayInterval, config.Logger)
+	}
+
+	// a consumer that hasn't read anything yet, e.g. from a quiet stream,
+	// isn't ready, but restarting us won't help it
+	if checker, ok := config.Consumer.(health.Checker); ok {
+		w.health.AddReadiness("consumer", checker)
+	}
+	w.health.AddReadiness("scheduler", health.CheckerFunc(w.checkSaturation))
+	w.health.AddLiveness("consumer_loop", health.CheckerFunc(w.checkConsuming))
+
+	if config.AdminAddr != "" {
+		w.admin = admin.New(config.AdminAddr, w.health, config.Logger)
+		w.admin.Handle("/breakers", w.BreakerHandler())
 	}
+
+	return w
 }
 
 func (w *Worker) Start() error {
 	w.logger.Info("starting")
+	defer close(w.stopped)
+
+	if w.admin != nil {
+		go func() {
+			if err := w.admin.Start(); err != nil {
+				w.logger.WithError(err).Error("admin listener error")
+			}
+		}()
+	}
+
+	if w.timer != nil {
+		w.timer.Start()
+	}
+
+	if w.cron != nil {
+		w.cron.Start(w.emit)
+	}
 
 	errChan := make(chan error)
 	go func() {
@@ -76,122 +332,390 @@ func (w *Worker) Start() error {
 				return nil
 			}
 
-			w.logger.Debugf("got event from consumer: %s", event.Name)
+			w.logger.WithFields(event.LogFields()).Debug("got event from consumer")
 
 			err = w.DispatchEvent(event)
 			if err != nil {
-				// we've tried too long to submit our tasks to the queue,
-				// so let's just shut down
+				if w.ctx.Err() != nil {
+					// we were stopped while waiting for room in
+					// the queue
+					return nil
+				}
+
 				return err
 			}
 
 		case err = <-errChan:
 			return err
+
+		case <-w.ctx.Done():
+			return nil
 		}
 	}
-
-	w.stoppedChan <- struct{}{}
-	return nil
 }
 
 func (w *Worker) DispatchEvent(event *gmunch.Event) error {
-	dispatchFunc, err := w.getDispatch(event)
+	return w.dispatchEvent(event, false)
+}
+
+// emit dispatches an event from the cron scheduler. It was made here, so
+// it isn't verified, and if it's rejected the error is returned instead of
+// the event being dead lettered, so that the scheduler fires the tick again.
+func (w *Worker) emit(event *gmunch.Event) error {
+	return w.dispatchEvent(event, true)
+}
+
+func (w *Worker) dispatchEvent(event *gmunch.Event, local bool) error {
+	start := time.Now()
+	span, ctx := trace.StartSpan(trace.Extract(w.ctx, event), "gmunch.dispatch")
+	span.SetAttribute("name", event.Name)
+	defer span.Finish()
+
+	logger := w.logger.WithFields(event.LogFields())
+	ctx = NewLoggerContext(ctx, logger)
+
+	// event is what's dispatched, which may be a decrypted copy, and
+	// received is what's dead lettered
+	received := event
+
+	rejected := func(reason string, err error, deadLetter bool) error {
+		w.reject(logger, received, reason, err, deadLetter && !local)
+		span.SetError(err)
+		if local {
+			return err
+		}
+
+		return nil
+	}
+
+	if w.keyring != nil && !local {
+		if err := w.verify(event); err != nil {
+			reason := "signature"
+			if err == signing.ErrUnsigned {
+				reason = "unsigned"
+			}
+
+			return rejected(reason, err, w.signaturePolicy.OnFailure != signing.Reject)
+		}
+	}
+
+	if !delay.IsDue(event, start) {
+		if w.timer == nil {
+			logger.Warn("no delay store, dispatching delayed event early")
+		} else {
+			// it's verified before it's stored, so that untrusted
+			// events can't fill the store, and stored as it arrived,
+			// to be verified again and decrypted when it's due
+			if err := w.timer.Schedule(event); err != nil {
+				return rejected("delay", err, true)
+			}
+
+			logger.Debug("delayed event")
+			span.SetAttribute("delayed", "true")
+			return nil
+		}
+	}
+
+	if envelope.IsEncrypted(event) {
+		decrypted, err := w.decrypt(event)
+		if err != nil {
+			return rejected("decrypt", err, true)
+		}
+		event = decrypted
+	}
+
+	if w.duplicate(logger, event) {
+		span.SetAttribute("duplicate", "true")
+		return nil
+	}
+
+	dispatchFunc, fallback, err := w.dispatch.lookup(event.Name)
 	if err != nil {
-		// just log and ignore
-		w.logger.WithError(err).Error("no dispatch function for event: ", event.Name)
+		// just log and ignore, unless it's the scheduler's, which can try
+		// again once there's a dispatch function for it
+		w.abandon(logger, event)
+		logger.WithError(err).Error("no dispatch function for event")
+		dispatchMisses.With(w.dispatch.label(event.Name)).Inc()
+		span.SetError(err)
+		if local {
+			return err
+		}
+
 		return nil
 	}
 
-	return w.trySubmit(dispatchFunc(event))
+	if fallback {
+		logger.Debug("dispatching event to the fallback")
+		dispatchFallbacks.With(w.dispatch.label(event.Name)).Inc()
+	}
+
+	if w.breaker != nil && !w.breaker.allow(event.Name) {
+		w.abandon(logger, event)
+		return rejected("disabled", errDisabled, true)
+	}
+
+	tasks, err := dispatchTasks(ctx, dispatchFunc, event)
+	if err != nil {
+		dispatchPanics.With(w.dispatch.label(event.Name)).Inc()
+		w.panicked(logger, event.Name, err.(*PanicError))
+		w.abandon(logger, event)
+		return rejected("panic", err, true)
+	}
+
+	for i, task := range tasks {
+		tasks[i] = w.wrap(event.Name, task, logger)
+	}
+
+	trials, err := w.admit(ctx, w.breakersFor(event.Name, tasks))
+	if err != nil {
+		w.abandon(logger, event)
+		if w.ctx.Err() != nil {
+			return err
+		}
+
+		return rejected("breaker", err, true)
+	}
+
+	for _, task := range tasks {
+		task.(*workerTask).hold(trials)
+	}
+
+	release := func() {
+		for _, trial := range trials {
+			trial.done()
+		}
+	}
+
+	key := w.partitionKey(event)
+	if key == "" {
+		err = w.submit(event, received, start, logger, tasks, release)
+		if err != nil {
+			span.SetError(err)
+		}
+
+		return err
+	}
+
+	// ordered events are submitted once the one before them is done, which
+	// may be from another event's collector
+	submit := func() {
+		err := w.submit(event, received, start, logger, tasks, func() {
+			release()
+			w.sequencer.done(key)
+		})
+		if err != nil {
+			logger.WithError(err).Error("couldn't submit ordered event")
+		}
+	}
+
+	if !w.sequencer.run(key, submit, w.ctx.Done()) {
+		release()
+		w.abandon(logger, event)
+		return w.ctx.Err()
+	}
+
+	return nil
 }
 
+// submit queues the event's tasks in its lane and starts collecting
+// their results. done, if set, is called once they've all finished.
+func (w *Worker) submit(event, received *gmunch.Event, start time.Time, logger *log.Entry, tasks []Task, done func()) error {
+	wrapped := make([]*workerTask, len(tasks))
+	for i, task := range tasks {
+		wrapped[i] = task.(*workerTask)
+	}
+
+	// a lane takes all of an event's tasks or none of them, and blocks
+	// until there's room, which is how the consumer feels backpressure
+	jobs, err := w.lanes.submit(w.ctx, w.lane(event), wrapped)
+	if err != nil {
+		w.collect(event, received, start, logger, tasks, nil, err, done)
+		return err
+	}
+
+	queueDepth.Set(float64(w.lanes.queued()))
+	go w.collect(event, received, start, logger, tasks, jobs, nil, done)
+
+	return nil
+}
+
+// lane returns the name of the lane the event's tasks go in.
+func (w *Worker) lane(event *gmunch.Event) string {
+	if lane, ok := w.eventLanes[event.Name]; ok {
+		return lane
+	}
+
+	return DefaultLane
+}
+
+// partitionKey returns the key that orders the event, or "" if it isn't
+// ordered.
+func (w *Worker) partitionKey(event *gmunch.Event) string {
+	if w.sequencer == nil {
+		return ""
+	}
+
+	if keyFunc, ok := w.partitionKeys[event.Name]; ok {
+		return keyFunc(event)
+	}
+
+	return HeaderKey(event)
+}
+
+// Stop stops consuming, cancels the contexts of the tasks in flight and
+// waits a while for them to return.
 func (w *Worker) Stop() {
 	w.logger.Info("stopping")
 	w.consumer.Stop()
 
-	defer close(w.stopChan)
-	defer close(w.stoppedChan)
+	if w.admin != nil {
+		w.admin.Stop()
+	}
+
+	w.cancel()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	if w.cron != nil {
+		w.cron.Stop()
+	}
 
-	w.stopChan <- struct{}{}
 	select {
-	case <-w.stoppedChan:
-	case <-time.After(5 * time.Second):
+	case <-w.stopped:
+	case <-time.After(stopTimeout):
+	}
+
+	if !w.pool.wait(stopTimeout) {
+		w.logger.Warnf("stopped with %d tasks still running", w.pool.inFlight())
 	}
 	w.logger.Info("stopped")
 }
 
-func (w *Worker) shouldStop() bool {
-	if w.stopping {
-		return true
+// QueueDepth returns the number of tasks waiting to run.
+func (w *Worker) QueueDepth() int {
+	return w.lanes.queued()
+}
+
+// InFlight returns the number of tasks running.
+func (w *Worker) InFlight() int {
+	return w.pool.inFlight()
+}
+
+// Register adds a DispatchFunc for the events matching pattern, which is
+// an event name, a glob or a regular expression as in Dispatch. It takes
+// precedence after the overlapping patterns already registered. It's an
+// error if the pattern is invalid or already registered. It's safe to call
+// while the worker is running, and affects events dispatched after it
+// returns.
+func (w *Worker) Register(pattern string, dispatchFunc DispatchFunc) error {
+	return w.dispatch.register(pattern, dispatchFunc)
+}
+
+// Unregister removes the pattern's DispatchFunc, reporting whether there
+// was one. Tasks already dispatched by it run to completion.
+func (w *Worker) Unregister(pattern string) bool {
+	return w.dispatch.unregister(pattern)
+}
+
+// Replace swaps in a new DispatchFunc for a registered pattern, keeping its
+// precedence, so that no event goes unmatched in between as it would
+// unregistering and registering again.
+func (w *Worker) Replace(pattern string, dispatchFunc DispatchFunc) error {
+	return w.dispatch.replace(pattern, dispatchFunc)
+}
+
+// SetFallback sets the DispatchFunc for events no pattern matches. Nil
+// means they're logged and dropped.
+func (w *Worker) SetFallback(dispatchFunc DispatchFunc) {
+	w.dispatch.setFallback(dispatchFunc)
+}
+
+// duplicate claims the event, reporting whether it's a duplicate because
+// it has already been completed or another copy of it is being handled. If
+// the store can't tell us, we'd rather handle it twice than not at all.
+func (w *Worker) duplicate(logger *log.Entry, event *gmunch.Event) bool {
+	if w.dedupe == nil || event.Id == "" {
+		return false
 	}
 
-	select {
-	case <-w.stopChan:
-		w.stopping = true
-		return w.stopping
-	default:
+	claimed, err := w.dedupe.Begin(event.Id, w.dedupeLease)
+	if err != nil {
+		logger.WithError(err).Error("couldn't check dedupe store")
+		dedupeErrors.With(w.dispatch.label(event.Name)).Inc()
 		return false
 	}
-}
 
-// here's where we have to manage the backpressure
-func (w *Worker) trySubmit(tasks []Task) error {
-	var (
-		err error
-	)
+	if !claimed {
+		logger.Info("skipping duplicate event")
+		duplicateEvents.With(w.dispatch.label(event.Name)).Inc()
+	}
 
-	backoff.Retry(func() error {
-		if w.shouldStop() {
-			return nil
-		}
+	return !claimed
+}
 
-		numTasks := w.scheduler.QueueDepth() + len(tasks)
-		w.logger.Debugf("queue depth: %d max queue depth: %d", numTasks, w.scheduler.MaxQueueDepth)
+// abandon gives up the claim on an event that won't be completed, so that
+// a later copy of it, like a replayed dead letter, can run.
+func (w *Worker) abandon(logger *log.Entry, event *gmunch.Event) {
+	if w.dedupe == nil || event.Id == "" {
+		return
+	}
 
-		if uint(numTasks) > w.scheduler.MaxQueueDepth {
-			w.logger.Error("max queue depth reached")
+	if err := w.dedupe.Abandon(event.Id); err != nil {
+		logger.WithError(err).Error("couldn't abandon event claim")
+		dedupeErrors.With(w.dispatch.label(event.Name)).Inc()
+	}
+}
 
-			// take a breather for the queue to clear so that we can
-			// ensure all of are tasks for one event are submitted together
-			return errMaxQueueDepth
-		}
+func (w *Worker) verify(event *gmunch.Event) error {
+	err := w.keyring.Verify(event)
+	if err == signing.ErrUnsigned && w.signaturePolicy.AllowUnsigned {
+		return nil
+	}
 
-		for _, task := range tasks {
-			w.logger.Debugf("submitting task: %#v", task)
+	return err
+}
 
-			_, err = w.scheduler.Submit(task)
-			if err != nil {
-				w.logger.WithError(err).Error("scheduler submit error")
+// decrypt returns a decrypted copy of the event, leaving the original
+// encrypted so that it can be dead lettered as it arrived.
+func (w *Worker) decrypt(event *gmunch.Event) (*gmunch.Event, error) {
+	if w.encrypter == nil {
+		return nil, errNoEncrypter
+	}
 
-				// how this would happen, nobody can possibly know
-				// is someone stealing our queue????
-				return err
-			}
-		}
+	decrypted := proto.Clone(event).(*gmunch.Event)
+	if err := w.encrypter.Decrypt(decrypted); err != nil {
+		return nil, err
+	}
 
-		return nil
+	return decrypted, nil
+}
 
-	}, &backoff.ExponentialBackOff{
-		InitialInterval:     100 * time.Millisecond,
-		RandomizationFactor: 0.5,
-		Multiplier:          1.5,
-		MaxInterval:         1 * time.Minute,
-		MaxElapsedTime:      60 * time.Minute,
-		Clock:               &systemClock{},
-	})
+// we're not ready for more work if there's no room left in a lane's queue
+func (w *Worker) checkSaturation() error {
+	if lane := w.lanes.full(); lane != "" {
+		return fmt.Errorf("lane %s: %s", lane, errMaxQueueDepth)
+	}
 
-	return err
+	return nil
 }
 
-func (w *Worker) getDispatch(event *gmunch.Event) (DispatchFunc, error) {
-	w.dispatchMut.Lock()
-	defer w.dispatchMut.Unlock()
+// we're dead if we've stopped reading from the consumer, because it closed
+// its channel or failed, without being told to stop. A server runs its
+// worker in the background, so nothing else would notice.
+func (w *Worker) checkConsuming() error {
+	select {
+	case <-w.stopped:
+	default:
+		return nil
+	}
 
-	if dispatchFunc, ok := w.dispatch[event.Name]; ok {
-		return dispatchFunc, nil
+	if w.ctx.Err() != nil {
+		return nil
 	}
 
-	return nil, errNoDispatch
+	return errNotConsuming
 }
 
 type systemClock struct{}
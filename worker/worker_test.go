@@ -0,0 +1,995 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opsee/gmunch"
+	"github.com/opsee/gmunch/cron"
+	"github.com/opsee/gmunch/deadletter"
+	"github.com/opsee/gmunch/dedupe"
+	"github.com/opsee/gmunch/delay"
+	"github.com/opsee/gmunch/envelope"
+	"github.com/opsee/gmunch/metrics"
+	"github.com/opsee/gmunch/signing"
+	"github.com/opsee/gmunch/trace"
+	log "github.com/opsee/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testConsumer struct {
+	events  chan *gmunch.Event
+	stopped chan struct{}
+}
+
+func newTestConsumer() *testConsumer {
+	return &testConsumer{
+		events:  make(chan *gmunch.Event),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (c *testConsumer) Start() error {
+	<-c.stopped
+	return nil
+}
+
+func (c *testConsumer) Stop()                      { close(c.stopped) }
+func (c *testConsumer) Events() chan *gmunch.Event { return c.events }
+
+type testTask struct {
+	ctx     context.Context
+	execute func() (interface{}, error)
+}
+
+func (t *testTask) Context() context.Context {
+	return t.ctx
+}
+
+func (t *testTask) Execute() (interface{}, error) {
+	return t.execute()
+}
+
+func newTestWorker(dispatch Dispatch) (*Worker, chan *EventResult) {
+	results := make(chan *EventResult, 1)
+
+	return New(Config{
+		Consumer: newTestConsumer(),
+		Dispatch: dispatch,
+		MaxJobs:  4,
+		ResultHandler: ResultHandlerFunc(func(result *EventResult) {
+			results <- result
+		}),
+	}), results
+}
+
+func waitResult(t *testing.T, results chan *EventResult) *EventResult {
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event result")
+	}
+
+	return nil
+}
+
+func TestEventResults(t *testing.T) {
+	assert := assert.New(t)
+	taskErr := errors.New("downstream is down")
+
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return "sent", nil }},
+				&testTask{ctx, func() (interface{}, error) { return nil, taskErr }},
+			}
+		},
+		"great": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return "ok", nil }},
+			}
+		},
+	})
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "1"}))
+	result := waitResult(t, results)
+	assert.Equal("1", result.Event.Id)
+	assert.Equal(StatusPartialFailure, result.Status)
+	assert.Len(result.Tasks, 2)
+	assert.Equal("sent", result.Tasks[0].Result)
+	assert.NoError(result.Tasks[0].Err)
+	assert.Equal(taskErr, result.Tasks[1].Err)
+	assert.Equal([]error{taskErr}, result.Errors())
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "great", Id: "2"}))
+	result = waitResult(t, results)
+	assert.Equal(StatusSuccess, result.Status)
+	assert.Equal("ok", result.Tasks[0].Result)
+}
+
+type flakyTask struct {
+	testTask
+	attempts []int
+}
+
+func (t *flakyTask) SetAttempt(attempt int) {
+	t.attempts = append(t.attempts, attempt)
+}
+
+func TestRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		flaky   *flakyTask
+		calls   int
+		permErr = Permanent(errors.New("bad request"))
+	)
+
+	w, results := newTestWorker(Dispatch{
+		"flaky": func(ctx context.Context, event *gmunch.Event) []Task {
+			flaky = &flakyTask{testTask: testTask{ctx, func() (interface{}, error) {
+				calls++
+				if calls < 3 {
+					return nil, errors.New("try again")
+				}
+				return "finally", nil
+			}}}
+			return []Task{flaky}
+		},
+		"broken": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{&testTask{ctx, func() (interface{}, error) { return nil, permErr }}}
+		},
+	})
+	w.retryPolicies = map[string]*RetryPolicy{
+		"flaky":  {MaxAttempts: 3, InitialInterval: time.Millisecond, MaxInterval: time.Millisecond},
+		"broken": {MaxAttempts: 3, InitialInterval: time.Millisecond, MaxInterval: time.Millisecond},
+	}
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "flaky"}))
+	result := waitResult(t, results)
+	assert.Equal(StatusSuccess, result.Status)
+	assert.Equal("finally", result.Tasks[0].Result)
+	assert.Equal(3, result.Tasks[0].Attempts)
+	assert.Equal([]int{1, 2, 3}, flaky.attempts)
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "broken"}))
+	result = waitResult(t, results)
+	assert.Equal(StatusFailure, result.Status)
+	assert.Equal(permErr, result.Tasks[0].Err)
+	assert.Equal(1, result.Tasks[0].Attempts)
+}
+
+func TestDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return nil, errors.New("downstream is down") }},
+			}
+		},
+	})
+	sink := deadletter.NewMemorySink()
+	w.deadLetter = sink
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "1"}))
+	waitResult(t, results)
+
+	letters := sink.Letters()
+	assert.Len(letters, 1)
+	assert.Equal("1", letters[0].Event.Id)
+	assert.Equal([]string{"*worker.testTask"}, letters[0].Tasks)
+	assert.Equal("*worker.testTask: downstream is down", letters[0].Reason)
+}
+
+func TestDecryption(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := envelope.NewStaticKeys("one", map[string][]byte{"one": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypter := envelope.New(envelope.Config{Provider: keys, Names: []string{"cool"}})
+
+	dispatched := make(chan []byte, 1)
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			dispatched <- event.Data
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) {
+					if string(event.Data) == "fail" {
+						return nil, errors.New("nope")
+					}
+					return nil, nil
+				}},
+			}
+		},
+	})
+	sink := deadletter.NewMemorySink()
+	w.deadLetter = sink
+
+	// without an encrypter, encrypted events are rejected
+	event := &gmunch.Event{Name: "cool", Id: "1", Data: []byte("compuper@merkmertin")}
+	assert.NoError(encrypter.Encrypt(event))
+	assert.NoError(w.DispatchEvent(event))
+	assert.Len(sink.Letters(), 1)
+	assert.True(envelope.IsEncrypted(sink.Letters()[0].Event))
+
+	w.encrypter = encrypter
+	assert.NoError(w.DispatchEvent(event))
+	assert.Equal([]byte("compuper@merkmertin"), <-dispatched)
+	waitResult(t, results)
+
+	// tampered events are rejected too
+	event = &gmunch.Event{Name: "cool", Id: "2", Data: []byte("compuper@merkmertin")}
+	assert.NoError(encrypter.Encrypt(event))
+	event.Data[0] ^= 1
+	assert.NoError(w.DispatchEvent(event))
+	assert.Len(sink.Letters(), 2)
+	assert.Equal("2", sink.Letters()[1].Event.Id)
+
+	// failed events are dead lettered as they arrived, not decrypted
+	event = &gmunch.Event{Name: "cool", Id: "3", Data: []byte("fail")}
+	assert.NoError(encrypter.Encrypt(event))
+	ciphertext := append([]byte{}, event.Data...)
+	assert.NoError(w.DispatchEvent(event))
+	assert.Equal([]byte("fail"), <-dispatched)
+	waitResult(t, results)
+	if assert.Len(sink.Letters(), 3) {
+		letter := sink.Letters()[2].Event
+		assert.True(envelope.IsEncrypted(letter))
+		assert.Equal(ciphertext, letter.Data)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	assert := assert.New(t)
+
+	key := signing.NewHMACKey("one", bytes.Repeat([]byte{1}, 32))
+	dispatched := make(chan string, 1)
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			dispatched <- event.Id
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return nil, nil }},
+			}
+		},
+	})
+	sink := deadletter.NewMemorySink()
+	w.deadLetter = sink
+	w.keyring = signing.NewKeyring(key)
+
+	signed := &gmunch.Event{Name: "cool", Id: "signed", Data: []byte("worms")}
+	assert.NoError(signing.Sign(signed, key))
+	assert.NoError(w.DispatchEvent(signed))
+	assert.Equal("signed", <-dispatched)
+	waitResult(t, results)
+
+	// unsigned and tampered events are dead lettered by default
+	unsigned := &gmunch.Event{Name: "cool", Id: "unsigned"}
+	assert.NoError(w.DispatchEvent(unsigned))
+
+	tampered := &gmunch.Event{Name: "cool", Id: "tampered", Data: []byte("worms")}
+	assert.NoError(signing.Sign(tampered, key))
+	tampered.Data = []byte("snakes")
+	assert.NoError(w.DispatchEvent(tampered))
+
+	letters := sink.Drain()
+	assert.Len(letters, 2)
+	assert.Equal(signing.ErrUnsigned.Error(), letters[0].Reason)
+	assert.Equal(signing.ErrBadSignature.Error(), letters[1].Reason)
+
+	// or let through and dropped, depending on policy
+	w.signaturePolicy = signing.Policy{AllowUnsigned: true, OnFailure: signing.Reject}
+	assert.NoError(w.DispatchEvent(unsigned))
+	assert.Equal("unsigned", <-dispatched)
+	waitResult(t, results)
+
+	assert.NoError(w.DispatchEvent(tampered))
+	assert.Empty(sink.Letters())
+}
+
+func TestDedupe(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatched := make(chan string, 10)
+	fail := true
+	unblock := make(chan struct{})
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			dispatched <- event.Id
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return nil, nil }},
+			}
+		},
+		"flaky": func(ctx context.Context, event *gmunch.Event) []Task {
+			dispatched <- event.Id
+			err := errors.New("downstream is down")
+			if !fail {
+				err = nil
+			}
+
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return nil, err }},
+			}
+		},
+		"slow": func(ctx context.Context, event *gmunch.Event) []Task {
+			dispatched <- event.Id
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) {
+					<-unblock
+					return nil, nil
+				}},
+			}
+		},
+	})
+	store := dedupe.NewMemoryStore(10, time.Hour)
+	w.dedupe = store
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "1"}))
+	waitResult(t, results)
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "1"}))
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "2"}))
+	waitResult(t, results)
+
+	// failures aren't recorded, so the event is handled again
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "flaky", Id: "3"}))
+	waitResult(t, results)
+	fail = false
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "flaky", Id: "3"}))
+	waitResult(t, results)
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "flaky", Id: "3"}))
+
+	// a copy that arrives while the first is running is skipped too
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "slow", Id: "4"}))
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "slow", Id: "4"}))
+	close(unblock)
+	waitResult(t, results)
+
+	close(dispatched)
+	ids := []string{}
+	for id := range dispatched {
+		ids = append(ids, id)
+	}
+	assert.Equal([]string{"1", "2", "3", "3", "4"}, ids)
+	assert.Equal(4, store.Len())
+}
+
+func TestOrdering(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(map[string]chan struct{})
+	ran := make(chan string, 10)
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			release[event.Id] = make(chan struct{})
+			wait := release[event.Id]
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) {
+					ran <- event.Id
+					<-wait
+					return nil, nil
+				}},
+			}
+		},
+	})
+	w.sequencer = newSequencer(10)
+	w.partitionKeys = map[string]KeyFunc{
+		"cool": func(event *gmunch.Event) string { return string(event.Data) },
+	}
+
+	for i, customer := range []string{"a", "a", "b", "a"} {
+		assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: fmt.Sprint(i), Data: []byte(customer)}))
+	}
+
+	// a's first event and b's run, a's others wait their turn
+	started := []string{<-ran, <-ran}
+	sort.Strings(started)
+	assert.Equal([]string{"0", "2"}, started)
+
+	close(release["2"])
+	waitResult(t, results)
+	select {
+	case id := <-ran:
+		t.Fatalf("event %s ran out of order", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release["0"])
+	waitResult(t, results)
+	assert.Equal("1", <-ran)
+	close(release["1"])
+	waitResult(t, results)
+	assert.Equal("3", <-ran)
+	close(release["3"])
+	waitResult(t, results)
+
+	// unkeyed events aren't held up
+	w.partitionKeys = nil
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "4"}))
+	assert.Equal("4", <-ran)
+	close(release["4"])
+	waitResult(t, results)
+}
+
+func TestLanes(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(chan struct{})
+	ran := make(chan string, 10)
+	dispatch := func(ctx context.Context, event *gmunch.Event) []Task {
+		return []Task{
+			&testTask{ctx, func() (interface{}, error) {
+				ran <- event.Id
+				if event.Id == "block" {
+					<-release
+				}
+				return nil, nil
+			}},
+		}
+	}
+
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	w := New(Config{
+		Consumer: newTestConsumer(),
+		Dispatch: Dispatch{"bulk": dispatch, "urgent": dispatch},
+		MaxJobs:  1,
+		Logger:   logger,
+		Lanes: []Lane{
+			{Name: "bulk", MaxQueueDepth: 10},
+			{Name: "urgent", Weight: 3, MaxQueueDepth: 10},
+		},
+		EventLanes: map[string]string{"bulk": "bulk", "urgent": "urgent"},
+	})
+
+	// hold the only slot while both lanes fill up
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "bulk", Id: "block"}))
+	assert.Equal("block", <-ran)
+	for i := 0; i < 4; i++ {
+		assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "bulk", Id: fmt.Sprint("b", i)}))
+	}
+	for i := 0; i < 4; i++ {
+		assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "urgent", Id: fmt.Sprint("u", i)}))
+	}
+	assert.Equal(8, w.lanes.queued())
+
+	// urgent gets three turns for each of bulk's
+	close(release)
+	order := []string{}
+	for i := 0; i < 8; i++ {
+		order = append(order, <-ran)
+	}
+	assert.Equal([]string{"u0", "u1", "u2", "b0", "u3", "b1", "b2", "b3"}, order)
+
+	// a lane's MaxJobs caps it even when there are free slots
+	release = make(chan struct{})
+	w = New(Config{
+		Consumer: newTestConsumer(),
+		Dispatch: Dispatch{"bulk": dispatch, "urgent": dispatch},
+		MaxJobs:  2,
+		Logger:   logger,
+		Lanes: []Lane{
+			{Name: "bulk", MaxJobs: 1, MaxQueueDepth: 1},
+		},
+		EventLanes: map[string]string{"bulk": "bulk"},
+	})
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "bulk", Id: "block"}))
+	assert.Equal("block", <-ran)
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "bulk", Id: "b0"}))
+	assert.Equal("bulk", w.lanes.full())
+	assert.Error(w.checkSaturation())
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "urgent", Id: "u0"}))
+	assert.Equal("u0", <-ran)
+	select {
+	case id := <-ran:
+		t.Fatalf("event %s ran past its lane's limit", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal("b0", <-ran)
+}
+
+type contextTask struct {
+	testTask
+	attempt context.Context
+}
+
+func (t *contextTask) SetContext(ctx context.Context) {
+	t.attempt = ctx
+}
+
+func TestTaskSpan(t *testing.T) {
+	assert := assert.New(t)
+
+	var dispatched context.Context
+	var task *contextTask
+	w, results := newTestWorker(Dispatch{
+		"traced": func(ctx context.Context, event *gmunch.Event) []Task {
+			dispatched = ctx
+			task = &contextTask{testTask: testTask{ctx, func() (interface{}, error) { return nil, nil }}}
+			return []Task{task}
+		},
+	})
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "traced"}))
+	waitResult(t, results)
+
+	// the attempt's context carries its own span, in the event's trace
+	parent, ok := trace.SpanContextFromContext(dispatched)
+	assert.True(ok)
+	attempt, ok := trace.SpanContextFromContext(task.attempt)
+	assert.True(ok)
+	assert.Equal(parent.TraceID, attempt.TraceID)
+	assert.NotEqual(parent.SpanID, attempt.SpanID)
+}
+
+func TestTimeoutsAndPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	var running, overlapped int32
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			waiting := &contextTask{testTask: testTask{ctx: ctx}}
+			waiting.execute = func() (interface{}, error) {
+				<-waiting.attempt.Done()
+				return nil, waiting.attempt.Err()
+			}
+
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) {
+					panic("oh no")
+				}},
+				waiting,
+				&testTask{ctx, func() (interface{}, error) {
+					return "fine", nil
+				}},
+			}
+		},
+		"stubborn": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) {
+					if atomic.AddInt32(&running, 1) > 1 {
+						atomic.AddInt32(&overlapped, 1)
+					}
+					time.Sleep(30 * time.Millisecond)
+					atomic.AddInt32(&running, -1)
+					return "late", nil
+				}},
+			}
+		},
+	})
+	w.defaultTaskTimeout = 10 * time.Millisecond
+
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool"}))
+	result := waitResult(t, results)
+	assert.Equal(StatusPartialFailure, result.Status)
+	assert.EqualError(result.Tasks[0].Err, "panic: oh no")
+	assert.Equal(ErrTaskTimeout, result.Tasks[1].Err)
+	assert.Equal("fine", result.Tasks[2].Result)
+	assert.Equal(0, w.InFlight())
+
+	// a task that ignores its context keeps its slot past its timeout, and
+	// isn't retried until it returns
+	w.retryPolicies = map[string]*RetryPolicy{"stubborn": {MaxAttempts: 2, InitialInterval: time.Millisecond}}
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "stubborn"}))
+	time.Sleep(15 * time.Millisecond)
+	assert.Equal(1, w.InFlight())
+
+	result = waitResult(t, results)
+	assert.Equal(ErrTaskTimeout, result.Tasks[0].Err)
+	assert.Equal(2, result.Tasks[0].Attempts)
+	assert.Equal(int32(0), atomic.LoadInt32(&overlapped))
+	assert.Equal(0, w.InFlight())
+}
+
+func TestConsumerLiveness(t *testing.T) {
+	assert := assert.New(t)
+
+	w, _ := newTestWorker(Dispatch{})
+	assert.NoError(w.health.Live()["consumer_loop"])
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- w.Start()
+	}()
+
+	// a consumer that closes its channel leaves the worker with nothing to
+	// do, and restarting it is the only way back
+	consumer := w.consumer.(*testConsumer)
+	close(consumer.events)
+	assert.NoError(<-errChan)
+	assert.Equal(errNotConsuming, w.health.Live()["consumer_loop"])
+
+	// but stopping on purpose isn't a failure
+	w.Stop()
+	assert.NoError(w.health.Live()["consumer_loop"])
+}
+
+func TestStop(t *testing.T) {
+	assert := assert.New(t)
+
+	running := make(chan struct{}, 1)
+	results := make(chan *EventResult, 3)
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	w := New(Config{
+		Consumer: newTestConsumer(),
+		Dispatch: Dispatch{
+			"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+				return []Task{
+					&testTask{ctx, func() (interface{}, error) {
+						running <- struct{}{}
+						<-ctx.Done()
+						return nil, ctx.Err()
+					}},
+				}
+			},
+		},
+		MaxJobs: 1,
+		Logger:  logger,
+		Lanes:   []Lane{{Name: DefaultLane, MaxQueueDepth: 1}},
+		ResultHandler: ResultHandlerFunc(func(result *EventResult) {
+			results <- result
+		}),
+	})
+
+	errChan := make(chan error)
+	go func() {
+		errChan <- w.Start()
+	}()
+
+	consumer := w.consumer.(*testConsumer)
+	consumer.events <- &gmunch.Event{Name: "cool"}
+	<-running
+	assert.Equal(1, w.InFlight())
+
+	// fill the queue behind it, so that the next event blocks dispatch
+	consumer.events <- &gmunch.Event{Name: "cool"}
+	consumer.events <- &gmunch.Event{Name: "cool"}
+	assert.Equal(1, w.QueueDepth())
+
+	// stopping unblocks dispatch, cancels the running task and drops the
+	// queued one, and the tasks that never ran are reported as skipped
+	w.Stop()
+	assert.NoError(<-errChan)
+	skipped := 0
+	for i := 0; i < 3; i++ {
+		result := waitResult(t, results)
+		assert.Equal(StatusFailure, result.Status)
+		assert.Equal(context.Canceled, result.Tasks[0].Err)
+		if result.Tasks[0].Skipped {
+			skipped++
+		}
+	}
+	assert.Equal(2, skipped)
+	assert.Equal(0, w.InFlight())
+	assert.Equal(0, w.QueueDepth())
+}
+
+func TestPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	w, results := newTestWorker(Dispatch{
+		"broken": func(ctx context.Context, event *gmunch.Event) []Task {
+			panic("bad dispatch")
+		},
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { panic("bad task") }},
+			}
+		},
+	})
+	sink := deadletter.NewMemorySink()
+	w.deadLetter = sink
+	w.breaker = newPanicBreaker(2, time.Minute, time.Minute)
+
+	// a panicking dispatch function dead letters the event
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "broken", Id: "1"}))
+	assert.Len(sink.Letters(), 1)
+	assert.Equal("panic: bad dispatch", sink.Letters()[0].Reason)
+
+	// and a panicking task fails like any other
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "2"}))
+	result := waitResult(t, results)
+	assert.Equal(StatusFailure, result.Status)
+	assert.IsType(&PanicError{}, result.Tasks[0].Err)
+	assert.Contains(string(result.Tasks[0].Err.(*PanicError).Stack), "panic")
+	assert.Len(sink.Letters(), 2)
+
+	// the second panic disables the handler, and its events are dead
+	// lettered without being dispatched
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "broken", Id: "3"}))
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "broken", Id: "4"}))
+	letters := sink.Letters()
+	assert.Len(letters, 4)
+	assert.Equal(errDisabled.Error(), letters[3].Reason)
+	assert.True(w.breaker.allow("cool"))
+
+	// until the cooldown has passed
+	w.breaker.disabled["broken"] = time.Now()
+	assert.True(w.breaker.allow("broken"))
+}
+
+func TestBreakers(t *testing.T) {
+	assert := assert.New(t)
+
+	var down int32 = 1
+	w, results := newTestWorker(Dispatch{
+		"cool": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) {
+					if atomic.LoadInt32(&down) == 1 {
+						return nil, errors.New("downstream is down")
+					}
+					return nil, nil
+				}},
+			}
+		},
+	})
+	sink := deadletter.NewMemorySink()
+	w.deadLetter = sink
+	w.circuits = newCircuits(&BreakerConfig{Threshold: 2, Cooldown: 50 * time.Millisecond}, nil, nil)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool"}))
+		assert.Equal(StatusFailure, waitResult(t, results).Status)
+	}
+
+	statuses := w.Breakers()
+	assert.Len(statuses, 1)
+	assert.Equal("event", statuses[0].Type)
+	assert.Equal("cool", statuses[0].Key)
+	assert.Equal("open", statuses[0].State)
+	assert.NotNil(statuses[0].OpenedAt)
+
+	rec := httptest.NewRecorder()
+	w.BreakerHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/breakers", nil))
+	assert.Contains(rec.Body.String(), `"state":"open"`)
+
+	// dispatch pauses until the cooldown is up, and the trial closes it
+	atomic.StoreInt32(&down, 0)
+	start := time.Now()
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool"}))
+	assert.True(time.Since(start) >= 40*time.Millisecond)
+	assert.Equal(StatusSuccess, waitResult(t, results).Status)
+	assert.Equal("closed", w.Breakers()[0].State)
+
+	// a failed trial opens it again
+	atomic.StoreInt32(&down, 1)
+	for i := 0; i < 2; i++ {
+		assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool"}))
+		waitResult(t, results)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool"}))
+	waitResult(t, results)
+	assert.Equal("open", w.Breakers()[0].State)
+
+	// and breakers that dead letter don't pause
+	w.circuits.forEvent("cool").DeadLetter = true
+	before := len(sink.Letters())
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "cool", Id: "shunted"}))
+	letters := sink.Letters()
+	assert.Len(letters, before+1)
+	assert.Equal("shunted", letters[len(letters)-1].Event.Id)
+	assert.Equal("circuit breaker for event cool is open", letters[len(letters)-1].Reason)
+}
+
+func TestBreakerTrials(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newCircuitBreaker("event", "trials", BreakerConfig{Threshold: 1, Cooldown: time.Millisecond})
+	_, early, _, _ := b.allow()
+	b.record(errors.New("down"), nil)
+	assert.Equal(BreakerOpen, b.state)
+
+	time.Sleep(5 * time.Millisecond)
+	ok, trial, _, _ := b.allow()
+	assert.True(ok)
+	assert.Equal(BreakerHalfOpen, b.state)
+
+	// attempts let through before the breaker opened don't count
+	b.record(nil, early)
+	b.record(errors.New("down"), nil)
+	assert.Equal(BreakerHalfOpen, b.state)
+
+	// a trial counts once its event is done
+	b.record(nil, trial)
+	assert.Equal(BreakerHalfOpen, b.state)
+	trial.done()
+	assert.Equal(BreakerClosed, b.state)
+
+	// nor do trials from before it closed, once it's half-open again
+	b.record(errors.New("down"), nil)
+	time.Sleep(5 * time.Millisecond)
+	b.allow()
+	b.record(nil, trial)
+	trial.done()
+	assert.Equal(BreakerHalfOpen, b.state)
+
+	// an event with several successful tasks is still one trial event
+	b = newCircuitBreaker("event", "events", BreakerConfig{Threshold: 1, Cooldown: time.Millisecond, Trials: 2})
+	b.record(errors.New("down"), nil)
+	time.Sleep(5 * time.Millisecond)
+	_, first, _, _ := b.allow()
+	for i := 0; i < 3; i++ {
+		b.record(nil, first)
+	}
+	first.done()
+	assert.Equal(BreakerHalfOpen, b.state)
+
+	_, second, _, _ := b.allow()
+	b.record(nil, second)
+	second.done()
+	assert.Equal(BreakerClosed, b.state)
+}
+
+func TestDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	w, results := newTestWorker(Dispatch{
+		"trial_expired": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return nil, nil }},
+			}
+		},
+	})
+	store := delay.NewMemoryStore()
+	w.timer = delay.NewTimer(store, w.DispatchEvent, 10*time.Millisecond, w.logger.Logger)
+
+	// untrusted events are turned away before they're stored
+	key := signing.NewHMACKey("one", bytes.Repeat([]byte{1}, 32))
+	w.keyring = signing.NewKeyring(key)
+	unsigned := &gmunch.Event{Name: "trial_expired", Id: "unsigned"}
+	unsigned.SetDeliveryTime(time.Now().Add(time.Hour))
+	assert.NoError(w.DispatchEvent(unsigned))
+	assert.Equal(0, store.Len())
+
+	event := &gmunch.Event{Name: "trial_expired", Id: "1"}
+	deliverAt := time.Now().Add(50 * time.Millisecond)
+	event.SetDeliveryTime(deliverAt)
+	assert.NoError(signing.Sign(event, key))
+	assert.NoError(w.DispatchEvent(event))
+	assert.Equal(1, store.Len())
+
+	w.timer.Start()
+	result := waitResult(t, results)
+	assert.Equal("1", result.Event.Id)
+	assert.False(time.Now().Before(deliverAt.Truncate(time.Millisecond)))
+
+	// stopping waits for the delivery to finish
+	w.timer.Stop()
+	assert.Equal(0, store.Len())
+}
+
+func TestCron(t *testing.T) {
+	assert := assert.New(t)
+
+	w, results := newTestWorker(Dispatch{
+		"send_report": func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return nil, nil }},
+			}
+		},
+	})
+
+	// the jobs' own events are trusted, even though they aren't signed
+	sink := deadletter.NewMemorySink()
+	w.deadLetter = sink
+	w.keyring = signing.NewKeyring(signing.NewHMACKey("one", bytes.Repeat([]byte{1}, 32)))
+
+	// the jobs last ran a while ago, so they catch up right away
+	lastRun := time.Now().Add(-time.Hour)
+	state := cron.NewMemoryState()
+	state.SetLastRun("report", lastRun)
+	state.SetLastRun("orphan", lastRun)
+
+	scheduler, err := cron.New([]cron.Job{
+		{Name: "report", Schedule: "@every 10m", Event: "send_report", CatchUp: cron.Latest},
+		{Name: "orphan", Schedule: "@every 10m", Event: "unhandled", CatchUp: cron.Latest},
+	}, cron.Config{State: state, Interval: 10 * time.Millisecond, Grace: time.Millisecond, Logger: w.logger.Logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.cron = scheduler
+	w.cron.Start(w.emit)
+	defer w.cron.Stop()
+
+	result := waitResult(t, results)
+	assert.Equal("send_report", result.Event.Name)
+	assert.Equal("report", result.Event.Header(cron.HeaderJob))
+	assert.Len(results, 0)
+
+	// a tick that's turned away is fired again rather than dead lettered
+	time.Sleep(30 * time.Millisecond)
+	last, _ := state.LastRun("orphan")
+	assert.True(lastRun.Equal(last))
+	assert.Len(sink.Letters(), 0)
+}
+
+func TestDispatchPatterns(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := func(label string) DispatchFunc {
+		return func(ctx context.Context, event *gmunch.Event) []Task {
+			return []Task{
+				&testTask{ctx, func() (interface{}, error) { return label, nil }},
+			}
+		}
+	}
+
+	w, results := newTestWorker(Dispatch{
+		"user_created":     handler("exact"),
+		"user_*":           handler("prefix"),
+		"user_billing_*":   handler("longer prefix"),
+		"*_deleted":        handler("glob"),
+		"re:^team_[0-9]+$": handler("regex"),
+		"re:(":             handler("bad regex"),
+		"bad[":             handler("bad glob"),
+	})
+
+	dispatched := func(name string) interface{} {
+		assert.NoError(w.DispatchEvent(&gmunch.Event{Name: name}))
+		result := waitResult(t, results)
+		if !assert.Len(result.Tasks, 1, name) {
+			return nil
+		}
+		return result.Tasks[0].Result
+	}
+
+	assert.Equal("exact", dispatched("user_created"))
+	assert.Equal("prefix", dispatched("user_updated"))
+	assert.Equal("longer prefix", dispatched("user_billing_failed"))
+	assert.Equal("glob", dispatched("account_deleted"))
+	assert.Equal("regex", dispatched("team_42"))
+
+	// metrics are labelled by what the event matched, so that made up
+	// names can't add series without bound
+	assert.Equal("user_created", w.dispatch.label("user_created"))
+	assert.Equal("user_*", w.dispatch.label("user_updated"))
+	assert.Equal("re:^team_[0-9]+$", w.dispatch.label("team_42"))
+	assert.Equal(metrics.UnknownEvent, w.dispatch.label("team_x"))
+
+	// unmatched events are dropped until there's a fallback
+	assert.NoError(w.DispatchEvent(&gmunch.Event{Name: "team_x"}))
+	assert.Len(results, 0)
+	w.SetFallback(handler("fallback"))
+	assert.Equal("fallback", dispatched("team_x"))
+
+	assert.Error(w.Register("user_*", handler("again")))
+	assert.Error(w.Register("re:[", handler("bad")))
+	assert.Error(w.Replace("nobody_*", handler("missing")))
+
+	assert.NoError(w.Replace("user_*", handler("replaced")))
+	assert.Equal("replaced", dispatched("user_updated"))
+
+	assert.True(w.Unregister("user_billing_*"))
+	assert.False(w.Unregister("user_billing_*"))
+	assert.Equal("replaced", dispatched("user_billing_failed"))
+
+	assert.NoError(w.Register("team_x", handler("registered")))
+	assert.Equal("registered", dispatched("team_x"))
+
+	// overlapping patterns resolve in registration order, so one that's
+	// registered later loses even though its name sorts first
+	assert.NoError(w.Register("re:^team_.*_deleted$", handler("team regex")))
+	assert.Equal("glob", dispatched("team_a_deleted"))
+	assert.True(w.Unregister("*_deleted"))
+	assert.NoError(w.Register("*_deleted", handler("glob again")))
+	assert.Equal("team regex", dispatched("team_a_deleted"))
+	assert.Equal("glob again", dispatched("account_deleted"))
+}